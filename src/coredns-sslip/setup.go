@@ -0,0 +1,146 @@
+package sslip
+
+import (
+	"fmt"
+	"strings"
+	"xip/xip"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+)
+
+func init() { plugin.Register("sslip", setup) }
+
+func setup(c *caddy.Controller) error {
+	s, err := parse(c)
+	if err != nil {
+		return plugin.Error("sslip", err)
+	}
+	c.OnShutdown(func() error {
+		s.Xip.Close()
+		return nil
+	})
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		s.Next = next
+		return s
+	})
+	return nil
+}
+
+// parse parses the Corefile stanza:
+//
+//	sslip [ZONES...] {
+//	    blocklist URL
+//	    nameservers NAME...
+//	    addresses HOST=IP...
+//	}
+func parse(c *caddy.Controller) (s Sslip, err error) {
+	var blocklistURL string
+	var nameServers, addresses []string
+	i := 0
+	for c.Next() {
+		if i > 0 {
+			return s, plugin.ErrOnce
+		}
+		i++
+		s.Zones = plugin.OriginsFromArgsOrServerBlock(c.RemainingArgs(), c.ServerBlockKeys)
+		for c.NextBlock() {
+			switch c.Val() {
+			case "blocklist":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return s, c.ArgErr()
+				}
+				blocklistURL = args[0]
+			case "nameservers":
+				if nameServers = c.RemainingArgs(); len(nameServers) == 0 {
+					return s, c.ArgErr()
+				}
+			case "addresses":
+				if addresses = c.RemainingArgs(); len(addresses) == 0 {
+					return s, c.ArgErr()
+				}
+			default:
+				return s, c.Errf("unknown property '%s'", c.Val())
+			}
+		}
+	}
+	s.Xip, err = newXip(s.Zones, blocklistURL, nameServers, addresses)
+	return s, err
+}
+
+// newXip returns a Xip whose tenants are the zones, so that the zones have our
+// nameservers, and each has its own special TXT names, e.g.
+// "ip.example.internal.". The PTR records of the reverse zones (e.g.
+// "10.in-addr.arpa.") point into the first forward zone, e.g.
+// "10-0-0-1.example.internal.".
+func newXip(zones []string, blocklistURL string, nameServers []string, addresses []string) (*xip.Xip, error) {
+	var ptrDomain string
+	for _, zone := range zones {
+		if zone != "." && !strings.HasSuffix(zone, ".arpa.") {
+			ptrDomain = zone
+			break
+		}
+	}
+	var tenantConfigs []xip.TenantConfig
+	everyZone := false
+	for _, zone := range zones {
+		if zone == "." {
+			everyZone = true // we needn't make it a tenant; its names are sslip.io's
+			continue
+		}
+		tenantConfig := xip.TenantConfig{Apex: zone, NameServers: nameServers, PTRDomain: ptrDomain}
+		if !strings.HasSuffix(zone, ".arpa.") {
+			tenantConfig.Customizations = specialTXTs(zone)
+		}
+		tenantConfigs = append(tenantConfigs, tenantConfig)
+	}
+	for _, address := range addresses {
+		host, _, _ := strings.Cut(address, "=")
+		host = strings.ToLower(strings.TrimSuffix(host, ".") + ".")
+		zone := plugin.Zones(zones).Matches(host)
+		if zone == "" || zone == "." {
+			return nil, fmt.Errorf(`address "%s" isn't within any of the zones %v`, address, zones)
+		}
+		for j := range tenantConfigs {
+			if tenantConfigs[j].Apex == zone {
+				tenantConfigs[j].Addresses = append(tenantConfigs[j].Addresses, address)
+			}
+		}
+	}
+	for _, tenantConfig := range tenantConfigs {
+		if err := tenantConfig.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	x, logmessages := xip.NewXip(blocklistURL, nameServers, nil)
+	if _, _, blocklistUpdated := x.Blocklist(); blocklistURL != "" && blocklistUpdated.IsZero() {
+		x.Close()
+		return nil, fmt.Errorf("blocklist: %s", logmessages[0])
+	}
+	logmessages = append(logmessages, x.SetTenants(tenantConfigs)...)
+	if !everyZone {
+		logmessages = append(logmessages, x.SetZones(zones, false)...)
+	}
+	for _, logmessage := range logmessages {
+		log.Debug(logmessage)
+	}
+	return x, nil
+}
+
+// specialTXTs are sslip.io's special TXT names (e.g. "ip.sslip.io.", whose TXT
+// record is the querier's IP address) in zone, e.g. "ip.example.internal."
+func specialTXTs(zone string) xip.DomainCustomizations {
+	customizations := xip.DomainCustomizations{}
+	for host, customization := range xip.DefaultCustomizations() {
+		if customization.TXT == nil {
+			continue
+		}
+		if label, ok := strings.CutSuffix(host, "sslip.io."); ok && label != "" {
+			customizations[label+zone] = xip.DomainCustomization{TXT: customization.TXT}
+		}
+	}
+	return customizations
+}
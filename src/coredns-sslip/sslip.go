@@ -0,0 +1,58 @@
+// Package sslip is a CoreDNS plugin that answers like sslip.io, e.g.
+// "10-0-0-1.example.internal" resolves to 10.0.0.1, so that a cluster which
+// already runs CoreDNS needn't run the sslip.io DNS server, too. It passes the
+// queries to the xip package, so it answers the same records: the IP
+// addresses embedded in the names, PTR, the blocklist, and the special TXT
+// names, e.g. "ip.example.internal".
+package sslip
+
+import (
+	"context"
+	"net"
+	"xip/xip"
+
+	"github.com/coredns/coredns/plugin"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+var log = clog.NewWithPlugin("sslip")
+
+// Sslip is the plugin.Handler. It answers the queries for names within Zones
+// and passes the rest to Next.
+type Sslip struct {
+	Next  plugin.Handler
+	Zones []string // e.g. "example.internal.", "10.in-addr.arpa."
+	Xip   *xip.Xip
+}
+
+// ServeDNS implements the plugin.Handler interface
+func (s Sslip) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	state := request.Request{W: w, Req: r}
+	if plugin.Zones(s.Zones).Matches(state.Name()) == "" {
+		return plugin.NextOrFailure(s.Name(), s.Next, ctx, w, r)
+	}
+	queryBytes, err := r.Pack()
+	if err != nil {
+		return dns.RcodeServerFailure, err
+	}
+	// CoreDNS truncates the UDP responses that are too big, so we needn't
+	responseBytes, logMessage, err := s.Xip.QueryResponseContext(ctx, queryBytes, net.ParseIP(state.IP()))
+	if err != nil {
+		return dns.RcodeServerFailure, err
+	}
+	response := new(dns.Msg)
+	if err = response.Unpack(responseBytes); err != nil {
+		return dns.RcodeServerFailure, err
+	}
+	log.Debug(state.IP() + ": " + logMessage)
+	if err = w.WriteMsg(response); err != nil {
+		return dns.RcodeServerFailure, err
+	}
+	// we've written the response, whatever its rcode, so CoreDNS mustn't write another
+	return dns.RcodeSuccess, nil
+}
+
+// Name implements the plugin.Handler interface
+func (s Sslip) Name() string { return "sslip" }
@@ -0,0 +1,13 @@
+package sslip_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestSslip(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Sslip Suite")
+}
@@ -0,0 +1,142 @@
+package sslip_test
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	_ "github.com/coredns/coredns/plugin/whoami"
+	"github.com/miekg/dns"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	_ "github.com/cunnie/sslip.io/src/coredns-sslip"
+)
+
+// corefile is a caddy.Input, i.e. a Corefile that isn't a file
+type corefile string
+
+func (c corefile) Body() []byte       { return []byte(c) }
+func (c corefile) Path() string       { return "Corefile" }
+func (c corefile) ServerType() string { return "dns" }
+
+var _ = BeforeSuite(func() {
+	caddy.Quiet = true
+	dnsserver.Quiet = true
+	// a CoreDNS built with the plugin has it in plugin.cfg; we put it before "whoami"
+	for i, directive := range dnsserver.Directives {
+		if directive == "whoami" {
+			dnsserver.Directives = append(dnsserver.Directives[:i], append([]string{"sslip"}, dnsserver.Directives[i:]...)...)
+			break
+		}
+	}
+})
+
+var _ = Describe("the sslip CoreDNS plugin", func() {
+	var instance *caddy.Instance
+	var udpAddr, tcpAddr string
+	var err error
+
+	start := func(contents string) (*caddy.Instance, error) {
+		return caddy.Start(corefile(contents))
+	}
+	query := func(network string, name string, qType uint16) *dns.Msg {
+		client := dns.Client{Net: network}
+		addr := udpAddr
+		if network == "tcp" {
+			addr = tcpAddr
+		}
+		response, _, err := client.Exchange(new(dns.Msg).SetQuestion(name, qType), addr)
+		Expect(err).ToNot(HaveOccurred())
+		return response
+	}
+	answers := func(response *dns.Msg) (answers []string) {
+		for _, rr := range response.Answer {
+			switch rr := rr.(type) {
+			case *dns.A:
+				answers = append(answers, rr.A.String())
+			case *dns.PTR:
+				answers = append(answers, rr.Ptr)
+			case *dns.TXT:
+				answers = append(answers, rr.Txt...)
+			}
+		}
+		return answers
+	}
+
+	When("the Corefile is valid", func() {
+		BeforeEach(func() {
+			instance, err = start(`
+.:0 {
+	sslip example.internal 10.0.0.0/8 {
+		blocklist file://../../etc/blocklist.txt
+		nameservers ns1.example.internal.
+		addresses www.example.internal=10.9.9.9
+	}
+	whoami
+}
+`)
+			Expect(err).ToNot(HaveOccurred())
+			// it listens on every interface; we query it on 127.0.0.1
+			udpAddr = net.JoinHostPort("127.0.0.1", strconv.Itoa(instance.Servers()[0].LocalAddr().(*net.UDPAddr).Port))
+			tcpAddr = net.JoinHostPort("127.0.0.1", strconv.Itoa(instance.Servers()[0].Addr().(*net.TCPAddr).Port))
+		})
+		AfterEach(func() {
+			Expect(instance.Stop()).To(Succeed())
+		})
+		It("answers with the IP address embedded in the name, over UDP & TCP", func() {
+			response := query("udp", "10-0-0-1.example.internal.", dns.TypeA)
+			Expect(response.Authoritative).To(BeTrue())
+			Expect(answers(response)).To(Equal([]string{"10.0.0.1"}))
+			Expect(answers(query("tcp", "www.10.1.2.3.example.internal.", dns.TypeA))).To(Equal([]string{"10.1.2.3"}))
+		})
+		It("answers the addresses", func() {
+			Expect(answers(query("udp", "www.example.internal.", dns.TypeA))).To(Equal([]string{"10.9.9.9"}))
+		})
+		It("answers PTR queries with names in the zone", func() {
+			Expect(answers(query("udp", "1.0.0.10.in-addr.arpa.", dns.TypePTR))).To(Equal([]string{"10-0-0-1.example.internal."}))
+		})
+		It("answers the special TXT names in the zone", func() {
+			Expect(answers(query("udp", "ip.example.internal.", dns.TypeTXT))).To(Equal([]string{"127.0.0.1"}))
+		})
+		It("has the zone's nameservers", func() {
+			response := query("udp", "example.internal.", dns.TypeNS)
+			Expect(response.Answer).To(HaveLen(1))
+			Expect(response.Answer[0].(*dns.NS).Ns).To(Equal("ns1.example.internal."))
+		})
+		It("doesn't resolve blocked names to their embedded IP address", func() {
+			response := query("udp", "raiffeisen.94-0-0-1.example.internal.", dns.TypeA)
+			Expect(response.Answer).To(BeEmpty())
+			Expect(response.Rcode).To(Equal(dns.RcodeSuccess))
+		})
+		It("answers NXDOMAIN for names without records", func() {
+			response := query("udp", "no-ip.example.internal.", dns.TypeA)
+			Expect(response.Rcode).To(Equal(dns.RcodeNameError))
+			Expect(response.Ns).To(HaveLen(1))
+		})
+		It("passes the names outside its zones to the next plugin", func() {
+			response := query("udp", "example.com.", dns.TypeA)
+			Expect(response.Answer).To(BeEmpty())
+			Expect(response.Extra).ToNot(BeEmpty()) // whoami's
+		})
+	})
+	When("the Corefile is invalid", func() {
+		It("refuses unknown properties", func() {
+			_, err = start(".:0 {\n\tsslip example.internal {\n\t\tblocklists file:///dev/null\n\t}\n}\n")
+			Expect(err).To(MatchError(ContainSubstring("unknown property 'blocklists'")))
+		})
+		It("refuses addresses outside its zones", func() {
+			_, err = start(".:0 {\n\tsslip example.internal {\n\t\taddresses www.example.com=10.0.0.1\n\t}\n}\n")
+			Expect(err).To(MatchError(ContainSubstring(`address "www.example.com=10.0.0.1" isn't within any of the zones`)))
+		})
+		It("refuses invalid addresses", func() {
+			_, err = start(".:0 {\n\tsslip example.internal {\n\t\taddresses www.example.internal=not-an-ip\n\t}\n}\n")
+			Expect(err).To(MatchError(ContainSubstring("not-an-ip")))
+		})
+		It("refuses a blocklist it can't read", func() {
+			_, err = start(".:0 {\n\tsslip example.internal {\n\t\tblocklist file:///non-existent\n\t}\n}\n")
+			Expect(err).To(MatchError(ContainSubstring(`failed to open blocklist "/non-existent"`)))
+		})
+	})
+})
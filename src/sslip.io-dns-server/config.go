@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"xip/xip"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"gopkg.in/yaml.v3"
+)
+
+// config is the schema of the -config file, which may be YAML or JSON (JSON
+// being YAML, too). Every setting is optional and defaults to its flag's
+// default; a flag on the command line overrides the setting. Unknown settings
+// are errors, so that a misspelled setting isn't silently ignored.
+type config struct {
+	BlocklistURL *string         `yaml:"blocklist_url"` // -blocklistURL
+	Nameservers  []string        `yaml:"nameservers"`   // -nameservers
+	Addresses    []string        `yaml:"addresses"`     // -addresses, e.g. "ns.example.com=10.0.0.1"
+	Zones        []string        `yaml:"zones"`         // -zones
+	OutOfZone    *string         `yaml:"out_of_zone"`   // -out-of-zone
+	ZoneFile     *string         `yaml:"zonefile"`      // -zonefile
+	Tenants      *string         `yaml:"tenants"`       // -tenants
+	Listeners    configListeners `yaml:"listeners"`
+	SOA          configSOA       `yaml:"soa"`
+	TTL          configTTL       `yaml:"ttl"`
+	Privileges   configPrivilege `yaml:"privileges"`
+	Logging      configLogging   `yaml:"logging"`
+	// ShutdownTimeout is a duration, e.g. "20s"
+	ShutdownTimeout *string `yaml:"shutdown_timeout"` // -shutdown-timeout
+}
+
+type configListeners struct {
+	Port          *int                `yaml:"port"`      // -port
+	Listen        []string            `yaml:"listen"`    // -listen, e.g. "udp://10.0.0.5:53"
+	IPv4Only      *bool               `yaml:"ipv4_only"` // -ipv4-only
+	IPv6Only      *bool               `yaml:"ipv6_only"` // -ipv6-only
+	Sockets       *int                `yaml:"sockets"`   // -sockets
+	TLS           configTLS           `yaml:"tls"`
+	TCP           configTCP           `yaml:"tcp"`
+	UDP           configUDP           `yaml:"udp"`
+	ProxyProtocol configProxyProtocol `yaml:"proxy_protocol"`
+}
+
+type configTLS struct {
+	Cert    *string `yaml:"cert"`     // -tls-cert
+	Key     *string `yaml:"key"`      // -tls-key
+	Port    *int    `yaml:"port"`     // -tls-port
+	DoHPort *int    `yaml:"doh_port"` // -doh-port
+}
+
+type configTCP struct {
+	IdleTimeout    *string `yaml:"idle_timeout"`    // -tcp-idle-timeout, e.g. "10s"
+	ReadTimeout    *string `yaml:"read_timeout"`    // -tcp-read-timeout, e.g. "5s"
+	MaxConnections *int    `yaml:"max_connections"` // -tcp-max-connections
+}
+
+type configUDP struct {
+	Workers   *int    `yaml:"workers"`    // -udp-workers
+	QueueSize *int    `yaml:"queue_size"` // -udp-queue-size
+	Overflow  *string `yaml:"overflow"`   // -udp-overflow
+	BatchSize *int    `yaml:"batch_size"` // -udp-batch-size
+}
+
+type configProxyProtocol struct {
+	Enabled *bool    `yaml:"enabled"` // -proxy-protocol
+	Trusted []string `yaml:"trusted"` // -proxy-protocol-trusted, e.g. "10.0.0.0/8"
+}
+
+type configSOA struct {
+	MName   *string `yaml:"mname"`   // -soa-mname
+	MBox    *string `yaml:"mbox"`    // -soa-mbox
+	Serial  *uint32 `yaml:"serial"`  // -soa-serial
+	Refresh *uint32 `yaml:"refresh"` // -soa-refresh
+	Retry   *uint32 `yaml:"retry"`   // -soa-retry
+	Expire  *uint32 `yaml:"expire"`  // -soa-expire
+	Minimum *uint32 `yaml:"minimum"` // -soa-minimum
+}
+
+type configTTL struct {
+	EmbeddedIP    *uint32 `yaml:"embedded_ip"`   // -ttl-embedded-ip
+	Customization *uint32 `yaml:"customization"` // -ttl-customization
+	TXT           *uint32 `yaml:"txt"`           // -ttl-txt
+	NS            *uint32 `yaml:"ns"`            // -ttl-ns
+	SOA           *uint32 `yaml:"soa"`           // -ttl-soa
+	Negative      *uint32 `yaml:"negative"`      // -ttl-negative
+	Blocked       *uint32 `yaml:"blocked"`       // -ttl-blocked
+}
+
+type configPrivilege struct {
+	User   *string `yaml:"user"`   // -user
+	Group  *string `yaml:"group"`  // -group
+	Chroot *string `yaml:"chroot"` // -chroot
+}
+
+type configLogging struct {
+	Quiet *bool `yaml:"quiet"` // -quiet
+}
+
+// configSetting is the value(s) of the flag that a config setting sets; a
+// repeatable flag (-listen) has several
+type configSetting struct {
+	flag   string
+	values []string
+}
+
+// reloadableFlags are the flags that we reload on SIGHUP (see reload()); we
+// read the others once, when we start
+var reloadableFlags = []string{"blocklistURL", "nameservers", "addresses", "zones", "out-of-zone", "zonefile", "tenants"}
+
+// flagsSetOnCommandLine returns the names of the flags set on the command
+// line, which override the -config file's settings; call it before
+// loadConfig(), which sets flags, too
+func flagsSetOnCommandLine() map[string]bool {
+	setOnCommandLine := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		setOnCommandLine[f.Name] = true
+	})
+	return setOnCommandLine
+}
+
+// loadConfig reads the -config file, validates it, and sets the flags that
+// weren't set on the command line. It returns every error it finds, not
+// merely the first.
+func loadConfig(path string, setOnCommandLine map[string]bool) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	c, err := parseConfig(contents)
+	if err != nil {
+		return fmt.Errorf(`"%s": %w`, path, err)
+	}
+	var errs []error
+	for _, setting := range c.settings() {
+		if setOnCommandLine[setting.flag] {
+			continue // the command line overrides the config file
+		}
+		for _, value := range setting.values {
+			if err = flag.Set(setting.flag, value); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", setting.flag, err))
+			}
+		}
+	}
+	if err = errors.Join(errs...); err != nil {
+		return fmt.Errorf(`"%s": %w`, path, err)
+	}
+	return nil
+}
+
+// reloadConfig returns the values that the reloadableFlags have now: those set
+// on the command line keep theirs; the others have the -config file's settings,
+// which it re-reads, or, if it no longer has them, their defaults. Without
+// -config (path is ""), they keep their values.
+func reloadConfig(path string, setOnCommandLine map[string]bool) (values map[string]string, err error) {
+	values = map[string]string{}
+	for _, flagName := range reloadableFlags {
+		f := flag.Lookup(flagName)
+		values[flagName] = f.Value.String()
+		if path != "" && !setOnCommandLine[flagName] {
+			values[flagName] = f.DefValue
+		}
+	}
+	if path == "" {
+		return values, nil
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseConfig(contents)
+	if err != nil {
+		return nil, fmt.Errorf(`"%s": %w`, path, err)
+	}
+	for _, setting := range c.settings() {
+		if _, reloadable := values[setting.flag]; reloadable && !setOnCommandLine[setting.flag] {
+			values[setting.flag] = setting.values[0]
+		}
+	}
+	return values, nil
+}
+
+// parseConfig strictly decodes & validates a config file's contents
+func parseConfig(contents []byte) (c config, err error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(&c); err != nil && err != io.EOF { // io.EOF means an empty file, which is valid
+		return c, err
+	}
+	return c, c.validate()
+}
+
+// validate checks the settings that the flags themselves don't, e.g. the
+// flags skip (and log) an invalid nameserver, but a config file mustn't have one
+func (c config) validate() error {
+	var errs []error
+	if c.BlocklistURL != nil && *c.BlocklistURL != "" {
+		if u, err := url.Parse(*c.BlocklistURL); err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "file") {
+			errs = append(errs, fmt.Errorf(`blocklist_url: "%s" must be an "http://", "https://", or "file://" URL`, *c.BlocklistURL))
+		}
+	}
+	for i, nameserver := range c.Nameservers {
+		if err := validateConfigName(nameserver); err != nil {
+			errs = append(errs, fmt.Errorf("nameservers[%d]: %w", i, err))
+		}
+	}
+	for i, address := range c.Addresses {
+		host, ip, found := strings.Cut(address, "=")
+		if !found {
+			errs = append(errs, fmt.Errorf(`addresses[%d]: "%s" must be in the format "host=ip"`, i, address))
+			continue
+		}
+		if err := validateConfigName(host); err != nil {
+			errs = append(errs, fmt.Errorf("addresses[%d]: %w", i, err))
+		}
+		if net.ParseIP(ip) == nil {
+			errs = append(errs, fmt.Errorf(`addresses[%d]: "%s" isn't an IP address`, i, ip))
+		}
+	}
+	for i, zone := range c.Zones {
+		if err := validateConfigName(zone); err != nil {
+			errs = append(errs, fmt.Errorf("zones[%d]: %w", i, err))
+		}
+	}
+	if c.OutOfZone != nil && *c.OutOfZone != "refuse" && *c.OutOfZone != "answer" {
+		errs = append(errs, fmt.Errorf(`out_of_zone: must be "refuse" or "answer", not "%s"`, *c.OutOfZone))
+	}
+	if c.Listeners.UDP.Overflow != nil && *c.Listeners.UDP.Overflow != "drop" && *c.Listeners.UDP.Overflow != "refuse" {
+		errs = append(errs, fmt.Errorf(`listeners.udp.overflow: must be "drop" or "refuse", not "%s"`, *c.Listeners.UDP.Overflow))
+	}
+	for i, listen := range c.Listeners.Listen {
+		if _, err := parseListenAddress(listen); err != nil {
+			errs = append(errs, fmt.Errorf("listeners.listen[%d]: %w", i, err))
+		}
+	}
+	for name, port := range map[string]*int{"listeners.port": c.Listeners.Port, "listeners.tls.port": c.Listeners.TLS.Port, "listeners.tls.doh_port": c.Listeners.TLS.DoHPort} {
+		if port != nil && (*port < 0 || *port > 65535) {
+			errs = append(errs, fmt.Errorf("%s: must be between 0 and 65535, not %d", name, *port))
+		}
+	}
+	for i, cidr := range c.Listeners.ProxyProtocol.Trusted {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			errs = append(errs, fmt.Errorf(`listeners.proxy_protocol.trusted[%d]: "%s" isn't a CIDR`, i, cidr))
+		}
+	}
+	for name, duration := range map[string]*string{"listeners.tcp.idle_timeout": c.Listeners.TCP.IdleTimeout, "listeners.tcp.read_timeout": c.Listeners.TCP.ReadTimeout, "shutdown_timeout": c.ShutdownTimeout} {
+		if duration != nil {
+			if _, err := time.ParseDuration(*duration); err != nil {
+				errs = append(errs, fmt.Errorf(`%s: "%s" isn't a duration, e.g. "10s"`, name, *duration))
+			}
+		}
+	}
+	if c.SOA.MBox != nil {
+		if err := validateConfigName(*c.SOA.MBox); err != nil {
+			errs = append(errs, fmt.Errorf("soa.mbox: %w", err))
+		}
+	}
+	if c.SOA.MName != nil && *c.SOA.MName != "" {
+		if err := validateConfigName(*c.SOA.MName); err != nil {
+			errs = append(errs, fmt.Errorf("soa.mname: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateConfigName checks that a name is a valid domain name, e.g. "ns.example.com."
+func validateConfigName(name string) error {
+	if strings.Contains(name, ",") {
+		return fmt.Errorf(`"%s" mustn't contain ","`, name)
+	}
+	absolute := strings.TrimSuffix(name, ".") + "."
+	for _, label := range strings.Split(strings.TrimSuffix(absolute, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return fmt.Errorf(`"%s" isn't a valid name: each label must be 1 to 63 characters long`, name)
+		}
+	}
+	if _, err := dnsmessage.NewName(absolute); err != nil {
+		return fmt.Errorf(`"%s" isn't a valid name: %w`, name, err)
+	}
+	return nil
+}
+
+// settings returns the flags that the config file sets
+func (c config) settings() (settings []configSetting) {
+	add := func(flagName string, value interface{}) {
+		switch v := value.(type) {
+		case *string:
+			if v != nil {
+				settings = append(settings, configSetting{flagName, []string{*v}})
+			}
+		case *int:
+			if v != nil {
+				settings = append(settings, configSetting{flagName, []string{fmt.Sprint(*v)}})
+			}
+		case *uint32:
+			if v != nil {
+				settings = append(settings, configSetting{flagName, []string{fmt.Sprint(*v)}})
+			}
+		case *bool:
+			if v != nil {
+				settings = append(settings, configSetting{flagName, []string{fmt.Sprint(*v)}})
+			}
+		case []string: // a comma-separated flag, e.g. -nameservers
+			if v != nil {
+				settings = append(settings, configSetting{flagName, []string{strings.Join(v, ",")}})
+			}
+		}
+	}
+	add("blocklistURL", c.BlocklistURL)
+	add("nameservers", c.Nameservers)
+	add("addresses", c.Addresses)
+	add("zones", c.Zones)
+	add("out-of-zone", c.OutOfZone)
+	add("zonefile", c.ZoneFile)
+	add("tenants", c.Tenants)
+	add("port", c.Listeners.Port)
+	if c.Listeners.Listen != nil {
+		settings = append(settings, configSetting{"listen", c.Listeners.Listen}) // -listen is repeated, not comma-separated
+	}
+	add("ipv4-only", c.Listeners.IPv4Only)
+	add("ipv6-only", c.Listeners.IPv6Only)
+	add("sockets", c.Listeners.Sockets)
+	add("tls-cert", c.Listeners.TLS.Cert)
+	add("tls-key", c.Listeners.TLS.Key)
+	add("tls-port", c.Listeners.TLS.Port)
+	add("doh-port", c.Listeners.TLS.DoHPort)
+	add("tcp-idle-timeout", c.Listeners.TCP.IdleTimeout)
+	add("tcp-read-timeout", c.Listeners.TCP.ReadTimeout)
+	add("tcp-max-connections", c.Listeners.TCP.MaxConnections)
+	add("udp-workers", c.Listeners.UDP.Workers)
+	add("udp-queue-size", c.Listeners.UDP.QueueSize)
+	add("udp-overflow", c.Listeners.UDP.Overflow)
+	add("udp-batch-size", c.Listeners.UDP.BatchSize)
+	add("proxy-protocol", c.Listeners.ProxyProtocol.Enabled)
+	add("proxy-protocol-trusted", c.Listeners.ProxyProtocol.Trusted)
+	add("soa-mname", c.SOA.MName)
+	add("soa-mbox", c.SOA.MBox)
+	add("soa-serial", c.SOA.Serial)
+	add("soa-refresh", c.SOA.Refresh)
+	add("soa-retry", c.SOA.Retry)
+	add("soa-expire", c.SOA.Expire)
+	add("soa-minimum", c.SOA.Minimum)
+	add("ttl-embedded-ip", c.TTL.EmbeddedIP)
+	add("ttl-customization", c.TTL.Customization)
+	add("ttl-txt", c.TTL.TXT)
+	add("ttl-ns", c.TTL.NS)
+	add("ttl-soa", c.TTL.SOA)
+	add("ttl-negative", c.TTL.Negative)
+	add("ttl-blocked", c.TTL.Blocked)
+	add("user", c.Privileges.User)
+	add("group", c.Privileges.Group)
+	add("chroot", c.Privileges.Chroot)
+	add("quiet", c.Logging.Quiet)
+	add("shutdown-timeout", c.ShutdownTimeout)
+	return settings
+}
+
+// checkFiles reads & parses the files that the configuration refers to, which
+// the server would otherwise only read once it has started, for -check-config
+func checkFiles(nameservers, addresses, zoneFile, tenants string) error {
+	var errs []error
+	if _, err := readListFlag(nameservers); err != nil {
+		errs = append(errs, fmt.Errorf("-nameservers: %w", err))
+	}
+	if _, err := readListFlag(addresses); err != nil {
+		errs = append(errs, fmt.Errorf("-addresses: %w", err))
+	}
+	if zoneFile != "" {
+		file, err := os.Open(zoneFile)
+		if err == nil {
+			_, err = xip.ParseZoneFile(file)
+			_ = file.Close()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("-zonefile: %w", err))
+		}
+	}
+	if tenants != "" {
+		tenantConfigs, err := readTenants(tenants)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("-tenants: %w", err))
+		}
+		for i, tenantConfig := range tenantConfigs {
+			err = tenantConfig.Validate()
+			tenantErrs := []error{err}
+			if joined, ok := err.(interface{ Unwrap() []error }); ok {
+				tenantErrs = joined.Unwrap() // one line per nameserver or record
+			}
+			for _, err := range tenantErrs {
+				if err != nil {
+					errs = append(errs, fmt.Errorf("-tenants: tenant %d: %w", i, err))
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"xip/xip"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dohTimeout is how long a DNS-over-HTTPS client has to send its request or read our response
+const dohTimeout = 10 * time.Second
+
+// dohContentType is the media type of DNS-over-HTTPS queries & responses (RFC 8484 section 6)
+const dohContentType = "application/dns-message"
+
+// newDoHServer returns an HTTPS server which answers DNS-over-HTTPS (RFC 8484)
+// queries on the "/dns-query" path
+func newDoHServer(tlsConfig *tls.Config, x *xip.Xip, quiet bool) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/dns-query", dohHandler(x, quiet))
+	return &http.Server{
+		Handler:           mux,
+		TLSConfig:         tlsConfig.Clone(), // Clone() because ServeTLS() adds "h2" to NextProtos
+		ReadHeaderTimeout: dohTimeout,
+		ReadTimeout:       dohTimeout,
+		WriteTimeout:      dohTimeout,
+		IdleTimeout:       2 * time.Minute,
+		ErrorLog:          log.Default(),
+	}
+}
+
+// serveDoH serves DNS-over-HTTPS until the server is shut down
+func serveDoH(server *http.Server, listener net.Listener) {
+	// the certificate & key are already in TLSConfig, so we don't pass their filenames
+	if err := server.ServeTLS(listener, "", ""); !errors.Is(err, http.ErrServerClosed) {
+		log.Println(err.Error())
+	}
+}
+
+// dohHandler decodes the DNS query from either the "dns" parameter of a GET
+// (base64url-encoded, no padding) or the body of a POST, passes it to
+// QueryResponseContext(), and returns the packed response
+func dohHandler(x *xip.Xip, quiet bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var query []byte
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			dnsParam := r.URL.Query().Get("dns")
+			if dnsParam == "" {
+				http.Error(w, `missing "dns" query parameter`, http.StatusBadRequest)
+				return
+			}
+			// RFC 8484 says no padding, but let's be lenient about clients who pad anyway
+			query, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(dnsParam, "="))
+			if err != nil {
+				http.Error(w, `"dns" query parameter isn't base64url-encoded: `+err.Error(), http.StatusBadRequest)
+				return
+			}
+		case http.MethodPost:
+			if mediaType := strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0]); mediaType != dohContentType {
+				http.Error(w, `Content-Type must be "`+dohContentType+`"`, http.StatusUnsupportedMediaType)
+				return
+			}
+			// DNS messages can't be larger than 65535 bytes
+			query, err = io.ReadAll(http.MaxBytesReader(w, r.Body, 65535))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "only GET & POST are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		addr, port, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			log.Println(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response, logMessage, err := x.QueryResponseContext(r.Context(), query, net.ParseIP(addr))
+		if err != nil {
+			log.Println(err.Error())
+			http.Error(w, "malformed DNS query: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", dohContentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(response)))
+		// RFC 8484 section 5.1: HTTP caches shouldn't keep the response longer than the smallest TTL
+		if ttl, ok := minTTL(response); ok {
+			w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(int(ttl)))
+		}
+		if _, err = w.Write(response); err != nil {
+			log.Println(err.Error())
+			return
+		}
+		if !quiet {
+			log.Printf("%s.%s %s", addr, port, logMessage)
+		}
+		x.CountTCPQuery()
+	}
+}
+
+// minTTL returns the smallest TTL of the records in the packed response, and
+// false if there aren't any records (and hence no TTL)
+func minTTL(response []byte) (ttl uint32, ok bool) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(response); err != nil {
+		return 0, false
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return 0, false
+	}
+	answers, err := p.AllAnswers()
+	if err != nil {
+		return 0, false
+	}
+	authorities, err := p.AllAuthorities()
+	if err != nil {
+		return 0, false
+	}
+	for _, resource := range append(answers, authorities...) {
+		if !ok || resource.Header.TTL < ttl {
+			ttl, ok = resource.Header.TTL, true
+		}
+	}
+	return ttl, ok
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// The environment variables of systemd's socket activation protocol
+// (sd_listen_fds(3)). We use them, too, when we hand our sockets to our
+// successor, plus predecessorPIDEnv, because we can't know our successor's pid
+// (LISTEN_PID) before we start it.
+const (
+	listenPIDEnv      = "LISTEN_PID"
+	listenFDsEnv      = "LISTEN_FDS"
+	listenFDNamesEnv  = "LISTEN_FDNAMES"
+	predecessorPIDEnv = "SSLIP_IO_PREDECESSOR_PID"
+	listenFDsStart    = 3 // the first passed file descriptor; 0, 1, 2 are stdin, stdout, stderr
+)
+
+// The names of the passed sockets (systemd's FileDescriptorName=). A socket
+// with any other name (systemd defaults to the socket unit's name) is for plain
+// DNS, UDP or TCP.
+const (
+	socketNameDNS = "dns"
+	socketNameDoT = "dot"
+	socketNameDoH = "doh"
+)
+
+// inheritedSockets are the listening sockets we were handed rather than bound
+// ourselves: by systemd (socket activation), or by the server we're replacing
+// during an upgrade (SIGUSR2). Either way, we don't need the privileges to bind
+// to port 53, and there's no moment when nothing is listening on port 53.
+type inheritedSockets struct {
+	udpConns     []*net.UDPConn
+	tcpListeners []*net.TCPListener
+	tlsListener  net.Listener
+	dohListener  net.Listener
+	predecessor  int // the pid of the server we're replacing; 0 if none
+}
+
+// inheritSockets picks up the sockets passed to us, if any. It unsets the
+// environment variables so that our own children (e.g. our successor) don't
+// mistake the sockets for theirs.
+func inheritSockets() (inherited inheritedSockets, err error) {
+	defer func() {
+		for _, env := range []string{listenPIDEnv, listenFDsEnv, listenFDNamesEnv, predecessorPIDEnv} {
+			_ = os.Unsetenv(env)
+		}
+	}()
+	if os.Getenv(listenFDsEnv) == "" {
+		return inherited, nil
+	}
+	source := "systemd"
+	switch {
+	case os.Getenv(listenPIDEnv) == strconv.Itoa(os.Getpid()):
+	case os.Getenv(predecessorPIDEnv) == strconv.Itoa(os.Getppid()):
+		inherited.predecessor = os.Getppid()
+		source = fmt.Sprintf("my predecessor (pid %d)", inherited.predecessor)
+	default:
+		return inherited, nil // they were meant for another process, e.g. a parent that didn't unset them
+	}
+	numFDs, err := strconv.Atoi(os.Getenv(listenFDsEnv))
+	if err != nil {
+		return inherited, fmt.Errorf(`%s "%s" isn't a number`, listenFDsEnv, os.Getenv(listenFDsEnv))
+	}
+	names := strings.Split(os.Getenv(listenFDNamesEnv), ":")
+	for i := 0; i < numFDs; i++ {
+		name := socketNameDNS
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(listenFDsStart+i), name)
+		err = inherited.add(file, name)
+		_ = file.Close() // add() has its own copy
+		if err != nil {
+			return inherited, fmt.Errorf(`file descriptor %d ("%s"): %w`, listenFDsStart+i, name, err)
+		}
+	}
+	log.Printf("I'm pid %d; I inherited %d sockets from %s", os.Getpid(), numFDs, source)
+	return inherited, nil
+}
+
+func (inherited *inheritedSockets) add(file *os.File, name string) error {
+	if listener, err := net.FileListener(file); err == nil {
+		tcpListener, ok := listener.(*net.TCPListener)
+		if !ok {
+			_ = listener.Close()
+			return fmt.Errorf("it's a %s listener, not a TCP listener", listener.Addr().Network())
+		}
+		switch name {
+		case socketNameDoT:
+			inherited.tlsListener = tcpListener
+		case socketNameDoH:
+			inherited.dohListener = tcpListener
+		default:
+			inherited.tcpListeners = append(inherited.tcpListeners, tcpListener)
+		}
+		return nil
+	}
+	packetConn, err := net.FilePacketConn(file)
+	if err != nil {
+		return fmt.Errorf("it's neither a listening TCP socket nor a UDP socket: %w", err)
+	}
+	udpConn, ok := packetConn.(*net.UDPConn)
+	if !ok {
+		_ = packetConn.Close()
+		return fmt.Errorf("it's a %s socket, not a UDP socket", packetConn.LocalAddr().Network())
+	}
+	inherited.udpConns = append(inherited.udpConns, udpConn)
+	return nil
+}
+
+// hasDNS is true if we inherited any plain DNS sockets, in which case we don't bind any
+func (inherited *inheritedSockets) hasDNS() bool {
+	return len(inherited.udpConns) > 0 || len(inherited.tcpListeners) > 0
+}
+
+// tellPredecessorToExit is called once we're ready to answer queries: our
+// predecessor stops accepting queries, finishes the ones it has, and exits
+func (inherited *inheritedSockets) tellPredecessorToExit() {
+	if inherited.predecessor == 0 {
+		return
+	}
+	log.Printf("I'm ready, so I'm telling my predecessor (pid %d) to exit", inherited.predecessor)
+	predecessor, err := os.FindProcess(inherited.predecessor)
+	if err == nil {
+		err = predecessor.Signal(syscall.SIGTERM)
+	}
+	if err != nil {
+		log.Printf("I couldn't tell my predecessor (pid %d) to exit: %s", inherited.predecessor, err.Error())
+	}
+}
+
+// startSuccessor starts a new copy of ourselves (usually a freshly-deployed
+// binary at the same path, with the same flags) and hands it our listening
+// sockets. We carry on answering queries until it's ready, at which point it
+// sends us SIGTERM.
+func startSuccessor(udpConns []*net.UDPConn, tcpListeners []*net.TCPListener, tlsListener, dohListener net.Listener) (*exec.Cmd, error) {
+	var files []*os.File
+	var names []string
+	defer func() {
+		for _, file := range files {
+			_ = file.Close() // our successor has its own copies
+		}
+	}()
+	addFile := func(socket interface{ File() (*os.File, error) }, name string) error {
+		file, err := socket.File()
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+		names = append(names, name)
+		return nil
+	}
+	for _, udpConn := range udpConns {
+		if err := addFile(udpConn, socketNameDNS); err != nil {
+			return nil, err
+		}
+	}
+	for _, tcpListener := range tcpListeners {
+		if err := addFile(tcpListener, socketNameDNS); err != nil {
+			return nil, err
+		}
+	}
+	for name, listener := range map[string]net.Listener{socketNameDoT: tlsListener, socketNameDoH: dohListener} {
+		if tcpListener, ok := listener.(*net.TCPListener); ok {
+			if err := addFile(tcpListener, name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	successor := exec.Command(executable, os.Args[1:]...)
+	successor.Stdout = os.Stdout
+	successor.Stderr = os.Stderr
+	successor.ExtraFiles = files
+	successor.Env = append(os.Environ(),
+		listenFDsEnv+"="+strconv.Itoa(len(files)),
+		listenFDNamesEnv+"="+strings.Join(names, ":"),
+		predecessorPIDEnv+"="+strconv.Itoa(os.Getpid()))
+	if err = successor.Start(); err != nil {
+		return nil, err
+	}
+	return successor, nil
+}
@@ -0,0 +1,126 @@
+package main_test
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+	. "github.com/onsi/gomega/gexec"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var _ = Describe("the -config file", func() {
+	var serverSession *Session
+	var port = getFreePort()
+	var configPath string
+
+	writeConfig := func(name string, contents string) {
+		configPath = filepath.Join(GinkgoT().TempDir(), name)
+		Expect(os.WriteFile(configPath, []byte(contents), 0644)).To(Succeed())
+	}
+	startServer := func(args ...string) {
+		serverSession, err = Start(exec.Command(serverPath, append([]string{"-config", configPath}, args...)...), GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+	}
+	queryA := func(name string) string {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Expect(conn.SetDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+		_, err = conn.Write(lengthPrefixedQuery(1, name))
+		Expect(err).ToNot(HaveOccurred())
+		response := readLengthPrefixedResponse(conn)
+		Expect(response.Answers).To(HaveLen(1))
+		return net.IP(response.Answers[0].Body.(*dnsmessage.AResource).A[:]).String()
+	}
+
+	AfterEach(func() {
+		serverSession.Terminate()
+		Eventually(serverSession).Should(Exit())
+	})
+	When("it's YAML", func() {
+		BeforeEach(func() {
+			writeConfig("config.yaml", `
+blocklist_url: file://../../etc/blocklist.txt
+nameservers:
+  - ns1.example.com.
+addresses:
+  - config.example.com=10.0.0.1
+  - override.example.com=10.0.0.2
+listeners:
+  port: `+strconv.Itoa(port)+`
+  tcp:
+    idle_timeout: 3s
+ttl:
+  embedded_ip: 60
+`)
+		})
+		It("configures the server, but the flags override it", func() {
+			startServer("-addresses", "override.example.com=10.0.0.3")
+			Eventually(serverSession.Err, 10).Should(Say(`Adding nameserver "ns1\.example\.com\."`))
+			Eventually(serverSession.Err, 10).Should(Say("Ready to answer queries"))
+			Expect(queryA("override.example.com.")).To(Equal("10.0.0.3"))
+			Expect(string(serverSession.Err.Contents())).ToNot(ContainSubstring("config.example.com"))
+		})
+		It("validates it with -check-config", func() {
+			startServer("-check-config")
+			Eventually(serverSession.Err, 10).Should(Say("The configuration is valid"))
+			Eventually(serverSession, 10).Should(Exit(0))
+		})
+	})
+	When("it's JSON", func() {
+		BeforeEach(func() {
+			writeConfig("config.json", `{"blocklist_url": "file://../../etc/blocklist.txt", "addresses": ["json.example.com=10.0.0.4"], "listeners": {"port": `+strconv.Itoa(port)+`}}`)
+		})
+		It("configures the server", func() {
+			startServer()
+			Eventually(serverSession.Err, 10).Should(Say("Ready to answer queries"))
+			Expect(queryA("json.example.com.")).To(Equal("10.0.0.4"))
+		})
+	})
+	When("it's invalid", func() {
+		It("rejects unknown settings, e.g. misspelled ones", func() {
+			writeConfig("config.yaml", "listeners:\n  prot: 53\n")
+			startServer("-check-config")
+			Eventually(serverSession.Err, 10).Should(Say(`I couldn't load -config: ".*config\.yaml": yaml: unmarshal errors:\n.*field prot not found`))
+			Eventually(serverSession, 10).Should(Exit(1))
+		})
+		It("reports every invalid setting", func() {
+			writeConfig("config.yaml", `
+nameservers: [ "ns1..example.com" ]
+addresses: [ "no-ip.example.com" ]
+out_of_zone: ignore
+`)
+			startServer("-check-config")
+			Eventually(serverSession.Err, 10).Should(Say(`nameservers\[0\]: "ns1\.\.example\.com" isn't a valid name`))
+			Eventually(serverSession.Err, 10).Should(Say(`addresses\[0\]: "no-ip\.example\.com" must be in the format "host=ip"`))
+			Eventually(serverSession.Err, 10).Should(Say(`out_of_zone: must be "refuse" or "answer", not "ignore"`))
+			Eventually(serverSession, 10).Should(Exit(1))
+		})
+	})
+	When("a file it refers to is invalid", func() {
+		It("fails -check-config", func() {
+			zoneFilePath := filepath.Join(GinkgoT().TempDir(), "sslip.io.zone")
+			Expect(os.WriteFile(zoneFilePath, []byte("$ORIGIN sslip.io.\nwww A not-an-ip\n"), 0644)).To(Succeed())
+			writeConfig("config.yaml", "zonefile: "+zoneFilePath+"\n")
+			startServer("-check-config")
+			Eventually(serverSession.Err, 10).Should(Say(`The configuration is invalid: -zonefile: line 2: www\.sslip\.io\. A: invalid IPv4 address "not-an-ip"`))
+			Eventually(serverSession, 10).Should(Exit(1))
+		})
+		It("reports every nameserver & record of a tenant that it would ignore", func() {
+			tenantsPath := filepath.Join(GinkgoT().TempDir(), "tenants.json")
+			Expect(os.WriteFile(tenantsPath, []byte(`[{"apex": "xip.example.com", "nameservers": [""], "addresses": ["www.example.org=10.0.0.1"]}]`), 0644)).To(Succeed())
+			writeConfig("config.yaml", "tenants: "+tenantsPath+"\n")
+			startServer("-check-config")
+			Eventually(serverSession.Err, 10).Should(Say(`-tenants: tenant 0: tenant "xip\.example\.com\.": -nameservers: ignoring zero-length nameserver ""\n`))
+			Eventually(serverSession.Err, 10).Should(Say(`-tenants: tenant 0: tenant "xip\.example\.com\.": ignoring record "www\.example\.org\.", which isn't within the tenant\n`))
+			Eventually(serverSession, 10).Should(Exit(1))
+		})
+	})
+})
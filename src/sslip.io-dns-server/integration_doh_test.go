@@ -0,0 +1,96 @@
+package main_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"time"
+	"xip/testhelper"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+	. "github.com/onsi/gomega/gexec"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var _ = Describe("DNS-over-HTTPS", func() {
+	var serverCmd *exec.Cmd
+	var serverSession *Session
+	var port = getFreePort()
+	var dohPort = getFreePort()
+	var dohURL = "https://localhost:" + strconv.Itoa(dohPort) + "/dns-query"
+	var flags []string
+	var client = &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	var queryBytes []byte
+
+	BeforeEach(func() {
+		certPath, keyPath, err := testhelper.SelfSignedCert(GinkgoT().TempDir())
+		Expect(err).ToNot(HaveOccurred())
+		flags = []string{"-tls-cert", certPath, "-tls-key", keyPath, "-tls-port", strconv.Itoa(getFreePort()), "-doh-port", strconv.Itoa(dohPort)}
+		queryBytes, err = (&dnsmessage.Message{
+			Questions: []dnsmessage.Question{{
+				Name:  dnsmessage.MustNewName("ip.sslip.io."),
+				Type:  dnsmessage.TypeTXT,
+				Class: dnsmessage.ClassINET,
+			}},
+		}).Pack()
+		Expect(err).ToNot(HaveOccurred())
+	})
+	JustBeforeEach(func() {
+		flags = append(flags, "-port", strconv.Itoa(port), "-blocklistURL", "file://../../etc/blocklist.txt")
+		serverCmd = exec.Command(serverPath, flags...)
+		serverSession, err = Start(serverCmd, GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(serverSession.Err, 10).Should(Say(`I bound via DNS-over-HTTPS to "\[::\]:` + strconv.Itoa(dohPort) + `"`))
+		Eventually(serverSession.Err, 10).Should(Say("Ready to answer queries"))
+	})
+	AfterEach(func() {
+		serverSession.Terminate()
+		Eventually(serverSession).Should(Exit())
+	})
+	expectTXTIsLoopback := func(resp *http.Response) {
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("Content-Type")).To(Equal("application/dns-message"))
+		Expect(resp.Header.Get("Cache-Control")).To(Equal("max-age=180"))
+		body, err := io.ReadAll(resp.Body)
+		Expect(err).ToNot(HaveOccurred())
+		var response dnsmessage.Message
+		Expect(response.Unpack(body)).To(Succeed())
+		Expect(response.Answers).To(HaveLen(1))
+		Expect(net.ParseIP(response.Answers[0].Body.(*dnsmessage.TXTResource).TXT[0]).IsLoopback()).To(BeTrue())
+	}
+	It("answers GET queries with the base64url-encoded query in the dns parameter", func() {
+		resp, err := client.Get(dohURL + "?dns=" + base64.RawURLEncoding.EncodeToString(queryBytes))
+		Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+		expectTXTIsLoopback(resp)
+		Eventually(serverSession.Err).Should(Say(`TypeTXT ip\.sslip\.io\. \? \["(127\.0\.0\.1|::1)"\]`))
+	})
+	It("answers POST queries with the query in the body", func() {
+		resp, err := client.Post(dohURL, "application/dns-message", bytes.NewReader(queryBytes))
+		Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+		expectTXTIsLoopback(resp)
+	})
+	It("rejects POST queries with the wrong Content-Type", func() {
+		resp, err := client.Post(dohURL, "text/plain", bytes.NewReader(queryBytes))
+		Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusUnsupportedMediaType))
+	})
+	It("rejects GET queries without a dns parameter", func() {
+		resp, err := client.Get(dohURL)
+		Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+	})
+})
@@ -0,0 +1,90 @@
+package main_test
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"os/exec"
+	"strconv"
+	"time"
+	"xip/testhelper"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+	. "github.com/onsi/gomega/gexec"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var _ = Describe("DNS-over-TLS", func() {
+	var serverCmd *exec.Cmd
+	var serverSession *Session
+	var port = getFreePort()
+	var tlsPort = getFreePort()
+	var flags []string
+
+	JustBeforeEach(func() {
+		flags = append(flags, "-port", strconv.Itoa(port), "-blocklistURL", "file://../../etc/blocklist.txt")
+		serverCmd = exec.Command(serverPath, flags...)
+		serverSession, err = Start(serverCmd, GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		serverSession.Terminate()
+		Eventually(serverSession).Should(Exit())
+	})
+	When("-tls-cert and -tls-key are set", func() {
+		BeforeEach(func() {
+			certPath, keyPath, err := testhelper.SelfSignedCert(GinkgoT().TempDir())
+			Expect(err).ToNot(HaveOccurred())
+			flags = []string{"-tls-cert", certPath, "-tls-key", keyPath, "-tls-port", strconv.Itoa(tlsPort)}
+		})
+		It("answers queries over TLS", func() {
+			Eventually(serverSession.Err, 10).Should(Say(`I bound via DNS-over-TLS to "\[::\]:` + strconv.Itoa(tlsPort) + `"`))
+			Eventually(serverSession.Err, 10).Should(Say("Ready to answer queries"))
+			conn, err := tls.Dial("tcp", "localhost:"+strconv.Itoa(tlsPort), &tls.Config{InsecureSkipVerify: true})
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			Expect(conn.SetDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+
+			queryBytes, err := (&dnsmessage.Message{
+				Header: dnsmessage.Header{ID: 853},
+				Questions: []dnsmessage.Question{{
+					Name:  dnsmessage.MustNewName("127-0-0-1.sslip.io."),
+					Type:  dnsmessage.TypeA,
+					Class: dnsmessage.ClassINET,
+				}},
+			}).Pack()
+			Expect(err).ToNot(HaveOccurred())
+			// send the length and the query in separate writes to make sure the server doesn't expect one segment
+			lengthBytes := make([]byte, 2)
+			binary.BigEndian.PutUint16(lengthBytes, uint16(len(queryBytes)))
+			_, err = conn.Write(lengthBytes)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = conn.Write(queryBytes)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = io.ReadFull(conn, lengthBytes)
+			Expect(err).ToNot(HaveOccurred())
+			responseBytes := make([]byte, binary.BigEndian.Uint16(lengthBytes))
+			_, err = io.ReadFull(conn, responseBytes)
+			Expect(err).ToNot(HaveOccurred())
+			var response dnsmessage.Message
+			Expect(response.Unpack(responseBytes)).To(Succeed())
+			Expect(response.Header.ID).To(Equal(uint16(853)))
+			Expect(response.Answers).To(HaveLen(1))
+			Expect(net.IP(response.Answers[0].Body.(*dnsmessage.AResource).A[:]).String()).To(Equal("127.0.0.1"))
+			Eventually(serverSession.Err).Should(Say(`TypeA 127-0-0-1\.sslip\.io\. \? 127\.0\.0\.1`))
+		})
+	})
+	When("only one of -tls-cert and -tls-key is set", func() {
+		BeforeEach(func() {
+			flags = []string{"-tls-cert", "/dev/null"}
+		})
+		It("prints an error message and exits", func() {
+			Eventually(serverSession.Err, 10).Should(Say("I need both -tls-cert and -tls-key to enable DNS-over-TLS"))
+			Eventually(serverSession).Should(Exit(1))
+		})
+	})
+})
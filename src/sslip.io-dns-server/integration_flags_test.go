@@ -1,7 +1,10 @@
 package main_test
 
 import (
+	"net"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -9,6 +12,7 @@ import (
 	. "github.com/onsi/gomega"
 	. "github.com/onsi/gomega/gbytes"
 	. "github.com/onsi/gomega/gexec"
+	"golang.org/x/net/dns/dnsmessage"
 )
 
 var _ = Describe("flags", func() {
@@ -110,6 +114,98 @@ var _ = Describe("flags", func() {
 			})
 		})
 	})
+	When("the -soa-* flags are set", func() {
+		BeforeEach(func() {
+			flags = []string{"-nameservers=ns1.example.com,ns2.example.com", "-soa-mbox=hostmaster.example.com",
+				"-soa-serial=2024010100", "-soa-refresh=3600", "-soa-retry=600", "-soa-expire=604800", "-soa-minimum=60"}
+		})
+		It("returns the configured SOA, with the first nameserver as the MNAME, and the MINIMUM as the negative TTL", func() {
+			Expect(string(serverSession.Err.Contents())).Should(MatchRegexp(`SOA: \(our first nameserver\) hostmaster\.example\.com\. 2024010100 3600 600 604800 60\n`))
+			conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			_, err = conn.Write(lengthPrefixedQuery(1, "non-existent.example.com."))
+			Expect(err).ToNot(HaveOccurred())
+			response := readLengthPrefixedResponse(conn)
+			Expect(response.Header.RCode).To(Equal(dnsmessage.RCodeNameError))
+			Expect(response.Authorities).To(HaveLen(1))
+			Expect(response.Authorities[0].Header.TTL).To(Equal(uint32(60)))
+			Expect(*response.Authorities[0].Body.(*dnsmessage.SOAResource)).To(Equal(dnsmessage.SOAResource{
+				NS:      dnsmessage.MustNewName("ns1.example.com."),
+				MBox:    dnsmessage.MustNewName("hostmaster.example.com."),
+				Serial:  2024010100,
+				Refresh: 3600,
+				Retry:   600,
+				Expire:  604800,
+				MinTTL:  60,
+			}))
+		})
+	})
+	When("-zonefile is set", func() {
+		BeforeEach(func() {
+			zoneFilePath := filepath.Join(GinkgoT().TempDir(), "sslip.io.zone")
+			Expect(os.WriteFile(zoneFilePath, []byte("$ORIGIN sslip.io.\n$TTL 300\nstatic IN A 10.0.0.7\n"), 0644)).To(Succeed())
+			flags = []string{"-zonefile=" + zoneFilePath}
+		})
+		It("answers the zone file's records", func() {
+			Expect(string(serverSession.Err.Contents())).Should(MatchRegexp(`Loaded 1 names from zone file ".*sslip\.io\.zone"\n`))
+			conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			_, err = conn.Write(lengthPrefixedQuery(1, "static.sslip.io."))
+			Expect(err).ToNot(HaveOccurred())
+			response := readLengthPrefixedResponse(conn)
+			Expect(response.Answers).To(HaveLen(1))
+			Expect(response.Answers[0].Header.TTL).To(Equal(uint32(300)))
+			Expect(response.Answers[0].Body.(*dnsmessage.AResource).A).To(Equal([4]byte{10, 0, 0, 7}))
+		})
+	})
+	When("-tenants is set", func() {
+		BeforeEach(func() {
+			tenantsPath := filepath.Join(GinkgoT().TempDir(), "tenants.json")
+			Expect(os.WriteFile(tenantsPath, []byte(`[{"apex": "xip.example.com", "nameservers": ["ns1.example.com"], "mbox": "hostmaster.example.com", "addresses": ["www.xip.example.com=10.0.0.1"]}]`), 0644)).To(Succeed())
+			flags = []string{"-tenants=" + tenantsPath}
+		})
+		It("answers the tenant's names with its own records & SOA", func() {
+			Expect(string(serverSession.Err.Contents())).Should(MatchRegexp(`Adding tenant "xip\.example\.com\."\n`))
+			conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			_, err = conn.Write(lengthPrefixedQuery(1, "www.xip.example.com."))
+			Expect(err).ToNot(HaveOccurred())
+			response := readLengthPrefixedResponse(conn)
+			Expect(response.Answers).To(HaveLen(1))
+			Expect(response.Answers[0].Body.(*dnsmessage.AResource).A).To(Equal([4]byte{10, 0, 0, 1}))
+			_, err = conn.Write(lengthPrefixedQuery(2, "non-existent.xip.example.com."))
+			Expect(err).ToNot(HaveOccurred())
+			response = readLengthPrefixedResponse(conn)
+			Expect(response.Header.RCode).To(Equal(dnsmessage.RCodeNameError))
+			Expect(response.Authorities).To(HaveLen(1))
+			soa := response.Authorities[0].Body.(*dnsmessage.SOAResource)
+			Expect(soa.NS.String()).To(Equal("ns1.example.com."))
+			Expect(soa.MBox.String()).To(Equal("hostmaster.example.com."))
+		})
+	})
+	When("-zones is set", func() {
+		BeforeEach(func() {
+			flags = []string{"-zones=sslip.io,in-addr.arpa"}
+		})
+		It("answers queries in the zones, but refuses the rest", func() {
+			Expect(string(serverSession.Err.Contents())).Should(MatchRegexp(`Adding zone "sslip\.io\."\n`))
+			conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			_, err = conn.Write(lengthPrefixedQuery(1, "127-0-0-1.sslip.io."))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(readLengthPrefixedResponse(conn).Header.RCode).To(Equal(dnsmessage.RCodeSuccess))
+			_, err = conn.Write(lengthPrefixedQuery(2, "127-0-0-1.example.com."))
+			Expect(err).ToNot(HaveOccurred())
+			response := readLengthPrefixedResponse(conn)
+			Expect(response.Header.RCode).To(Equal(dnsmessage.RCodeRefused))
+			Expect(response.Answers).To(BeEmpty())
+			Eventually(serverSession.Err).Should(Say(`TypeA 127-0-0-1\.example\.com\. \? Refused \(out of zone\)`))
+		})
+	})
 	When("-quiet is set", func() {
 		BeforeEach(func() {
 			flags = []string{"-quiet"}
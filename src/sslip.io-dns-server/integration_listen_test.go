@@ -0,0 +1,109 @@
+package main_test
+
+import (
+	"net"
+	"os/exec"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+	. "github.com/onsi/gomega/gexec"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var _ = Describe("-listen, -ipv4-only, and -ipv6-only", func() {
+	var serverCmd *exec.Cmd
+	var serverSession *Session
+	var port = getFreePort()
+	var tcpPort = getFreePort()
+	var flags []string
+
+	JustBeforeEach(func() {
+		flags = append(flags, "-port", strconv.Itoa(port), "-blocklistURL", "file://../../etc/blocklist.txt")
+		serverCmd = exec.Command(serverPath, flags...)
+		serverSession, err = Start(serverCmd, GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		serverSession.Terminate()
+		Eventually(serverSession).Should(Exit())
+	})
+	When("-listen is set", func() {
+		BeforeEach(func() {
+			flags = []string{"-listen", "udp://127.0.0.1", "-listen", "tcp://127.0.0.1:" + strconv.Itoa(tcpPort)}
+		})
+		It("binds to exactly those addresses, defaulting to -port", func() {
+			Eventually(serverSession.Err, 10).Should(Say(`I bound via UDP to the following IPs: "127\.0\.0\.1:` + strconv.Itoa(port) + `"`))
+			Eventually(serverSession.Err, 10).Should(Say(`I bound via TCP to the following IPs: "127\.0\.0\.1:` + strconv.Itoa(tcpPort) + `"`))
+			Eventually(serverSession.Err, 10).Should(Say("Ready to answer queries"))
+
+			udpConn, err := net.Dial("udp", "127.0.0.1:"+strconv.Itoa(port))
+			Expect(err).ToNot(HaveOccurred())
+			defer udpConn.Close()
+			Expect(udpConn.SetDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+			queryBytes, err := (&dnsmessage.Message{
+				Header: dnsmessage.Header{ID: 53},
+				Questions: []dnsmessage.Question{{
+					Name:  dnsmessage.MustNewName("127-0-0-1.sslip.io."),
+					Type:  dnsmessage.TypeA,
+					Class: dnsmessage.ClassINET,
+				}},
+			}).Pack()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = udpConn.Write(queryBytes)
+			Expect(err).ToNot(HaveOccurred())
+			responseBytes := make([]byte, 512)
+			n, err := udpConn.Read(responseBytes)
+			Expect(err).ToNot(HaveOccurred())
+			var response dnsmessage.Message
+			Expect(response.Unpack(responseBytes[:n])).To(Succeed())
+			Expect(response.Header.ID).To(Equal(uint16(53)))
+
+			tcpConn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(tcpPort))
+			Expect(err).ToNot(HaveOccurred())
+			defer tcpConn.Close()
+			Expect(tcpConn.SetDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+			_, err = tcpConn.Write(lengthPrefixedQuery(1, "127-0-0-1.sslip.io."))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(readLengthPrefixedResponse(tcpConn).Answers).To(HaveLen(1))
+		})
+	})
+	When("-listen isn't a UDP or TCP address", func() {
+		BeforeEach(func() {
+			flags = []string{"-listen", "http://127.0.0.1:53"}
+		})
+		It("exits with an error", func() {
+			Eventually(serverSession.Err, 10).Should(Say(`"http://127\.0\.0\.1:53" must start with "udp://" or "tcp://"`))
+			Eventually(serverSession, 10).Should(Exit(2))
+		})
+	})
+	When("-ipv4-only is set", func() {
+		BeforeEach(func() {
+			flags = []string{"-ipv4-only"}
+		})
+		It("binds to only IPv4 addresses", func() {
+			Eventually(serverSession.Err, 10).Should(Say(`I bound via UDP to the following IPs: "0\.0\.0\.0:` + strconv.Itoa(port) + `"`))
+			Eventually(serverSession.Err, 10).Should(Say(`I bound via TCP to the following IPs: "0\.0\.0\.0:` + strconv.Itoa(port) + `"`))
+		})
+	})
+	When("-ipv4-only is set and -listen is an IPv6 address", func() {
+		BeforeEach(func() {
+			flags = []string{"-ipv4-only", "-listen", "udp://[::1]"}
+		})
+		It("exits with an error", func() {
+			Eventually(serverSession.Err, 10).Should(Say(`I can't listen on "udp://\[::1\]:` + strconv.Itoa(port) + `" because I was told to use only IPv4`))
+			Eventually(serverSession, 10).Should(Exit(1))
+		})
+	})
+	When("both -ipv4-only and -ipv6-only are set", func() {
+		BeforeEach(func() {
+			flags = []string{"-ipv4-only", "-ipv6-only"}
+		})
+		It("exits with an error", func() {
+			Eventually(serverSession.Err, 10).Should(Say("I can't bind to only IPv4 and only IPv6 at the same time"))
+			Eventually(serverSession, 10).Should(Exit(1))
+		})
+	})
+})
@@ -0,0 +1,76 @@
+//go:build !windows
+
+package main_test
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+	. "github.com/onsi/gomega/gexec"
+)
+
+var _ = Describe("dropping privileges", func() {
+	var serverSession *Session
+	var port = getFreePort()
+
+	startServer := func(args ...string) {
+		args = append([]string{"-port", strconv.Itoa(port), "-blocklistURL", "file://../../etc/blocklist.txt"}, args...)
+		serverSession, err = Start(exec.Command(serverPath, args...), GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+	}
+	queryIpSslipIo := func() {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Expect(conn.SetDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+		_, err = conn.Write(ipSslipIoQuery())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(txtAnswer(readLengthPrefixedResponse(conn))).To(Equal("127.0.0.1"))
+	}
+
+	AfterEach(func() {
+		serverSession.Terminate()
+		Eventually(serverSession).Should(Exit())
+	})
+	When("-group is set without -user", func() {
+		It("exits with an error", func() {
+			startServer("-group", "nogroup")
+			Eventually(serverSession.Err, 10).Should(Say(`I need -user to switch to group "nogroup"`))
+			Eventually(serverSession, 10).Should(Exit(1))
+		})
+	})
+	When("the user doesn't exist", func() {
+		It("exits with an error", func() {
+			startServer("-user", "no-such-user-sslip-io")
+			Eventually(serverSession.Err, 10).Should(Say(`I couldn't find user "no-such-user-sslip-io"`))
+			Eventually(serverSession, 10).Should(Exit(1))
+		})
+	})
+	When("it's started as root", func() {
+		BeforeEach(func() {
+			if os.Getuid() != 0 {
+				Skip("switching users requires root")
+			}
+		})
+		It("switches to the -user & -group once it has bound its sockets, and still answers queries", func() {
+			startServer("-user", "65534", "-group", "65534")
+			Eventually(serverSession.Err, 10).Should(Say(`I bound via TCP to the following IPs`))
+			Eventually(serverSession.Err, 10).Should(Say(`I dropped my privileges: I'm now running as uid 65534, gid 65534`))
+			Eventually(serverSession.Err, 10).Should(Say("Ready to answer queries"))
+			queryIpSslipIo()
+		})
+		It("chroots to the -chroot directory, and still answers queries", func() {
+			startServer("-user", "65534", "-group", "65534", "-chroot", GinkgoT().TempDir())
+			Eventually(serverSession.Err, 10).Should(Say(`I chrooted to "`))
+			Eventually(serverSession.Err, 10).Should(Say(`I dropped my privileges`))
+			Eventually(serverSession.Err, 10).Should(Say("Ready to answer queries"))
+			queryIpSslipIo()
+		})
+	})
+})
@@ -0,0 +1,143 @@
+package main_test
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"os/exec"
+	"strconv"
+	"time"
+	"xip/testhelper"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+	. "github.com/onsi/gomega/gexec"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var _ = Describe("PROXY protocol", func() {
+	var serverCmd *exec.Cmd
+	var serverSession *Session
+	var port = getFreePort()
+	var tlsPort = getFreePort()
+	var flags []string
+	var conn net.Conn
+
+	JustBeforeEach(func() {
+		flags = append(flags, "-port", strconv.Itoa(port), "-blocklistURL", "file://../../etc/blocklist.txt")
+		serverCmd = exec.Command(serverPath, flags...)
+		serverSession, err = Start(serverCmd, GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(serverSession.Err, 10).Should(Say("Ready to answer queries"))
+		conn, err = net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conn.SetDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+	})
+	AfterEach(func() {
+		_ = conn.Close()
+		serverSession.Terminate()
+		Eventually(serverSession).Should(Exit())
+	})
+	When("-proxy-protocol is set and the load balancer is trusted", func() {
+		BeforeEach(func() {
+			flags = []string{"-proxy-protocol", "-proxy-protocol-trusted", "127.0.0.0/8"}
+		})
+		It("uses the client address from a v1 header", func() {
+			_, err = conn.Write(append([]byte("PROXY TCP4 203.0.113.7 127.0.0.1 5555 53\r\n"), ipSslipIoQuery()...))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(txtAnswer(readLengthPrefixedResponse(conn))).To(Equal("203.0.113.7"))
+			Eventually(serverSession.Err).Should(Say(`203\.0\.113\.7\.5555 TypeTXT ip\.sslip\.io\. \? \["203\.0\.113\.7"\]`))
+		})
+		It("uses the client address from a v2 header", func() {
+			header := []byte("\r\n\r\n\x00\r\nQUIT\n")
+			header = append(header, 0x21, 0x21) // version 2, PROXY; AF_INET6, STREAM
+			header = binary.BigEndian.AppendUint16(header, 16+16+2+2+5)
+			header = append(header, net.ParseIP("2001:db8::7")...)
+			header = append(header, net.ParseIP("::1")...)
+			header = binary.BigEndian.AppendUint16(header, 5555)
+			header = binary.BigEndian.AppendUint16(header, 53)
+			header = append(header, 0x04, 0x00, 0x02, 0xff, 0xff) // a TLV (PP2_TYPE_NOOP), which we skip
+			_, err = conn.Write(append(header, ipSslipIoQuery()...))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(txtAnswer(readLengthPrefixedResponse(conn))).To(Equal("2001:db8::7"))
+		})
+		It("uses the load balancer's address for a LOCAL v2 header, e.g. a health check", func() {
+			header := append([]byte("\r\n\r\n\x00\r\nQUIT\n"), 0x20, 0x00, 0x00, 0x00)
+			_, err = conn.Write(append(header, ipSslipIoQuery()...))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(txtAnswer(readLengthPrefixedResponse(conn))).To(Equal("127.0.0.1"))
+		})
+		It("hangs up if there's no header", func() {
+			_, err = conn.Write(ipSslipIoQuery())
+			Expect(err).ToNot(HaveOccurred())
+			_, err = conn.Read(make([]byte, 1))
+			Expect(err).To(MatchError(io.EOF))
+			Eventually(serverSession.Err).Should(Say(`I couldn't read the PROXY protocol header from 127\.0\.0\.1:\d+, so I'm hanging up`))
+		})
+	})
+	When("-proxy-protocol is set but the load balancer isn't trusted", func() {
+		BeforeEach(func() {
+			flags = []string{"-proxy-protocol", "-proxy-protocol-trusted", "192.0.2.0/24"}
+		})
+		It("doesn't expect a header, and uses the connection's address", func() {
+			_, err = conn.Write(ipSslipIoQuery())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(txtAnswer(readLengthPrefixedResponse(conn))).To(Equal("127.0.0.1"))
+		})
+	})
+	When("-proxy-protocol isn't set", func() {
+		It("doesn't expect a header", func() {
+			_, err = conn.Write(ipSslipIoQuery())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(txtAnswer(readLengthPrefixedResponse(conn))).To(Equal("127.0.0.1"))
+		})
+	})
+	When("-proxy-protocol-trusted isn't a list of CIDRs", func() {
+		It("exits with an error", func() {
+			misconfiguredSession, err := Start(exec.Command(serverPath, "-proxy-protocol", "-proxy-protocol-trusted", "127.0.0.1", "-port", strconv.Itoa(getFreePort())), GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(misconfiguredSession.Err, 10).Should(Say(`I couldn't parse -proxy-protocol-trusted "127\.0\.0\.1"`))
+			Eventually(misconfiguredSession, 10).Should(Exit(1))
+		})
+	})
+	When("DNS-over-TLS is behind the load balancer", func() {
+		BeforeEach(func() {
+			certPath, keyPath, err := testhelper.SelfSignedCert(GinkgoT().TempDir())
+			Expect(err).ToNot(HaveOccurred())
+			flags = []string{"-proxy-protocol", "-tls-cert", certPath, "-tls-key", keyPath, "-tls-port", strconv.Itoa(tlsPort)}
+		})
+		It("reads the header before the TLS handshake", func() {
+			rawConn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(tlsPort))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rawConn.SetDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+			_, err = rawConn.Write([]byte("PROXY TCP4 198.51.100.9 127.0.0.1 5555 853\r\n"))
+			Expect(err).ToNot(HaveOccurred())
+			tlsConn := tls.Client(rawConn, &tls.Config{InsecureSkipVerify: true})
+			defer tlsConn.Close()
+			_, err = tlsConn.Write(ipSslipIoQuery())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(txtAnswer(readLengthPrefixedResponse(tlsConn))).To(Equal("198.51.100.9"))
+		})
+	})
+})
+
+// ipSslipIoQuery is a length-prefixed query for the TXT record of "ip.sslip.io", whose answer is the client's address
+func ipSslipIoQuery() []byte {
+	queryBytes, err := (&dnsmessage.Message{
+		Header: dnsmessage.Header{ID: 1},
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName("ip.sslip.io."),
+			Type:  dnsmessage.TypeTXT,
+			Class: dnsmessage.ClassINET,
+		}},
+	}).Pack()
+	Expect(err).ToNot(HaveOccurred())
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(queryBytes))), queryBytes...)
+}
+
+func txtAnswer(response dnsmessage.Message) string {
+	Expect(response.Answers).To(HaveLen(1))
+	return response.Answers[0].Body.(*dnsmessage.TXTResource).TXT[0]
+}
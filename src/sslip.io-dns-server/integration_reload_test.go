@@ -0,0 +1,133 @@
+package main_test
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+	. "github.com/onsi/gomega/gexec"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var _ = Describe("reloading the configuration on SIGHUP", func() {
+	var serverCmd *exec.Cmd
+	var serverSession *Session
+	var port = getFreePort()
+	var addressesPath string
+	var flags []string
+
+	queryA := func(name string) string {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Expect(conn.SetDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+		_, err = conn.Write(lengthPrefixedQuery(1, name))
+		Expect(err).ToNot(HaveOccurred())
+		response := readLengthPrefixedResponse(conn)
+		Expect(response.Answers).To(HaveLen(1))
+		return net.IP(response.Answers[0].Body.(*dnsmessage.AResource).A[:]).String()
+	}
+
+	BeforeEach(func() {
+		addressesPath = filepath.Join(GinkgoT().TempDir(), "addresses")
+		Expect(os.WriteFile(addressesPath, []byte("# our web server\nreload.example.com=10.1.2.3\n"), 0644)).To(Succeed())
+		flags = nil
+	})
+	JustBeforeEach(func() {
+		serverCmd = exec.Command(serverPath, append([]string{"-port", strconv.Itoa(port), "-blocklistURL", "file://../../etc/blocklist.txt",
+			"-addresses", "file://" + addressesPath}, flags...)...)
+		serverSession, err = Start(serverCmd, GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(serverSession.Err, 10).Should(Say(`Adding record "reload\.example\.com\.=10\.1\.2\.3"`))
+		Eventually(serverSession.Err, 10).Should(Say("Ready to answer queries"))
+	})
+	AfterEach(func() {
+		serverSession.Terminate()
+		Eventually(serverSession).Should(Exit())
+	})
+	It("re-reads the files and answers with the new records", func() {
+		Expect(queryA("reload.example.com.")).To(Equal("10.1.2.3"))
+		Expect(os.WriteFile(addressesPath, []byte("reload.example.com=10.4.5.6, reloaded.example.com=10.7.8.9\n"), 0644)).To(Succeed())
+		serverSession.Signal(syscall.SIGHUP)
+		Eventually(serverSession.Err, 10).Should(Say("I received SIGHUP, so I'm reloading my configuration"))
+		Eventually(serverSession.Err, 10).Should(Say(`Adding record "reload\.example\.com\.=10\.4\.5\.6"`))
+		Eventually(serverSession.Err, 10).Should(Say("I reloaded my configuration"))
+		Expect(queryA("reload.example.com.")).To(Equal("10.4.5.6"))
+		Expect(queryA("reloaded.example.com.")).To(Equal("10.7.8.9"))
+		Consistently(serverSession).ShouldNot(Exit())
+	})
+	When("-zones & -tenants are files", func() {
+		var zonesPath, tenantsPath string
+		BeforeEach(func() {
+			zonesPath = filepath.Join(GinkgoT().TempDir(), "zones")
+			tenantsPath = filepath.Join(GinkgoT().TempDir(), "tenants.json")
+			Expect(os.WriteFile(zonesPath, []byte("example.com\n"), 0644)).To(Succeed())
+			Expect(os.WriteFile(tenantsPath, []byte("[]"), 0644)).To(Succeed())
+			flags = []string{"-zones", "file://" + zonesPath, "-tenants", tenantsPath}
+		})
+		It("re-reads the zones along with the tenants", func() {
+			Expect(queryA("reload.example.com.")).To(Equal("10.1.2.3"))
+			Expect(os.WriteFile(zonesPath, []byte("example.com\nexample.net\n"), 0644)).To(Succeed())
+			Expect(os.WriteFile(tenantsPath, []byte(`[{"apex": "xip.example.net", "addresses": ["www.xip.example.net=10.0.0.9"]}]`), 0644)).To(Succeed())
+			serverSession.Signal(syscall.SIGHUP)
+			Eventually(serverSession.Err, 10).Should(Say(`Adding tenant "xip\.example\.net\."`))
+			Eventually(serverSession.Err, 10).Should(Say(`Adding zone "example\.net\."`))
+			Eventually(serverSession.Err, 10).Should(Say("I reloaded my configuration"))
+			Expect(queryA("www.xip.example.net.")).To(Equal("10.0.0.9"))
+			Expect(queryA("127-0-0-1.example.net.")).To(Equal("127.0.0.1"))
+		})
+	})
+	When("-config sets them", func() {
+		var configPath string
+		BeforeEach(func() {
+			configPath = filepath.Join(GinkgoT().TempDir(), "config.yaml")
+			Expect(os.WriteFile(configPath, []byte("zones: [example.com]\n"), 0644)).To(Succeed())
+			flags = []string{"-config", configPath}
+		})
+		It("re-reads the configuration file, too", func() {
+			Expect(os.WriteFile(configPath, []byte("zones: [example.com, example.net]\nlisteners: {sockets: 2}\n"), 0644)).To(Succeed())
+			serverSession.Signal(syscall.SIGHUP)
+			Eventually(serverSession.Err, 10).Should(Say(`Adding zone "example\.net\."`))
+			Eventually(serverSession.Err, 10).Should(Say(`I re-read -config's blocklistURL, nameservers, addresses, zones, out-of-zone, zonefile, tenants settings; its others take effect when I restart`))
+			Eventually(serverSession.Err, 10).Should(Say("I reloaded my configuration"))
+			Expect(queryA("127-0-0-1.example.net.")).To(Equal("127.0.0.1"))
+		})
+		It("keeps the previous configuration if the configuration file is invalid", func() {
+			Expect(os.WriteFile(configPath, []byte("zonez: [example.net]\n"), 0644)).To(Succeed())
+			serverSession.Signal(syscall.SIGHUP)
+			Eventually(serverSession.Err, 10).Should(Say("I couldn't reload my configuration, so I'm keeping the previous one: -config: "))
+			Expect(queryA("reload.example.com.")).To(Equal("10.1.2.3"))
+		})
+	})
+	When("the zone file doesn't parse", func() {
+		var zoneFilePath string
+		BeforeEach(func() {
+			zoneFilePath = filepath.Join(GinkgoT().TempDir(), "sslip.io.zone")
+			Expect(os.WriteFile(zoneFilePath, []byte("$ORIGIN example.com.\nwww A 10.0.0.80\n"), 0644)).To(Succeed())
+			flags = []string{"-zonefile", zoneFilePath}
+		})
+		It("keeps the whole previous configuration, not merely the previous zone file", func() {
+			Expect(os.WriteFile(addressesPath, []byte("reload.example.com=10.4.5.6\n"), 0644)).To(Succeed())
+			Expect(os.WriteFile(zoneFilePath, []byte("$ORIGIN example.com.\nwww A not-an-ip\n"), 0644)).To(Succeed())
+			serverSession.Signal(syscall.SIGHUP)
+			Eventually(serverSession.Err, 10).Should(Say("I couldn't reload my configuration, so I'm keeping the previous one: -zonefile: "))
+			Expect(queryA("reload.example.com.")).To(Equal("10.1.2.3"))
+			Expect(queryA("www.example.com.")).To(Equal("10.0.0.80"))
+		})
+	})
+	When("it can't read a file", func() {
+		It("keeps the previous configuration", func() {
+			Expect(os.Remove(addressesPath)).To(Succeed())
+			serverSession.Signal(syscall.SIGHUP)
+			Eventually(serverSession.Err, 10).Should(Say("I couldn't reload my configuration, so I'm keeping the previous one: -addresses: "))
+			Expect(queryA("reload.example.com.")).To(Equal("10.1.2.3"))
+		})
+	})
+})
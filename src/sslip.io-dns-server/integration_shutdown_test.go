@@ -0,0 +1,55 @@
+package main_test
+
+import (
+	"io"
+	"net"
+	"os/exec"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+	. "github.com/onsi/gomega/gexec"
+)
+
+var _ = Describe("graceful shutdown", func() {
+	var serverCmd *exec.Cmd
+	var serverSession *Session
+	var port = getFreePort()
+
+	BeforeEach(func() {
+		serverCmd = exec.Command(serverPath, "-port", strconv.Itoa(port), "-blocklistURL", "file://../../etc/blocklist.txt")
+		serverSession, err = Start(serverCmd, GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(serverSession.Err, 10).Should(Say("Ready to answer queries"))
+	})
+	When("the server receives SIGTERM", func() {
+		It("finishes the open TCP connections, logs the final metrics, and exits", func() {
+			conn, err := net.Dial("tcp", "localhost:"+strconv.Itoa(port))
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			Expect(conn.SetDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+			_, err = conn.Write(lengthPrefixedQuery(1, "127-0-0-1.sslip.io."))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(readLengthPrefixedResponse(conn).Answers).To(HaveLen(1))
+
+			serverSession.Terminate()
+			Eventually(serverSession.Err, 5).Should(Say(`I received terminated, so I'm no longer accepting queries`))
+			// the idle connection is closed rather than waiting out -tcp-idle-timeout
+			_, err = conn.Read(make([]byte, 1))
+			Expect(err).To(MatchError(io.EOF))
+			Eventually(serverSession.Err, 5).Should(Say(`I finished answering the in-flight queries`))
+			Eventually(serverSession.Err, 5).Should(Say(`Final metrics: Queries: 1 `))
+			Eventually(serverSession.Err, 5).Should(Say(`Final metrics: TCP/UDP: 1/0`))
+			Eventually(serverSession, 5).Should(Exit(0))
+		})
+	})
+	When("the server receives SIGINT", func() {
+		It("exits", func() {
+			serverSession.Interrupt()
+			Eventually(serverSession.Err, 5).Should(Say(`I received interrupt, so I'm no longer accepting queries`))
+			Eventually(serverSession, 5).Should(Exit(0))
+		})
+	})
+})
@@ -1,9 +1,11 @@
 package main_test
 
 import (
+	"fmt"
 	"net"
 	"os/exec"
 	"strconv"
+	"sync"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -82,4 +84,66 @@ var _ = Describe("speed", func() {
 			Expect(float64(numQueries) / elapsedSeconds).Should(BeNumerically(">", minThroughput))
 		})
 	})
+	When("we want to compare SO_REUSEPORT sockets & batched I/O with one socket", func() {
+		const numClients = 16
+		const queriesPerClient = 1000
+		const minThroughput = 1000
+		// The concurrent clients are what spreads the load across the sockets: the
+		// kernel picks a socket by hashing the client's address & port
+		concurrentThroughput := func() float64 {
+			queryBuf, err := (&dnsmessage.Message{
+				Questions: []dnsmessage.Question{
+					{
+						Name:  dnsmessage.MustNewName("127-0-0-1.sslip.io."),
+						Type:  dnsmessage.TypeA,
+						Class: dnsmessage.ClassINET,
+					},
+				},
+			}).Pack()
+			Expect(err).ToNot(HaveOccurred())
+			var clients sync.WaitGroup
+			startTime := time.Now()
+			for i := 0; i < numClients; i += 1 {
+				clients.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer clients.Done()
+					conn, err := net.Dial("udp", "127.0.0.1:"+strconv.Itoa(port))
+					Expect(err).ToNot(HaveOccurred())
+					defer conn.Close()
+					responseBuf := make([]byte, 512)
+					for j := 0; j < queriesPerClient; j += 1 {
+						_, err = conn.Write(queryBuf)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(conn.SetReadDeadline(time.Now().Add(time.Second))).To(Succeed())
+						bytesRead, err := conn.Read(responseBuf)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(bytesRead).To(Equal(52))
+					}
+				}()
+			}
+			clients.Wait()
+			return float64(numClients*queriesPerClient) / time.Since(startTime).Seconds()
+		}
+		When("there's one socket and no batching", func() {
+			BeforeEach(func() {
+				flags = []string{"-quiet", "-sockets", "1", "-udp-batch-size", "1"}
+			})
+			It("the throughput is > "+strconv.Itoa(minThroughput)+" queries/sec", func() {
+				throughput := concurrentThroughput()
+				AddReportEntry("1 socket, unbatched", fmt.Sprintf("%.0f queries/sec", throughput))
+				Expect(throughput).Should(BeNumerically(">", minThroughput))
+			})
+		})
+		When("there are four SO_REUSEPORT sockets with batching", func() {
+			BeforeEach(func() {
+				flags = []string{"-quiet", "-sockets", "4"}
+			})
+			It("the throughput is > "+strconv.Itoa(minThroughput)+" queries/sec", func() {
+				throughput := concurrentThroughput()
+				AddReportEntry("4 sockets, batched", fmt.Sprintf("%.0f queries/sec", throughput))
+				Expect(throughput).Should(BeNumerically(">", minThroughput))
+			})
+		})
+	})
 })
@@ -0,0 +1,127 @@
+package main_test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os/exec"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+	. "github.com/onsi/gomega/gexec"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var _ = Describe("DNS-over-TCP", func() {
+	var serverCmd *exec.Cmd
+	var serverSession *Session
+	var port = getFreePort()
+	var flags []string
+	var conn net.Conn
+
+	JustBeforeEach(func() {
+		flags = append(flags, "-port", strconv.Itoa(port), "-blocklistURL", "file://../../etc/blocklist.txt")
+		serverCmd = exec.Command(serverPath, flags...)
+		serverSession, err = Start(serverCmd, GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(serverSession.Err, 10).Should(Say("Ready to answer queries"))
+		conn, err = net.Dial("tcp", "localhost:"+strconv.Itoa(port))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conn.SetDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+	})
+	AfterEach(func() {
+		_ = conn.Close()
+		serverSession.Terminate()
+		Eventually(serverSession).Should(Exit())
+	})
+	When("the client sends a query in several segments", func() {
+		It("waits for the whole query", func() {
+			query := lengthPrefixedQuery(1, "127-0-0-1.sslip.io.")
+			for _, segment := range [][]byte{query[:1], query[1:5], query[5:]} {
+				_, err = conn.Write(segment)
+				Expect(err).ToNot(HaveOccurred())
+				time.Sleep(50 * time.Millisecond)
+			}
+			response := readLengthPrefixedResponse(conn)
+			Expect(response.Header.ID).To(Equal(uint16(1)))
+			Expect(response.Answers).To(HaveLen(1))
+		})
+	})
+	When("the client pipelines several queries on one connection", func() {
+		It("answers all of them", func() {
+			var queries []byte
+			for id := uint16(1); id <= 3; id++ {
+				queries = append(queries, lengthPrefixedQuery(id, "127-0-0-"+strconv.Itoa(int(id))+".sslip.io.")...)
+			}
+			_, err = conn.Write(queries)
+			Expect(err).ToNot(HaveOccurred())
+			answers := map[uint16]string{}
+			for i := 0; i < 3; i++ {
+				response := readLengthPrefixedResponse(conn)
+				Expect(response.Answers).To(HaveLen(1))
+				answers[response.Header.ID] = net.IP(response.Answers[0].Body.(*dnsmessage.AResource).A[:]).String()
+			}
+			// answers may arrive out of order (RFC 7766 section 6.2.1.1)
+			Expect(answers).To(Equal(map[uint16]string{1: "127.0.0.1", 2: "127.0.0.2", 3: "127.0.0.3"}))
+		})
+	})
+	When("the connection sits idle longer than -tcp-idle-timeout", func() {
+		BeforeEach(func() {
+			flags = []string{"-tcp-idle-timeout", "500ms"}
+		})
+		It("closes the connection", func() {
+			_, err = conn.Write(lengthPrefixedQuery(1, "127-0-0-1.sslip.io."))
+			Expect(err).ToNot(HaveOccurred())
+			readLengthPrefixedResponse(conn)
+			startTime := time.Now()
+			_, err = conn.Read(make([]byte, 1))
+			Expect(err).To(MatchError(io.EOF))
+			Expect(time.Since(startTime)).To(BeNumerically("<", 3*time.Second))
+		})
+	})
+	When("there are more connections than -tcp-max-connections", func() {
+		BeforeEach(func() {
+			flags = []string{"-tcp-max-connections", "1"}
+		})
+		It("hangs up on the extra connections", func() {
+			secondConn, err := net.Dial("tcp", "localhost:"+strconv.Itoa(port))
+			Expect(err).ToNot(HaveOccurred())
+			defer secondConn.Close()
+			Expect(secondConn.SetDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+			_, err = secondConn.Read(make([]byte, 1))
+			Expect(err).To(HaveOccurred())
+			Eventually(serverSession.Err).Should(Say(`I'm already serving 1 TCP connections, so I'm hanging up on`))
+			// but the first connection still works
+			_, err = conn.Write(lengthPrefixedQuery(1, "127-0-0-1.sslip.io."))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(readLengthPrefixedResponse(conn).Answers).To(HaveLen(1))
+		})
+	})
+})
+
+func lengthPrefixedQuery(id uint16, name string) []byte {
+	queryBytes, err := (&dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id},
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName(name),
+			Type:  dnsmessage.TypeA,
+			Class: dnsmessage.ClassINET,
+		}},
+	}).Pack()
+	Expect(err).ToNot(HaveOccurred())
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(queryBytes))), queryBytes...)
+}
+
+func readLengthPrefixedResponse(conn net.Conn) (response dnsmessage.Message) {
+	lengthBytes := make([]byte, 2)
+	_, err := io.ReadFull(conn, lengthBytes)
+	Expect(err).ToNot(HaveOccurred())
+	responseBytes := make([]byte, binary.BigEndian.Uint16(lengthBytes))
+	_, err = io.ReadFull(conn, responseBytes)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(response.Unpack(responseBytes)).To(Succeed())
+	return response
+}
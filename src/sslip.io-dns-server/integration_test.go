@@ -22,7 +22,7 @@ var err error
 var serverCmd *exec.Cmd
 var serverSession *Session
 var port = getFreePort()
-var serverPath, _ = Build("main.go")
+var serverPath, _ = Build("xip") // the main package is at the root of module "xip"
 
 var _ = BeforeSuite(func() {
 	Expect(err).ToNot(HaveOccurred())
@@ -65,10 +65,10 @@ var _ = Describe("sslip.io-dns-server", func() {
 				"@localhost sslip.io +short",
 				`\A78.46.204.247\n\z`,
 				`TypeA sslip.io. \? 78.46.204.247\n`),
-			Entry("A (or lack thereof) for example.com",
-				"@localhost example.com +short",
-				`\A\z`,
-				`TypeA example.com. \? nil, SOA example.com. briancunnie.gmail.com. 2023093000 900 900 1800 180\n`),
+			Entry("A (or lack thereof) for example.com is NXDOMAIN",
+				"@localhost example.com",
+				` status: NXDOMAIN,`,
+				`TypeA example.com. \? NXDOMAIN, SOA ns-aws.sslip.io. briancunnie.gmail.com. \d+ 900 900 1800 180\n`),
 			Entry("A for www-127-0-0-1.sslip.io",
 				"@localhost www-127-0-0-1.sslip.io +short",
 				`\A127.0.0.1\n\z`,
@@ -84,7 +84,11 @@ var _ = Describe("sslip.io-dns-server", func() {
 			Entry("AAAA not found for example.com",
 				"@localhost example.com aaaa +short",
 				`\A\z`,
-				`TypeAAAA example.com. \? nil, SOA example.com. briancunnie.gmail.com. 2023093000 900 900 1800 180\n`),
+				`TypeAAAA example.com. \? NXDOMAIN, SOA ns-aws.sslip.io. briancunnie.gmail.com. \d+ 900 900 1800 180\n`),
+			Entry("AAAA (or lack thereof) for 1-2-3-4.sslip.io is NODATA because it has an A record",
+				"@localhost 1-2-3-4.sslip.io aaaa",
+				` status: NOERROR,`,
+				`TypeAAAA 1-2-3-4.sslip.io. \? nil, SOA ns-aws.sslip.io. briancunnie.gmail.com. \d+ 900 900 1800 180\n`),
 			Entry("AAAA for www-2601-646-100-69f0-1c09-bae7-aa42-146c.sslip.io",
 				"@localhost www-2601-646-100-69f0-1c09-bae7-aa42-146c.sslip.io aaaa +short",
 				`\A2601:646:100:69f0:1c09:bae7:aa42:146c\n\z`,
@@ -101,23 +105,23 @@ var _ = Describe("sslip.io-dns-server", func() {
 			Entry("CNAME not found for example.com",
 				"@localhost example.com cname +short",
 				`\A\z`,
-				`TypeCNAME example.com. \? nil, SOA example.com. briancunnie.gmail.com. 2023093000 900 900 1800 180\n`),
+				`TypeCNAME example.com. \? NXDOMAIN, SOA ns-aws.sslip.io. briancunnie.gmail.com. \d+ 900 900 1800 180\n`),
 			Entry("MX for example.com",
 				"@localhost example.com mx +short",
 				`\A0 example.com.\n\z`,
 				`TypeMX example.com. \? 0 example.com.\n`),
 			Entry("SOA for sslip.io",
 				"@localhost sslip.io soa +short",
-				`\Asslip.io. briancunnie.gmail.com. 2023093000 900 900 1800 180\n\z`,
-				`TypeSOA sslip.io. \? sslip.io. briancunnie.gmail.com. 2023093000 900 900 1800 180\n`),
+				`\Ans-aws.sslip.io. briancunnie.gmail.com. \d+ 900 900 1800 180\n\z`,
+				`TypeSOA sslip.io. \? ns-aws.sslip.io. briancunnie.gmail.com. \d+ 900 900 1800 180\n`),
 			Entry("SOA for example.com",
 				"@localhost example.com soa +short",
-				`\Aexample.com. briancunnie.gmail.com. 2023093000 900 900 1800 180\n\z`,
-				`TypeSOA example.com. \? example.com. briancunnie.gmail.com. 2023093000 900 900 1800 180\n`),
+				`\Ans-aws.sslip.io. briancunnie.gmail.com. \d+ 900 900 1800 180\n\z`,
+				`TypeSOA example.com. \? ns-aws.sslip.io. briancunnie.gmail.com. \d+ 900 900 1800 180\n`),
 			Entry("SRV (or other record that we don't implement) for example.com",
 				"@localhost example.com srv +short",
 				`\A\z`,
-				`TypeSRV example.com. \? nil, SOA example.com. briancunnie.gmail.com. 2023093000 900 900 1800 180\n`),
+				`TypeSRV example.com. \? NXDOMAIN, SOA ns-aws.sslip.io. briancunnie.gmail.com. \d+ 900 900 1800 180\n`),
 			Entry(`TXT for version.status.sslip.io is the version number of the xip software (which gets overwritten during linking)`,
 				"@127.0.0.1 version.status.sslip.io txt +short",
 				`\A"0.0.0"\n"0001/01/01-99:99:99-0800"\n"cafexxx"\n\z`,
@@ -129,7 +133,7 @@ var _ = Describe("sslip.io-dns-server", func() {
 			Entry(`TXT is the querier's IPv4 address and the domain is NOT "ip.sslip.io"`,
 				"@127.0.0.1 example.com txt +short",
 				`\A\z`,
-				`TypeTXT example.com. \? nil, SOA example.com. briancunnie.gmail.com. 2023093000 900 900 1800 180\n`),
+				`TypeTXT example.com. \? NXDOMAIN, SOA ns-aws.sslip.io. briancunnie.gmail.com. \d+ 900 900 1800 180\n`),
 			Entry(`get a PTR for 1.0.168.192.in-addr.arpa returns 192-168-0-1.sslip.io`,
 				"@127.0.0.1 ptr -x 192.168.0.1 +short",
 				`\A192-168-0-1.sslip.io.\n\z`,
@@ -137,15 +141,15 @@ var _ = Describe("sslip.io-dns-server", func() {
 			Entry(`get a PTR for 1.0.0.127.blah.in-addr.arpa returns no records; "blah.in-addr.arpa is not a valid domain."`,
 				"@127.0.0.1 1.0.0.127.blah.in-addr.arpa ptr +short",
 				`\A\z`,
-				`TypePTR 1.0.0.127.blah.in-addr.arpa. \? nil, SOA sslip.io. briancunnie.gmail.com. 2023093000 900 900 1800 180\n`),
+				`TypePTR 1.0.0.127.blah.in-addr.arpa. \? NXDOMAIN, SOA ns-aws.sslip.io. briancunnie.gmail.com. \d+ 900 900 1800 180\n`),
 			Entry(`get a PTR for blah.1.0.0.127.in-addr.arpa returns no records; "blah" isn't a valid subdomain' `,
 				"@127.0.0.1 blah.1.0.0.127.in-addr.arpa ptr +short",
 				`\A\z`,
-				`TypePTR blah.1.0.0.127.in-addr.arpa. \? nil, SOA sslip.io. briancunnie.gmail.com. 2023093000 900 900 1800 180\n`),
+				`TypePTR blah.1.0.0.127.in-addr.arpa. \? NXDOMAIN, SOA ns-aws.sslip.io. briancunnie.gmail.com. \d+ 900 900 1800 180\n`),
 			Entry(`get a PTR for 0.0.127.in-addr.arpa returns no records; should have 4 octets, not 3`,
 				"@127.0.0.1 0.0.127.in-addr.arpa ptr +short",
 				`\A\z`,
-				`TypePTR 0.0.127.in-addr.arpa. \? nil, SOA sslip.io. briancunnie.gmail.com. 2023093000 900 900 1800 180\n`),
+				`TypePTR 0.0.127.in-addr.arpa. \? NXDOMAIN, SOA ns-aws.sslip.io. briancunnie.gmail.com. \d+ 900 900 1800 180\n`),
 			Entry(`get a PTR for 2.a.b.b.4.0.2.9.a.e.e.6.e.c.4.1.0.f.9.6.0.0.1.0.6.4.6.0.1.0.6.2.ip6.arpa returns 2601-646-100-69f0-14ce-6eea-9204-bba2.sslip.io`,
 				"@127.0.0.1 ptr -x 2601:646:100:69f0:14ce:6eea:9204:bba2 +short",
 				`\A2601-646-100-69f0-14ce-6eea-9204-bba2.sslip.io.\n\z`,
@@ -153,15 +157,15 @@ var _ = Describe("sslip.io-dns-server", func() {
 			Entry(`get a PTR for 2.a.b.b.4.0.2.9.a.e.e.6.e.c.4.1.0.f.9.6.0.0.1.0.6.4.6.0.1.0.6.2.blah.ip6.arpa returns no records; "blah isn't a valid subdomain'"`,
 				"@127.0.0.1 2.a.b.b.4.0.2.9.a.e.e.6.e.c.4.1.0.f.9.6.0.0.1.0.6.4.6.0.1.0.6.2.blah.ip6.arpa ptr +short",
 				`\A\z`,
-				`TypePTR 2.a.b.b.4.0.2.9.a.e.e.6.e.c.4.1.0.f.9.6.0.0.1.0.6.4.6.0.1.0.6.2.blah.ip6.arpa. \? nil, SOA sslip.io. briancunnie.gmail.com. 2023093000 900 900 1800 180\n`),
+				`TypePTR 2.a.b.b.4.0.2.9.a.e.e.6.e.c.4.1.0.f.9.6.0.0.1.0.6.4.6.0.1.0.6.2.blah.ip6.arpa. \? NXDOMAIN, SOA ns-aws.sslip.io. briancunnie.gmail.com. \d+ 900 900 1800 180\n`),
 			Entry(`get a PTR for b2.a.b.b.4.0.2.9.a.e.e.6.e.c.4.1.0.f.9.6.0.0.1.0.6.4.6.0.1.0.6.2.ip6.arpa returns no records; "b2" isn't a valid subdomain'`,
 				"@127.0.0.1 b2.a.b.b.4.0.2.9.a.e.e.6.e.c.4.1.0.f.9.6.0.0.1.0.6.4.6.0.1.0.6.2.ip6.arpa ptr +short",
 				`\A\z`,
-				`TypePTR b2.a.b.b.4.0.2.9.a.e.e.6.e.c.4.1.0.f.9.6.0.0.1.0.6.4.6.0.1.0.6.2.ip6.arpa. \? nil, SOA sslip.io. briancunnie.gmail.com. 2023093000 900 900 1800 180\n`),
+				`TypePTR b2.a.b.b.4.0.2.9.a.e.e.6.e.c.4.1.0.f.9.6.0.0.1.0.6.4.6.0.1.0.6.2.ip6.arpa. \? NXDOMAIN, SOA ns-aws.sslip.io. briancunnie.gmail.com. \d+ 900 900 1800 180\n`),
 			Entry(`get a PTR for b.b.4.0.2.9.a.e.e.6.e.c.4.1.0.f.9.6.0.0.1.0.6.4.6.0.1.0.6.2.ip6.arpa returns no records; has too few numbers`,
 				"@127.0.0.1 b.b.4.0.2.9.a.e.e.6.e.c.4.1.0.f.9.6.0.0.1.0.6.4.6.0.1.0.6.2.ip6.arpa ptr +short",
 				`\A\z`,
-				`TypePTR b.b.4.0.2.9.a.e.e.6.e.c.4.1.0.f.9.6.0.0.1.0.6.4.6.0.1.0.6.2.ip6.arpa. \? nil, SOA sslip.io. briancunnie.gmail.com. 2023093000 900 900 1800 180\n`),
+				`TypePTR b.b.4.0.2.9.a.e.e.6.e.c.4.1.0.f.9.6.0.0.1.0.6.4.6.0.1.0.6.2.ip6.arpa. \? NXDOMAIN, SOA ns-aws.sslip.io. briancunnie.gmail.com. \d+ 900 900 1800 180\n`),
 			Entry(`TODO: should, but doesn't, return an IDNA2008-compliant record for ::1`,
 				"@127.0.0.1 -x ::1 +short",
 				`\A--1.sslip.io.\n\z`,
@@ -461,6 +465,8 @@ var _ = Describe("sslip.io-dns-server", func() {
 			Eventually(secondServerSession.Err, 10).Should(Say(` version \d+\.\d+\.\d+ starting`))
 			Eventually(secondServerSession.Err, 10).Should(Say(`I couldn't bind via UDP to "\[::\]:\d+" \(INADDR_ANY, all interfaces\), so I'll try to bind to each address individually.`))
 			Eventually(secondServerSession.Err, 10).Should(Say(`I couldn't bind via UDP to the following IPs:.* "(::1|127\.0\.0\.1)"`))
+			// addresses may come & go, e.g. IPv6 SLAAC, so we must keep an eye on them
+			Eventually(secondServerSession.Err, 10).Should(Say(`I('m| couldn't) watch(ing)? the network interfaces for address changes`))
 			err = squatter.Close()
 			Expect(err).ToNot(HaveOccurred())
 			Eventually(secondServerSession.Err, 10).Should(Say("Ready to answer queries"))
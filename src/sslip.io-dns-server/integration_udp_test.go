@@ -0,0 +1,84 @@
+package main_test
+
+import (
+	"net"
+	"os/exec"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+	. "github.com/onsi/gomega/gexec"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var _ = Describe("UDP worker pool", func() {
+	var serverCmd *exec.Cmd
+	var serverSession *Session
+	var port = getFreePort()
+	var flags []string
+
+	JustBeforeEach(func() {
+		flags = append(flags, "-port", strconv.Itoa(port), "-blocklistURL", "file://../../etc/blocklist.txt")
+		serverCmd = exec.Command(serverPath, flags...)
+		serverSession, err = Start(serverCmd, GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		serverSession.Terminate()
+		Eventually(serverSession).Should(Exit())
+	})
+	When("the queue is full and -udp-overflow is refuse", func() {
+		BeforeEach(func() {
+			flags = []string{"-udp-workers", "1", "-udp-queue-size", "0", "-udp-overflow", "refuse", "-quiet"}
+		})
+		It("answers REFUSED and counts the shed queries", func() {
+			Eventually(serverSession.Err, 10).Should(Say("Ready to answer queries"))
+			conn, err := net.Dial("udp", "localhost:"+strconv.Itoa(port))
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			const numQueries = 500
+			queryBytes, err := (&dnsmessage.Message{
+				Questions: []dnsmessage.Question{{
+					Name:  dnsmessage.MustNewName("127-0-0-1.sslip.io."),
+					Type:  dnsmessage.TypeA,
+					Class: dnsmessage.ClassINET,
+				}},
+			}).Pack()
+			Expect(err).ToNot(HaveOccurred())
+			// flood the server without waiting for the answers
+			for i := 0; i < numQueries; i++ {
+				_, err = conn.Write(queryBytes)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			refused := 0
+			responseBuf := make([]byte, 512)
+			for {
+				Expect(conn.SetReadDeadline(time.Now().Add(time.Second))).To(Succeed())
+				n, err := conn.Read(responseBuf)
+				if err != nil {
+					break // we've read all the responses we're going to get
+				}
+				var response dnsmessage.Message
+				Expect(response.Unpack(responseBuf[:n])).To(Succeed())
+				if response.Header.RCode == dnsmessage.RCodeRefused {
+					refused++
+				}
+			}
+			Expect(refused).To(BeNumerically(">", 0))
+
+			serverSession.Terminate()
+			Eventually(serverSession.Err, 5).Should(Say(`Final metrics: Dropped UDP: [1-9]\d*`))
+		})
+	})
+	When("-udp-overflow isn't drop or refuse", func() {
+		BeforeEach(func() {
+			flags = []string{"-udp-overflow", "ignore"}
+		})
+		It("prints an error message and exits", func() {
+			Eventually(serverSession.Err, 10).Should(Say(`-udp-overflow must be "drop" or "refuse", not "ignore"`))
+			Eventually(serverSession).Should(Exit(1))
+		})
+	})
+})
@@ -0,0 +1,92 @@
+//go:build !windows
+
+package main_test
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+	. "github.com/onsi/gomega/gexec"
+)
+
+var _ = Describe("inheriting sockets", func() {
+	var serverSession *Session
+	var port = getFreePort()
+
+	queryTCP := func() {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Expect(conn.SetDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+		_, err = conn.Write(lengthPrefixedQuery(1, "127-0-0-1.sslip.io."))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(readLengthPrefixedResponse(conn).Answers).To(HaveLen(1))
+	}
+
+	AfterEach(func() {
+		serverSession.Terminate()
+		Eventually(serverSession).Should(Exit())
+	})
+	When("systemd hands us the sockets (LISTEN_FDS)", func() {
+		It("answers on them rather than binding its own", func() {
+			udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+			Expect(err).ToNot(HaveOccurred())
+			tcpListener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+			Expect(err).ToNot(HaveOccurred())
+			udpFile, err := udpConn.File()
+			Expect(err).ToNot(HaveOccurred())
+			tcpFile, err := tcpListener.File()
+			Expect(err).ToNot(HaveOccurred())
+			// LISTEN_PID must be the server's pid, which only the shell that exec's it knows
+			serverCmd := exec.Command("sh", "-c", `LISTEN_PID=$$ exec "$0" "$@"`, serverPath,
+				"-port", strconv.Itoa(getFreePort()), "-blocklistURL", "file://../../etc/blocklist.txt")
+			serverCmd.Env = append(os.Environ(), "LISTEN_FDS=2", "LISTEN_FDNAMES=sslip.io-dns-server.socket:sslip.io-dns-server.socket")
+			serverCmd.ExtraFiles = []*os.File{udpFile, tcpFile}
+			serverSession, err = Start(serverCmd, GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(serverSession.Err, 10).Should(Say(`I inherited 2 sockets from systemd`))
+			Eventually(serverSession.Err, 10).Should(Say(`I bound via UDP to the following IPs: "127\.0\.0\.1:` + strconv.Itoa(port) + `"`))
+			Eventually(serverSession.Err, 10).Should(Say(`I bound via TCP to the following IPs: "127\.0\.0\.1:` + strconv.Itoa(port) + `"`))
+			Eventually(serverSession.Err, 10).Should(Say("Ready to answer queries"))
+			// only the server's copies remain
+			for _, closer := range []interface{ Close() error }{udpFile, tcpFile, udpConn, tcpListener} {
+				Expect(closer.Close()).To(Succeed())
+			}
+			queryTCP()
+		})
+	})
+	When("we're told to upgrade (SIGUSR2)", func() {
+		var successorPID int
+		AfterEach(func() {
+			if successorPID != 0 {
+				Expect(syscall.Kill(successorPID, syscall.SIGTERM)).To(Succeed())
+			}
+		})
+		It("hands its sockets to its successor and exits once its successor is ready", func() {
+			serverCmd := exec.Command(serverPath, "-port", strconv.Itoa(port), "-blocklistURL", "file://../../etc/blocklist.txt")
+			serverSession, err = Start(serverCmd, GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(serverSession.Err, 10).Should(Say("Ready to answer queries"))
+			serverSession.Signal(syscall.SIGUSR2)
+			Eventually(serverSession.Err, 10).Should(Say(`I received user defined signal 2, so I started my successor \(pid \d+\) and handed it my sockets`))
+			successorPID, err = strconv.Atoi(regexp.MustCompile(`started my successor \(pid (\d+)\)`).FindStringSubmatch(string(serverSession.Err.Contents()))[1])
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(serverSession.Err, 10).Should(Say(`I'm pid ` + strconv.Itoa(successorPID) + `; I inherited 2 sockets from my predecessor \(pid ` + strconv.Itoa(serverCmd.Process.Pid) + `\)`))
+			Eventually(serverSession.Err, 10).Should(Say(`I'm ready, so I'm telling my predecessor \(pid ` + strconv.Itoa(serverCmd.Process.Pid) + `\) to exit`))
+			Eventually(serverSession.Err, 10).Should(Say(`I received terminated, so I'm no longer accepting queries`))
+			Eventually(serverSession.Err, 10).Should(Say(` version \d+\.\d+\.\d+ exiting`))
+			// we can't wait for the session to exit: our successor shares its stderr
+			Eventually(func() error { return syscall.Kill(serverCmd.Process.Pid, 0) }, 10).Should(MatchError(syscall.ESRCH))
+			// the successor carries on where its predecessor left off
+			queryTCP()
+		})
+	})
+})
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// interfacePollInterval is how often we look for address changes when we can't
+// be notified of them (i.e. when we're not on Linux)
+const interfacePollInterval = 10 * time.Second
+
+// interfaceWatcher binds to the addresses that appear after we've started (e.g.
+// IPv6 SLAAC, a cloud provider's secondary IPs) and closes the sockets on the
+// addresses that disappear. We only need it when we couldn't bind to all
+// interfaces ("[::]") and had to bind to each address individually.
+type interfaceWatcher struct {
+	bindPort int
+	family   string
+	sockets  int
+	watchUDP bool // we bound UDP to each address individually
+	watchTCP bool // we bound TCP to each address individually
+	serveUDP func(*net.UDPConn)
+	serveTCP func(*net.TCPListener)
+	// listIPCIDRs lists the local addresses, e.g. "10.0.0.5/24"; nil means listLocalIPCIDRs()
+	listIPCIDRs func() []string
+	// droppedPrivileges is true if we've switched to an unprivileged user (-user),
+	// who can't bind to a privileged port, e.g. 53
+	droppedPrivileges bool
+
+	mutex        sync.Mutex
+	knownIPs     map[string]bool // the addresses at our last look, whether or not we could bind to them
+	udpConns     map[string][]*net.UDPConn
+	tcpListeners map[string][]*net.TCPListener
+	stopped      bool
+	stopWatching func() // stops the notifications of address changes, if we get them
+}
+
+// adopt takes charge of the sockets we bound at startup, and of the addresses we
+// couldn't bind to, which we retry whenever the addresses change
+func (w *interfaceWatcher) adopt(udpConns []*net.UDPConn, tcpListeners []*net.TCPListener, unboundIPs []string) {
+	w.knownIPs = map[string]bool{}
+	w.udpConns = map[string][]*net.UDPConn{}
+	w.tcpListeners = map[string][]*net.TCPListener{}
+	for _, udpConn := range udpConns {
+		ip := udpConn.LocalAddr().(*net.UDPAddr).IP.String()
+		w.knownIPs[ip] = true
+		w.udpConns[ip] = append(w.udpConns[ip], udpConn)
+	}
+	for _, tcpListener := range tcpListeners {
+		ip := tcpListener.Addr().(*net.TCPAddr).IP.String()
+		w.knownIPs[ip] = true
+		w.tcpListeners[ip] = append(w.tcpListeners[ip], tcpListener)
+	}
+	for _, ip := range unboundIPs {
+		w.knownIPs[ip] = true
+	}
+	// no interface has the address of a socket bound to all interfaces ("[::]"), so we never close it
+	delete(w.knownIPs, net.IPv6unspecified.String())
+	delete(w.knownIPs, net.IPv4zero.String())
+}
+
+// start watches for address changes in the background
+func (w *interfaceWatcher) start() {
+	stopWatching, err := watchInterfaces(w.reconcile)
+	if err == nil {
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+		w.stopWatching = stopWatching
+		log.Printf("I'm watching the network interfaces for address changes")
+		return
+	}
+	log.Printf("I couldn't watch the network interfaces for address changes (%s), so I'll look every %s", err.Error(), interfacePollInterval)
+	go func() {
+		ticker := time.NewTicker(interfacePollInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if w.isStopped() {
+				return
+			}
+			w.reconcile()
+		}
+	}()
+}
+
+// stop stops binding & closing sockets, and watching for address changes
+func (w *interfaceWatcher) stop() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.stopped = true
+	if w.stopWatching != nil {
+		w.stopWatching()
+		w.stopWatching = nil
+	}
+}
+
+// openSockets returns the sockets that are open
+func (w *interfaceWatcher) openSockets() (udpConns []*net.UDPConn, tcpListeners []*net.TCPListener) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, conns := range w.udpConns {
+		udpConns = append(udpConns, conns...)
+	}
+	for _, listeners := range w.tcpListeners {
+		tcpListeners = append(tcpListeners, listeners...)
+	}
+	return udpConns, tcpListeners
+}
+
+func (w *interfaceWatcher) isStopped() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.stopped
+}
+
+// reconcile binds to the addresses that have appeared (or that we couldn't bind
+// to before) and closes the sockets on the addresses that have disappeared
+func (w *interfaceWatcher) reconcile() {
+	listIPCIDRs := w.listIPCIDRs
+	if listIPCIDRs == nil {
+		listIPCIDRs = listLocalIPCIDRs
+	}
+	currentIPs := map[string]net.IP{}
+	for _, ipCIDR := range listIPCIDRs() {
+		ip, _, err := net.ParseCIDR(ipCIDR)
+		if err != nil || !isIPInFamily(ip, w.family) {
+			continue
+		}
+		currentIPs[ip.String()] = ip
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.stopped {
+		return
+	}
+	for ipString, ip := range currentIPs {
+		// we only complain the first time we can't bind; an IPv6 address, for
+		// example, can't be bound until it has finished duplicate address detection
+		isNew := !w.knownIPs[ipString]
+		w.knownIPs[ipString] = true
+		if w.watchUDP && len(w.udpConns[ipString]) == 0 {
+			udpConn, err := listenUDP(w.family, &net.UDPAddr{IP: ip, Port: w.bindPort})
+			switch {
+			case err == nil:
+				w.udpConns[ipString] = multiplyUDPConns([]*net.UDPConn{udpConn}, w.sockets, w.family)
+				for _, udpConn := range w.udpConns[ipString] {
+					w.serveUDP(udpConn)
+				}
+				log.Printf(`I bound via UDP to the new address "%s"`, udpConn.LocalAddr().String())
+			case isNew:
+				log.Printf(`I couldn't bind via UDP to the new address "%s": %s%s`, ipString, err.Error(), w.bindHint(err))
+			}
+		}
+		if w.watchTCP && len(w.tcpListeners[ipString]) == 0 {
+			tcpListener, err := listenTCP(w.family, &net.TCPAddr{IP: ip, Port: w.bindPort})
+			switch {
+			case err == nil:
+				w.tcpListeners[ipString] = multiplyTCPListeners([]*net.TCPListener{tcpListener}, w.sockets, w.family)
+				for _, tcpListener := range w.tcpListeners[ipString] {
+					w.serveTCP(tcpListener)
+				}
+				log.Printf(`I bound via TCP to the new address "%s"`, tcpListener.Addr().String())
+			case isNew:
+				log.Printf(`I couldn't bind via TCP to the new address "%s": %s%s`, ipString, err.Error(), w.bindHint(err))
+			}
+		}
+	}
+	for ipString := range w.knownIPs {
+		if _, ok := currentIPs[ipString]; ok {
+			continue
+		}
+		delete(w.knownIPs, ipString)
+		for _, udpConn := range w.udpConns[ipString] {
+			_ = udpConn.Close() // its reader returns
+			log.Printf(`I closed the UDP socket on the removed address "%s"`, udpConn.LocalAddr().String())
+		}
+		delete(w.udpConns, ipString)
+		for _, tcpListener := range w.tcpListeners[ipString] {
+			_ = tcpListener.Close() // its reader returns; the open connections are served until they finish
+			log.Printf(`I closed the TCP listener on the removed address "%s"`, tcpListener.Addr().String())
+		}
+		delete(w.tcpListeners, ipString)
+	}
+}
+
+// bindHint explains why we couldn't bind to a new address if it's because
+// we've dropped our privileges: the unprivileged user can't bind to port 53
+func (w *interfaceWatcher) bindHint(err error) string {
+	if !w.droppedPrivileges || !isErrorPermissionsError(err) {
+		return ""
+	}
+	return fmt.Sprintf(" (I dropped my privileges, so I can't bind to port %d; restart me to bind to it)", w.bindPort)
+}
@@ -0,0 +1,73 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchInterfaces calls changed() whenever an address is added to, removed
+// from, or changed on (e.g. an IPv6 address finishes duplicate address
+// detection) a network interface. It subscribes to the kernel's rtnetlink
+// address notifications; we don't parse them, we merely take another look.
+// stop() closes the subscription, which wakes up its reader, e.g. when we
+// shut down.
+func watchInterfaces(changed func()) (stop func(), err error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	err = unix.Bind(fd, &unix.SockaddrNetlink{
+		Family: unix.AF_NETLINK,
+		Groups: unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR,
+	})
+	if err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	// we wait for the notifications in Go's poller rather than in a blocking
+	// recvfrom(), which closing the socket wouldn't interrupt
+	file := os.NewFile(uintptr(fd), "netlink")
+	rawConn, err := file.SyscallConn()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	stopped := make(chan struct{})
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			var recvErr error
+			err := rawConn.Read(func(fd uintptr) bool {
+				_, _, recvErr = unix.Recvfrom(int(fd), buf, 0)
+				return !errors.Is(recvErr, unix.EAGAIN) // EAGAIN: wait for the next notification
+			})
+			if err == nil {
+				err = recvErr
+			}
+			switch {
+			case err == nil:
+			case errors.Is(err, unix.EINTR):
+				continue
+			case errors.Is(err, unix.ENOBUFS):
+				// we've missed notifications, but we don't need them: we take another look
+			default:
+				select {
+				case <-stopped:
+				default:
+					log.Printf("I stopped watching the network interfaces for address changes: %s", err.Error())
+				}
+				return
+			}
+			changed()
+		}
+	}()
+	return func() {
+		close(stopped)
+		_ = file.Close()
+	}, nil
+}
@@ -0,0 +1,21 @@
+//go:build linux
+
+package main
+
+import (
+	"runtime"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("watchInterfaces", func() {
+	It("stops reading the notifications once it's stopped", func() {
+		goroutines := runtime.NumGoroutine()
+		stop, err := watchInterfaces(func() {})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(runtime.NumGoroutine()).To(BeNumerically(">", goroutines))
+		stop()
+		Eventually(runtime.NumGoroutine).Should(BeNumerically("<=", goroutines))
+	})
+})
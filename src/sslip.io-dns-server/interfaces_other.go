@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// watchInterfaces would notify us of address changes, but we only know how to
+// do that on Linux; elsewhere, we poll
+func watchInterfaces(_ func()) (stop func(), err error) {
+	return nil, errors.New("notifications of address changes are only supported on Linux")
+}
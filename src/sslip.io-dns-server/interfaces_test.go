@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("interfaceWatcher", func() {
+	var w *interfaceWatcher
+	var ipCIDRs []string // what the interfaces have
+	var servedUDP []*net.UDPConn
+	var servedTCP []*net.TCPListener
+
+	BeforeEach(func() {
+		ipCIDRs, servedUDP, servedTCP = nil, nil, nil
+		w = &interfaceWatcher{
+			bindPort: 0, // any free port
+			family:   "4",
+			sockets:  1,
+			watchUDP: true,
+			watchTCP: true,
+			serveUDP: func(udpConn *net.UDPConn) { servedUDP = append(servedUDP, udpConn) },
+			serveTCP: func(tcpListener *net.TCPListener) { servedTCP = append(servedTCP, tcpListener) },
+			listIPCIDRs: func() []string {
+				return ipCIDRs
+			},
+		}
+		w.adopt(nil, nil, nil)
+	})
+	AfterEach(func() {
+		w.stop()
+		udpConns, tcpListeners := w.openSockets()
+		for _, udpConn := range udpConns {
+			_ = udpConn.Close()
+		}
+		for _, tcpListener := range tcpListeners {
+			_ = tcpListener.Close()
+		}
+	})
+
+	It("binds to & serves the addresses that appear, once, in its family", func() {
+		ipCIDRs = []string{"127.0.0.1/8", "::1/128"}
+		w.reconcile()
+		udpConns, tcpListeners := w.openSockets()
+		Expect(udpConns).To(HaveLen(1))
+		Expect(udpConns[0].LocalAddr().(*net.UDPAddr).IP.String()).To(Equal("127.0.0.1"))
+		Expect(tcpListeners).To(HaveLen(1))
+		Expect(tcpListeners[0].Addr().(*net.TCPAddr).IP.String()).To(Equal("127.0.0.1"))
+		Expect(servedUDP).To(Equal(udpConns))
+		Expect(servedTCP).To(Equal(tcpListeners))
+
+		w.reconcile() // nothing has changed
+		Expect(servedUDP).To(HaveLen(1))
+		Expect(servedTCP).To(HaveLen(1))
+	})
+	It("closes the sockets on the addresses that disappear", func() {
+		ipCIDRs = []string{"127.0.0.1/8"}
+		w.reconcile()
+		udpConns, tcpListeners := w.openSockets()
+		Expect(udpConns).To(HaveLen(1))
+
+		ipCIDRs = nil
+		w.reconcile()
+		Expect(udpConns[0].Close()).To(MatchError(net.ErrClosed))
+		Expect(tcpListeners[0].Close()).To(MatchError(net.ErrClosed))
+		udpConns, tcpListeners = w.openSockets()
+		Expect(udpConns).To(BeEmpty())
+		Expect(tcpListeners).To(BeEmpty())
+		Expect(w.knownIPs).To(BeEmpty())
+	})
+	It("leaves the sockets bound to all interfaces alone", func() {
+		udpConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+		Expect(err).ToNot(HaveOccurred())
+		w.adopt([]*net.UDPConn{udpConn}, nil, nil)
+		w.reconcile()
+		udpConns, _ := w.openSockets()
+		Expect(udpConns).To(Equal([]*net.UDPConn{udpConn}))
+	})
+	It("does nothing once it's stopped", func() {
+		w.stop()
+		ipCIDRs = []string{"127.0.0.1/8"}
+		w.reconcile()
+		udpConns, tcpListeners := w.openSockets()
+		Expect(udpConns).To(BeEmpty())
+		Expect(tcpListeners).To(BeEmpty())
+	})
+})
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// listenAddress is an address from -listen, e.g. "udp://10.0.0.5:53"
+type listenAddress struct {
+	protocol string // "udp" or "tcp"
+	ip       net.IP // nil means all interfaces, e.g. "udp://:53"
+	port     int    // 0 means -port
+}
+
+// listenAddresses is the repeatable -listen flag, e.g.
+// "-listen udp://10.0.0.5:53 -listen tcp://[2001:db8::1]:5353"
+type listenAddresses []listenAddress
+
+func (l *listenAddresses) String() string {
+	if l == nil {
+		return ""
+	}
+	var addresses []string
+	for _, address := range *l {
+		addresses = append(addresses, address.String())
+	}
+	return strings.Join(addresses, ",")
+}
+
+func (l *listenAddresses) Set(value string) error {
+	address, err := parseListenAddress(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, address)
+	return nil
+}
+
+func (address listenAddress) String() string {
+	host := ""
+	if address.ip != nil {
+		host = address.ip.String()
+	}
+	return address.protocol + "://" + net.JoinHostPort(host, strconv.Itoa(address.port))
+}
+
+// family is "4" for an IPv4 address, "6" for IPv6, and "" for all interfaces
+func (address listenAddress) family() string {
+	switch {
+	case address.ip == nil:
+		return ""
+	case address.ip.To4() != nil:
+		return "4"
+	default:
+		return "6"
+	}
+}
+
+func parseListenAddress(value string) (listenAddress, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return listenAddress{}, err
+	}
+	if u.Scheme != "udp" && u.Scheme != "tcp" {
+		return listenAddress{}, fmt.Errorf(`"%s" must start with "udp://" or "tcp://"`, value)
+	}
+	if u.Path != "" || u.RawQuery != "" || u.User != nil {
+		return listenAddress{}, fmt.Errorf(`"%s" must be only a protocol, an address, and, optionally, a port`, value)
+	}
+	address := listenAddress{protocol: u.Scheme}
+	if u.Hostname() != "" {
+		if address.ip = net.ParseIP(u.Hostname()); address.ip == nil {
+			return listenAddress{}, fmt.Errorf(`"%s" must have an IP address, not "%s"`, value, u.Hostname())
+		}
+	}
+	if u.Port() != "" {
+		port, err := strconv.ParseUint(u.Port(), 10, 16)
+		if err != nil {
+			return listenAddress{}, fmt.Errorf(`"%s" has an invalid port "%s"`, value, u.Port())
+		}
+		address.port = int(port)
+	}
+	return address, nil
+}
+
+// bindListenAddresses binds to exactly the -listen addresses. Unlike our
+// default binding, we don't skip the addresses we can't bind to: someone asked
+// for them explicitly, so we bail out.
+func bindListenAddresses(addresses listenAddresses, bindPort int, family string) (udpConns []*net.UDPConn, tcpListeners []*net.TCPListener) {
+	for _, address := range addresses {
+		if address.port == 0 {
+			address.port = bindPort
+		}
+		if family != "" && address.family() != "" && address.family() != family {
+			log.Fatalf(`I can't listen on "%s" because I was told to use only IPv%s`, address.String(), family)
+		}
+		switch address.protocol {
+		case "udp":
+			udpConn, err := listenUDP(family, &net.UDPAddr{IP: address.ip, Port: address.port})
+			if err != nil {
+				log.Fatalf(`I couldn't bind via UDP to "%s": %s`, address.String(), err.Error())
+			}
+			udpConns = append(udpConns, udpConn)
+		case "tcp":
+			tcpListener, err := listenTCP(family, &net.TCPAddr{IP: address.ip, Port: address.port})
+			if err != nil {
+				log.Fatalf(`I couldn't bind via TCP to "%s": %s`, address.String(), err.Error())
+			}
+			tcpListeners = append(tcpListeners, tcpListener)
+		}
+	}
+	return udpConns, tcpListeners
+}
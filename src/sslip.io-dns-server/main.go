@@ -1,24 +1,41 @@
 package main
 
 import (
-	"encoding/binary"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"flag"
 	"log"
+	"math"
 	"net"
+	"net/http"
 	"os"
+	"os/exec"
+	"os/signal"
 	"runtime"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
+	"unicode"
 	"xip/xip"
 )
 
+// listenConfig is used to open all our UDP & TCP sockets. When -sockets is
+// greater than 1, its Control sets SO_REUSEPORT so that several sockets can
+// bind to the same address.
+var listenConfig net.ListenConfig
+
 func main() {
+	var configFile = flag.String("config", "", `path of a YAML or JSON configuration file whose settings are the defaults of the flags; flags on the command line override them. See the README for its schema. Example "-config /etc/sslip.io/config.yaml"`)
+	var checkConfig = flag.Bool("check-config", false, "validate the configuration (-config, and the files that the flags refer to) and exit: 0 if it's valid, 1 if it isn't")
 	var blocklistURL = flag.String("blocklistURL",
 		"https://raw.githubusercontent.com/cunnie/sslip.io/main/etc/blocklist.txt",
-		`URL containing a list of non-resolvable IPs/names/CIDRs, usually phishing or scamming sites. Example "file://../../etc/blocklist.txt"`)
+		`URL containing a list of non-resolvable IPs/names/CIDRs, usually phishing or scamming sites; "" means no blocklist. Example "file://../../etc/blocklist.txt"`)
 	var nameservers = flag.String("nameservers", "ns-aws.sslip.io.,ns-azure.sslip.io.,ns-gce.sslip.io.",
-		"comma-separated list of FQDNs of nameservers. If you're running your own sslip.io nameservers, set them here")
+		`comma-separated list of FQDNs of nameservers. If you're running your own sslip.io nameservers, set them here. May be a file, e.g. "file:///etc/sslip.io/nameservers", which is re-read on SIGHUP`)
 	var addresses = flag.String("addresses",
 		"sslip.io=78.46.204.247,"+
 			"sslip.io=2a01:4f8:c17:b8f::2,"+
@@ -30,59 +47,234 @@ func main() {
 			"ns-aws.sslip.io=2600:1f18:aaf:6900::a,"+
 			"ns-azure.sslip.io=52.187.42.158,"+
 			"ns-gce.sslip.io=104.155.144.4",
-		"comma-separated list of hosts and corresponding IPv4 and/or IPv6 address(es). If you're running your own sslip.io nameservers, add their hostnames and addresses here. If unsure, add to the list rather than replace")
+		`comma-separated list of hosts and corresponding IPv4 and/or IPv6 address(es). If you're running your own sslip.io nameservers, add their hostnames and addresses here. If unsure, add to the list rather than replace. May be a file, e.g. "file:///etc/sslip.io/addresses", which is re-read on SIGHUP`)
+	var soaMName = flag.String("soa-mname", "", "the SOA's MNAME (primary nameserver); defaults to the first of -nameservers")
+	var soaMBox = flag.String("soa-mbox", xip.DefaultSOAConfig.MBox, `the SOA's RNAME (administrator's email address, with "." instead of "@")`)
+	var soaSerial = flag.Uint("soa-serial", 0, "the SOA's serial; 0 derives it from the -blocklistURL, -nameservers, -addresses, and SOA flags, so it changes when they do (e.g. on SIGHUP), but is the same on nameservers with the same configuration")
+	var soaRefresh = flag.Uint("soa-refresh", uint(xip.DefaultSOAConfig.Refresh), "the SOA's refresh interval, in seconds")
+	var soaRetry = flag.Uint("soa-retry", uint(xip.DefaultSOAConfig.Retry), "the SOA's retry interval, in seconds")
+	var soaExpire = flag.Uint("soa-expire", uint(xip.DefaultSOAConfig.Expire), "the SOA's expire interval, in seconds")
+	var soaMinimum = flag.Uint("soa-minimum", uint(xip.DefaultSOAConfig.MinTTL), "the SOA's MINIMUM, in seconds, which is also how long resolvers cache our negative answers (NXDOMAIN & NODATA)")
+	var ttlEmbeddedIP = flag.Uint("ttl-embedded-ip", uint(xip.DefaultTTLs.EmbeddedIP), `TTL, in seconds, of the records derived from the name: the A/AAAA of its embedded IP (e.g. "127-0-0-1.sslip.io"), the MX pointing to it, and PTR`)
+	var ttlCustomization = flag.Uint("ttl-customization", uint(xip.DefaultTTLs.Customization), "TTL, in seconds, of customized A, AAAA, CNAME, and MX records, e.g. -addresses")
+	var ttlTXT = flag.Uint("ttl-txt", uint(xip.DefaultTTLs.TXT), `TTL, in seconds, of TXT records, e.g. "ip.sslip.io"`)
+	var ttlNS = flag.Uint("ttl-ns", uint(xip.DefaultTTLs.NS), "TTL, in seconds, of NS records and of the nameservers' addresses")
+	var ttlSOA = flag.Uint("ttl-soa", uint(xip.DefaultTTLs.SOA), "TTL, in seconds, of the SOA record when it's queried")
+	var ttlNegative = flag.Uint("ttl-negative", uint(xip.DefaultTTLs.Negative), "TTL, in seconds, of NXDOMAIN & NODATA answers; no more than -soa-minimum")
+	var ttlBlocked = flag.Uint("ttl-blocked", uint(xip.DefaultTTLs.Blocked), "TTL, in seconds, of the address we return in place of a blocked one")
+	var zones = flag.String("zones", "", `comma-separated zones we're authoritative for, e.g. "sslip.io,nip.io,ip.example.com,in-addr.arpa,ip6.arpa"; include the apexes of the domains you white-label, and the reverse zones if you want PTR answers. May be a file ("file:///etc/sslip.io/zones"), which is re-read on SIGHUP. "" means every zone`)
+	var zoneFile = flag.String("zonefile", "", `path of an RFC 1035 zone file whose records ($ORIGIN, $TTL, A, AAAA, CNAME, MX, TXT, NS, SRV, CAA) replace the built-in records of the same names, e.g. "/etc/sslip.io/sslip.io.zone". Re-read on SIGHUP`)
+	var tenants = flag.String("tenants", "", `path of a JSON file of white-label zones, each with its own apex, nameservers, SOA contact, records, and PTR domain, e.g. [{"apex": "xip.example.com", "nameservers": ["ns1.example.com"], "mbox": "hostmaster.example.com", "ptr_domain": "xip.example.com", "addresses": ["www.xip.example.com=10.0.0.1"]}]. Re-read on SIGHUP`)
+	var outOfZone = flag.String("out-of-zone", "refuse", `what to do with queries for names outside -zones: "refuse" (answer REFUSED) or "answer" (answer them anyway, the legacy behavior)`)
 	var bindPort = flag.Int("port", 53, "port the DNS server should bind to")
+	var listens listenAddresses
+	flag.Var(&listens, "listen", `address to bind to instead of all interfaces; may be repeated. The port defaults to -port. Example "-listen udp://10.0.0.5:53 -listen tcp://[2001:db8::1]:5353"`)
+	var ipv4Only = flag.Bool("ipv4-only", false, "bind only to IPv4 addresses")
+	var ipv6Only = flag.Bool("ipv6-only", false, "bind only to IPv6 addresses")
+	var tlsCert = flag.String("tls-cert", "",
+		`path to the PEM-encoded TLS certificate (chain) for DNS-over-TLS (DoT); requires -tls-key. Example "/etc/letsencrypt/live/ns-aws.sslip.io/fullchain.pem"`)
+	var tlsKey = flag.String("tls-key", "",
+		`path to the PEM-encoded private key for DNS-over-TLS (DoT); requires -tls-cert. Example "/etc/letsencrypt/live/ns-aws.sslip.io/privkey.pem"`)
+	var tlsPort = flag.Int("tls-port", 853, "port the DNS-over-TLS (DoT) server should bind to; ignored unless -tls-cert and -tls-key are set")
+	var dohPort = flag.Int("doh-port", 0, `port the DNS-over-HTTPS (DoH) server should bind to, usually 443. Requires -tls-cert and -tls-key. Queries are served from the "/dns-query" path. 0 disables DoH`)
+	var tcpIdleTimeout = flag.Duration("tcp-idle-timeout", xip.DefaultTCPIdleTimeout, "how long an open TCP (or DNS-over-TLS) connection may sit idle between queries before we close it")
+	var tcpReadTimeout = flag.Duration("tcp-read-timeout", xip.DefaultTCPReadTimeout, "how long a TCP (or DNS-over-TLS) client has to finish sending a query it has started, or to read our response")
+	var tcpMaxConnections = flag.Int("tcp-max-connections", xip.DefaultTCPMaxConnections, "maximum number of concurrent TCP (and DNS-over-TLS) connections; we hang up on new connections beyond that")
+	var udpWorkers = flag.Int("udp-workers", xip.DefaultUDPWorkers, "number of goroutines answering UDP queries")
+	var udpQueueSize = flag.Int("udp-queue-size", xip.DefaultUDPQueueSize, "number of UDP queries waiting for a worker; when the queue is full, we shed queries (see -udp-overflow)")
+	var udpOverflow = flag.String("udp-overflow", "drop", `what to do with UDP queries when the queue is full: "drop" (silently) or "refuse" (answer REFUSED)`)
+	var udpBatchSize = flag.Int("udp-batch-size", xip.DefaultUDPBatchSize, "maximum number of UDP packets read or written with one system call (recvmmsg/sendmmsg). Linux only; elsewhere it's always 1")
+	var sockets = flag.Int("sockets", 1, "number of UDP sockets & TCP listeners per address. When greater than 1, we set SO_REUSEPORT so that the kernel spreads the load across the sockets (and our cores). Linux only")
+	var proxyProtocolEnabled = flag.Bool("proxy-protocol", false, "expect a PROXY protocol (v1 or v2) header on TCP & DNS-over-TLS connections from the -proxy-protocol-trusted load balancers, and use the client address it contains")
+	var proxyProtocolTrusted = flag.String("proxy-protocol-trusted", "127.0.0.0/8,::1/128,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,fc00::/7", "comma-separated CIDRs of the load balancers whose PROXY protocol headers we trust")
+	var shutdownTimeout = flag.Duration("shutdown-timeout", xip.DefaultShutdownTimeout, "when we receive SIGTERM or SIGINT, how long to wait for in-flight queries to finish before exiting. Keep it shorter than Kubernetes' terminationGracePeriodSeconds")
+	var runAsUser = flag.String("user", "", "user (name or uid) to switch to once we've bound our sockets, e.g. \"nobody\"; requires starting as root")
+	var runAsGroup = flag.String("group", "", "group (name or gid) to switch to once we've bound our sockets; defaults to -user's primary group")
+	var chrootDir = flag.String("chroot", "", "directory to chroot to once we've bound our sockets. File paths we read later (e.g. \"file://\" -nameservers on SIGHUP) are relative to it")
 	var quiet = flag.Bool("quiet", false, "suppresses logging of each DNS response. Use this to avoid Google Cloud charging you $30/month to retain the logs of your GKE-based sslip.io server")
 	flag.Parse()
+	setOnCommandLine := flagsSetOnCommandLine()
+	if *configFile != "" {
+		if err := loadConfig(*configFile, setOnCommandLine); err != nil {
+			log.Fatalf("I couldn't load -config: %s", err.Error())
+		}
+	}
+	if *tcpMaxConnections < 1 {
+		log.Fatalf("-tcp-max-connections must be at least 1, not %d", *tcpMaxConnections)
+	}
+	if *udpWorkers < 1 {
+		log.Fatalf("-udp-workers must be at least 1, not %d", *udpWorkers)
+	}
+	if *udpQueueSize < 0 {
+		log.Fatalf("-udp-queue-size must not be negative, not %d", *udpQueueSize)
+	}
+	if *udpBatchSize < 1 {
+		log.Fatalf("-udp-batch-size must be at least 1, not %d", *udpBatchSize)
+	}
+	if *sockets < 1 {
+		log.Fatalf("-sockets must be at least 1, not %d", *sockets)
+	}
+	if *ipv4Only && *ipv6Only {
+		log.Fatal("I can't bind to only IPv4 and only IPv6 at the same time; pick one of -ipv4-only and -ipv6-only")
+	}
+	var family string // appended to "udp" & "tcp", e.g. "udp4"; "" means both IPv4 & IPv6
+	wildcard := "[::]"
+	switch {
+	case *ipv4Only:
+		family, wildcard = "4", "0.0.0.0"
+	case *ipv6Only:
+		family = "6"
+	}
+	if *udpOverflow != "drop" && *udpOverflow != "refuse" {
+		log.Fatalf(`-udp-overflow must be "drop" or "refuse", not "%s"`, *udpOverflow)
+	}
+	if *outOfZone != "refuse" && *outOfZone != "answer" {
+		log.Fatalf(`-out-of-zone must be "refuse" or "answer", not "%s"`, *outOfZone)
+	}
+	for name, value := range map[string]uint{"soa-serial": *soaSerial, "soa-refresh": *soaRefresh, "soa-retry": *soaRetry, "soa-expire": *soaExpire, "soa-minimum": *soaMinimum,
+		"ttl-embedded-ip": *ttlEmbeddedIP, "ttl-customization": *ttlCustomization, "ttl-txt": *ttlTXT, "ttl-ns": *ttlNS, "ttl-soa": *ttlSOA, "ttl-negative": *ttlNegative, "ttl-blocked": *ttlBlocked} {
+		if value > math.MaxUint32 {
+			log.Fatalf("-%s must fit in 32 bits, i.e. be at most %d, not %d", name, uint32(math.MaxUint32), value)
+		}
+	}
+	if *checkConfig {
+		if err := checkFiles(*nameservers, *addresses, *zoneFile, *tenants); err != nil {
+			log.Fatalf("The configuration is invalid: %s", err.Error())
+		}
+		log.Printf("The configuration is valid")
+		os.Exit(0)
+	}
 	log.Printf("%s version %s starting", os.Args[0], xip.VersionSemantic)
 	log.Printf("blocklist URL: %s, name servers: %s, bind port: %d, quiet: %t",
 		*blocklistURL, *nameservers, *bindPort, *quiet)
 
-	x, logmessages := xip.NewXip(*blocklistURL, strings.Split(*nameservers, ","), strings.Split(*addresses, ","))
+	nameserverList, err := readListFlag(*nameservers)
+	if err != nil {
+		log.Fatalf("I couldn't read -nameservers: %s", err.Error())
+	}
+	addressList, err := readListFlag(*addresses)
+	if err != nil {
+		log.Fatalf("I couldn't read -addresses: %s", err.Error())
+	}
+	x, logmessages := xip.NewXip(*blocklistURL, nameserverList, addressList)
 	for _, logmessage := range logmessages {
 		log.Println(logmessage)
 	}
+	logmessage, err := x.SetSOA(xip.SOAConfig{
+		MName:   *soaMName,
+		MBox:    *soaMBox,
+		Serial:  uint32(*soaSerial),
+		Refresh: uint32(*soaRefresh),
+		Retry:   uint32(*soaRetry),
+		Expire:  uint32(*soaExpire),
+		MinTTL:  uint32(*soaMinimum),
+	})
+	if err != nil {
+		log.Fatalf("I couldn't configure the SOA: %s", err.Error())
+	}
+	log.Println(logmessage)
+	x.SetTTLs(xip.TTLs{
+		EmbeddedIP:    uint32(*ttlEmbeddedIP),
+		Customization: uint32(*ttlCustomization),
+		TXT:           uint32(*ttlTXT),
+		NS:            uint32(*ttlNS),
+		SOA:           uint32(*ttlSOA),
+		Negative:      uint32(*ttlNegative),
+		Blocked:       uint32(*ttlBlocked),
+	})
+	if *zoneFile != "" {
+		logmessage, err = x.SetZoneFile(*zoneFile)
+		if err != nil {
+			log.Fatalf("I couldn't load -zonefile: %s", err.Error())
+		}
+		log.Println(logmessage)
+	}
+	if *tenants != "" {
+		tenantConfigs, err := readTenants(*tenants)
+		if err != nil {
+			log.Fatalf("I couldn't read -tenants: %s", err.Error())
+		}
+		for _, logmessage := range x.SetTenants(tenantConfigs) {
+			log.Println(logmessage)
+		}
+	}
+	zoneList, err := readListFlag(*zones)
+	if err != nil {
+		log.Fatalf("I couldn't read -zones: %s", err.Error())
+	}
+	for _, logmessage := range x.SetZones(zoneList, *outOfZone == "answer") {
+		log.Println(logmessage)
+	}
+
+	// systemd (socket activation) or our predecessor (upgrade) may have bound our sockets for us
+	inherited, err := inheritSockets()
+	if err != nil {
+		log.Fatalf("I couldn't use the sockets I was handed: %s", err.Error())
+	}
 
 	var udpConns []*net.UDPConn
 	var tcpListeners []*net.TCPListener
 	var unboundUDPIPs []string
 	var unboundTCPIPs []string
-	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: *bindPort})
-	switch {
-	case err == nil: // success! We've bound to all interfaces
-		udpConns = append(udpConns, udpConn)
-	case isErrorPermissionsError(err):
-		log.Printf("Try invoking me with `sudo` because I don't have permission to bind to UDP port %d.\n", *bindPort)
-		log.Fatal(err.Error())
-	case isErrorAddressAlreadyInUse(err):
-		log.Printf("I couldn't bind via UDP to \"[::]:%d\" (INADDR_ANY, all interfaces), so I'll try to bind to each address individually.\n", *bindPort)
-		udpConns, unboundUDPIPs = bindUDPAddressesIndividually(*bindPort)
-		if len(unboundUDPIPs) > 0 {
-			log.Printf(`I couldn't bind via UDP to the following IPs: "%s"`, strings.Join(unboundUDPIPs, `", "`))
+	var udpIndividually, tcpIndividually bool // we couldn't bind to all interfaces, so addresses may come & go
+	if *sockets > 1 && !inherited.hasDNS() {
+		if !reusePortSupported {
+			log.Fatalf("-sockets %d requires SO_REUSEPORT load-balancing, which %s doesn't have", *sockets, runtime.GOOS)
 		}
-	default:
-		log.Fatal(err.Error())
+		listenConfig.Control = reusePort
 	}
-	tcpListener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: *bindPort})
-	switch {
-	case err == nil: // success! We've bound to all interfaces
-		tcpListeners = append(tcpListeners, tcpListener)
-	case isErrorPermissionsError(err): // unnecessary because it should've bombed out earlier when attempting to bind UDP
-		log.Printf("Try invoking me with `sudo` because I don't have permission to bind to TCP port %d.\n", *bindPort)
-		log.Println(err.Error())
-	case isErrorAddressAlreadyInUse(err):
-		log.Printf("I couldn't bind via TCP to \"[::]:%d\" (INADDR_ANY, all interfaces), so I'll try to bind to each address individually.\n", *bindPort)
-		tcpListeners, unboundTCPIPs = bindTCPAddressesIndividually(*bindPort)
-		if len(unboundTCPIPs) > 0 {
-			log.Printf(`I couldn't bind via TCP to the following IPs: "%s"`, strings.Join(unboundTCPIPs, `", "`))
+	if inherited.hasDNS() {
+		udpConns, tcpListeners = inherited.udpConns, inherited.tcpListeners
+	} else if len(listens) > 0 {
+		udpConns, tcpListeners = bindListenAddresses(listens, *bindPort, family)
+	} else {
+		udpConn, err := listenUDP(family, &net.UDPAddr{Port: *bindPort})
+		switch {
+		case err == nil: // success! We've bound to all interfaces
+			udpConns = append(udpConns, udpConn)
+		case isErrorPermissionsError(err):
+			log.Printf("Try invoking me with `sudo`, or letting systemd bind the socket for me, because I don't have permission to bind to UDP port %d.\n", *bindPort)
+			log.Fatal(err.Error())
+		case isErrorAddressAlreadyInUse(err):
+			log.Printf("I couldn't bind via UDP to \"%s:%d\" (INADDR_ANY, all interfaces), so I'll try to bind to each address individually.\n", wildcard, *bindPort)
+			udpConns, unboundUDPIPs = bindUDPAddressesIndividually(*bindPort, family)
+			udpIndividually = true
+			if len(unboundUDPIPs) > 0 {
+				log.Printf(`I couldn't bind via UDP to the following IPs: "%s"`, strings.Join(unboundUDPIPs, `", "`))
+			}
+		default:
+			log.Fatal(err.Error())
 		}
-	default:
-		log.Println(err.Error()) // Unlike UDP, we don't exit on TCP errors, we merely log
+		tcpListener, err := listenTCP(family, &net.TCPAddr{Port: *bindPort})
+		switch {
+		case err == nil: // success! We've bound to all interfaces
+			tcpListeners = append(tcpListeners, tcpListener)
+		case isErrorPermissionsError(err): // unnecessary because it should've bombed out earlier when attempting to bind UDP
+			log.Printf("Try invoking me with `sudo`, or letting systemd bind the socket for me, because I don't have permission to bind to TCP port %d.\n", *bindPort)
+			log.Println(err.Error())
+		case isErrorAddressAlreadyInUse(err):
+			log.Printf("I couldn't bind via TCP to \"%s:%d\" (INADDR_ANY, all interfaces), so I'll try to bind to each address individually.\n", wildcard, *bindPort)
+			tcpListeners, unboundTCPIPs = bindTCPAddressesIndividually(*bindPort, family)
+			tcpIndividually = true
+			if len(unboundTCPIPs) > 0 {
+				log.Printf(`I couldn't bind via TCP to the following IPs: "%s"`, strings.Join(unboundTCPIPs, `", "`))
+			}
+		default:
+			log.Println(err.Error()) // Unlike UDP, we don't exit on TCP errors, we merely log
+		}
+	}
+	if len(udpConns) == 0 && !inherited.hasDNS() { // couldn't bind to UDP anywhere? exit
+		log.Fatalf("I couldn't bind via UDP to any IPs on port %d, so I'm exiting", *bindPort)
 	}
-	if len(tcpListeners) == 0 {
+	if len(tcpListeners) == 0 && len(listens) == 0 && !inherited.hasDNS() {
 		// unlike UDP failure to bind, we don't exit because TCP is optional, UDP, mandatory
 		log.Printf("I couldn't bind via TCP to any IPs on port %d", *bindPort)
 	}
+	if *sockets > 1 && !inherited.hasDNS() {
+		// the kernel spreads the queries across the sockets bound to the same address, and hence across our cores
+		udpConns = multiplyUDPConns(udpConns, *sockets, family)
+		tcpListeners = multiplyTCPListeners(tcpListeners, *sockets, family)
+	}
 
 	// Log the list of IPs that we've bound to because it helps troubleshooting
 	var boundUDPIPs []string
@@ -96,88 +288,362 @@ func main() {
 	}
 	log.Printf(`I bound via TCP to the following IPs: "%s"`, strings.Join(boundTCPIPs, `", "`))
 
-	if len(udpConns) == 0 { // couldn't bind to UDP anywhere? exit
-		log.Fatalf("I couldn't bind via UDP to any IPs on port %d, so I'm exiting", *bindPort)
+	// DNS-over-TLS & DNS-over-HTTPS are opt-in: we need a certificate, and we won't generate a self-signed one
+	var tlsConfig *tls.Config
+	if *tlsCert != "" || *tlsKey != "" {
+		if *tlsCert == "" || *tlsKey == "" {
+			log.Fatal("I need both -tls-cert and -tls-key to enable DNS-over-TLS, but I only got one of them")
+		}
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatalf(`I couldn't load the TLS certificate "%s" and key "%s": %s`, *tlsCert, *tlsKey, err.Error())
+		}
+		tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
 	}
-	if len(tcpListeners) == 0 { // couldn't bind to TCP anywhere? don't exit; TCP is optional
-		log.Printf("I couldn't bind via TCP to any IPs on port %d", *bindPort)
+	var tlsListener net.Listener
+	switch {
+	case tlsConfig == nil && inherited.tlsListener != nil:
+		log.Printf("I was handed a DNS-over-TLS socket, but I have no -tls-cert and -tls-key, so I'm closing it")
+		_ = inherited.tlsListener.Close()
+	case inherited.tlsListener != nil:
+		tlsListener = inherited.tlsListener
+		log.Printf(`I inherited DNS-over-TLS on "%s"`, tlsListener.Addr().String())
+	case tlsConfig != nil:
+		// we do the TLS ourselves, after any PROXY protocol header
+		tlsListener, err = net.Listen("tcp"+family, ":"+strconv.Itoa(*tlsPort))
+		switch {
+		case err == nil:
+			log.Printf(`I bound via DNS-over-TLS to "%s"`, tlsListener.Addr().String())
+		case isErrorPermissionsError(err):
+			log.Printf("Try invoking me with `sudo`, or letting systemd bind the socket for me, because I don't have permission to bind to TCP port %d for DNS-over-TLS.\n", *tlsPort)
+			log.Println(err.Error())
+		default:
+			log.Println(err.Error()) // Like TCP, DNS-over-TLS is optional, so we merely log
+		}
+	}
+	var dohListener net.Listener
+	if (*dohPort != 0 || inherited.dohListener != nil) && tlsConfig == nil {
+		log.Fatal("I need -tls-cert and -tls-key to enable DNS-over-HTTPS")
+	}
+	switch {
+	case inherited.dohListener != nil:
+		dohListener = inherited.dohListener
+		log.Printf(`I inherited DNS-over-HTTPS on "%s"`, dohListener.Addr().String())
+	case *dohPort != 0:
+		dohListener, err = net.Listen("tcp"+family, ":"+strconv.Itoa(*dohPort))
+		switch {
+		case err == nil:
+			log.Printf(`I bound via DNS-over-HTTPS to "%s"`, dohListener.Addr().String())
+		case isErrorPermissionsError(err):
+			log.Printf("Try invoking me with `sudo`, or letting systemd bind the socket for me, because I don't have permission to bind to TCP port %d for DNS-over-HTTPS.\n", *dohPort)
+			log.Println(err.Error())
+		default:
+			log.Println(err.Error()) // Like DNS-over-TLS, DNS-over-HTTPS is optional, so we merely log
+		}
+	}
+
+	// we no longer need root: we've bound our sockets and read our TLS key
+	if *runAsUser != "" || *runAsGroup != "" || *chrootDir != "" {
+		if err = dropPrivileges(*runAsUser, *runAsGroup, *chrootDir); err != nil {
+			log.Fatal(err.Error())
+		}
 	}
 
 	// Read from the UDP connections & TCP Listeners
-	// use goroutines to read from all the UDP connections EXCEPT the first; we don't use a goroutine for that
-	// one because we use the first one to keep this program from exiting
-	for _, udpConn := range udpConns[1:] {
-		go readFromUDP(udpConn, x, *quiet)
+	server := xip.NewServer(x, nil) // we've bound the sockets ourselves
+	server.Quiet = *quiet
+	server.UDPWorkers = *udpWorkers
+	server.UDPQueueSize = *udpQueueSize
+	server.UDPBatchSize = *udpBatchSize
+	server.UDPRefuse = *udpOverflow == "refuse"
+	server.TCPIdleTimeout = *tcpIdleTimeout
+	server.TCPReadTimeout = *tcpReadTimeout
+	server.TCPMaxConnections = *tcpMaxConnections
+	var proxy *proxyProtocol // nil unless -proxy-protocol
+	if *proxyProtocolEnabled {
+		proxy, err = newProxyProtocol(*proxyProtocolTrusted)
+		if err != nil {
+			log.Fatalf(`I couldn't parse -proxy-protocol-trusted "%s": %s`, *proxyProtocolTrusted, err.Error())
+		}
+	}
+	serveUDP := func(udpConn *net.UDPConn) {
+		_ = server.ServeUDP(udpConn) // it only fails once we're shutting down
+	}
+	serveTCP := func(tcpListener *net.TCPListener) {
+		_ = server.ServeStream(tcpListener, prepareStream(proxy, nil, *tcpReadTimeout))
+	}
+	for _, udpConn := range udpConns {
+		serveUDP(udpConn)
 	}
 	for _, tcpListener := range tcpListeners {
-		go readFromTCP(tcpListener, x, *quiet)
+		serveTCP(tcpListener)
+	}
+	if tlsListener != nil {
+		_ = server.ServeStream(tlsListener, prepareStream(proxy, tlsConfig, *tcpReadTimeout))
+	}
+	var dohServer *http.Server
+	if dohListener != nil {
+		dohServer = newDoHServer(tlsConfig, x, *quiet)
+		go serveDoH(dohServer, dohListener)
+	}
+	var watcher *interfaceWatcher
+	if udpIndividually || tcpIndividually {
+		watcher = &interfaceWatcher{
+			bindPort: *bindPort,
+			family:   family,
+			sockets:  *sockets,
+			watchUDP: udpIndividually,
+			watchTCP: tcpIndividually,
+			serveUDP: serveUDP,
+			serveTCP: serveTCP,
+			// -user can't bind to port 53; root (-group & -chroot alone) can
+			droppedPrivileges: *runAsUser != "",
+		}
+		watcher.adopt(udpConns, tcpListeners, append(unboundUDPIPs, unboundTCPIPs...))
+		watcher.start()
 	}
 	log.Printf("Ready to answer queries")
-	readFromUDP(udpConns[0], x, *quiet) // refrain from exiting; There should always be a udpConns[0], and readFromUDP() _never_ returns
-}
+	inherited.tellPredecessorToExit()
 
-func readFromUDP(conn *net.UDPConn, x *xip.Xip, quiet bool) {
+	// Kubernetes sends SIGTERM when it restarts our pod; Ctrl-C sends SIGINT; SIGHUP means "reload";
+	// SIGUSR2 means "upgrade": hand our sockets to a new copy of ourselves, which tells us to exit when it's ready
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, append([]os.Signal{syscall.SIGTERM, os.Interrupt, syscall.SIGHUP}, upgradeSignals...)...)
+	var successor *exec.Cmd
+	successorExited := make(chan error, 1)
+	var sig os.Signal
+waitForSignals:
 	for {
-		query := make([]byte, 512)
-		_, addr, err := conn.ReadFromUDP(query)
-		if err != nil {
-			log.Println(err.Error())
-			continue
+		select {
+		case err := <-successorExited:
+			log.Printf("My successor (pid %d) exited before it was ready, so I'll carry on: %v", successor.Process.Pid, err)
+			successor = nil
+		case sig = <-signals:
+			switch {
+			case sig == syscall.SIGHUP:
+				reload(x, *configFile, setOnCommandLine)
+			case len(upgradeSignals) > 0 && sig == upgradeSignals[0]:
+				if successor != nil {
+					log.Printf("I received %s, but I'm already waiting for my successor (pid %d) to be ready", sig, successor.Process.Pid)
+					continue
+				}
+				currentUDPConns, currentTCPListeners := udpConns, tcpListeners
+				if watcher != nil {
+					currentUDPConns, currentTCPListeners = watcher.openSockets()
+				}
+				successor, err = startSuccessor(currentUDPConns, currentTCPListeners, tlsListener, dohListener)
+				if err != nil {
+					log.Printf("I received %s, but I couldn't start my successor, so I'll carry on: %s", sig, err.Error())
+					continue
+				}
+				log.Printf("I received %s, so I started my successor (pid %d) and handed it my sockets", sig, successor.Process.Pid)
+				go func(successor *exec.Cmd) {
+					successorExited <- successor.Wait()
+				}(successor)
+			default:
+				break waitForSignals
+			}
 		}
+	}
+	log.Printf("I received %s, so I'm no longer accepting queries; I'll wait up to %s for in-flight queries to finish", sig, *shutdownTimeout)
+	if watcher != nil {
+		watcher.stop() // the server closes the sockets the watcher has opened
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	dohShutdown := make(chan error, 1)
+	if dohServer != nil {
 		go func() {
-			response, logMessage, err := x.QueryResponse(query, addr.IP)
-			if err != nil {
-				log.Println(err.Error())
-				return
-			}
-			_, err = conn.WriteToUDP(response, addr)
-			if !quiet {
-				log.Printf("%v.%d %s", addr.IP, addr.Port, logMessage)
-			}
-			x.Metrics.UDPQueries += 1
+			dohShutdown <- dohServer.Shutdown(ctx)
 		}()
+	} else {
+		dohShutdown <- nil
+	}
+	err = server.Shutdown(ctx)
+	if dohErr := <-dohShutdown; err == nil {
+		err = dohErr
+	}
+	if err == nil {
+		log.Printf("I finished answering the in-flight queries")
+	} else {
+		log.Printf("I gave up waiting for the in-flight queries to finish after %s", *shutdownTimeout)
 	}
+	for _, metric := range x.MetricsSummary() {
+		log.Printf("Final metrics: %s", metric)
+	}
+	log.Printf("%s version %s exiting", os.Args[0], xip.VersionSemantic)
 }
 
-func readFromTCP(tcpListener *net.TCPListener, x *xip.Xip, quiet bool) {
-	for {
-		query := make([]byte, 65535) // 2-byte length field means largest size is 65535
-		tcpConn, err := tcpListener.AcceptTCP()
-		if err != nil {
-			log.Println(err.Error())
+// reload re-reads -config, if any, and the files that the reloadableFlags
+// name (-nameservers, -addresses, & -zones may be files; -tenants, -zonefile),
+// and re-downloads the blocklist. It reads & parses all of them before it
+// replaces any of the configuration, so if it can't, we keep the whole
+// previous configuration rather than load half of a new one.
+func reload(x *xip.Xip, configFile string, setOnCommandLine map[string]bool) {
+	log.Printf("I received SIGHUP, so I'm reloading my configuration")
+	keepPrevious := func(flagName string, err error) {
+		log.Printf("I couldn't reload my configuration, so I'm keeping the previous one: -%s: %s", flagName, err.Error())
+	}
+	values, err := reloadConfig(configFile, setOnCommandLine)
+	if err != nil {
+		keepPrevious("config", err)
+		return
+	}
+	if values["out-of-zone"] != "refuse" && values["out-of-zone"] != "answer" {
+		keepPrevious("out-of-zone", errors.New(`must be "refuse" or "answer", not "`+values["out-of-zone"]+`"`))
+		return
+	}
+	c := xip.Configuration{
+		BlocklistURL:    values["blocklistURL"],
+		ZoneFile:        values["zonefile"],
+		AnswerOutOfZone: values["out-of-zone"] == "answer",
+	}
+	if c.NameServers, err = readListFlag(values["nameservers"]); err != nil {
+		keepPrevious("nameservers", err)
+		return
+	}
+	if c.Addresses, err = readListFlag(values["addresses"]); err != nil {
+		keepPrevious("addresses", err)
+		return
+	}
+	if c.Zones, err = readListFlag(values["zones"]); err != nil {
+		keepPrevious("zones", err)
+		return
+	}
+	if values["tenants"] != "" {
+		if c.Tenants, err = readTenants(values["tenants"]); err != nil {
+			keepPrevious("tenants", err)
+			return
+		}
+	}
+	logmessages, err := x.Reconfigure(c)
+	if err != nil {
+		keepPrevious("zonefile", err)
+		return
+	}
+	for _, logmessage := range logmessages {
+		log.Println(logmessage)
+	}
+	if configFile != "" {
+		log.Printf("I re-read -config's %s settings; its others take effect when I restart", strings.Join(reloadableFlags, ", "))
+	}
+	log.Printf("I reloaded my configuration")
+}
+
+// readTenants reads the -tenants JSON file
+func readTenants(path string) (tenantConfigs []xip.TenantConfig, err error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.DisallowUnknownFields() // e.g. a misspelled "nameservers"
+	if err = decoder.Decode(&tenantConfigs); err != nil {
+		return nil, err
+	}
+	return tenantConfigs, nil
+}
+
+// readListFlag splits a comma-separated flag, e.g. -addresses, into its
+// elements. If it's a file ("file:///etc/sslip.io/addresses"), the elements
+// may also be separated by whitespace or newlines, and lines starting with "#"
+// are comments.
+func readListFlag(value string) ([]string, error) {
+	if !strings.HasPrefix(value, "file://") {
+		return strings.Split(value, ","), nil
+	}
+	contents, err := os.ReadFile(strings.TrimPrefix(value, "file://"))
+	if err != nil {
+		return nil, err
+	}
+	var elements []string
+	for _, line := range strings.Split(string(contents), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
 			continue
 		}
-		_, err = tcpConn.Read(query)
-		query = query[2:] // remove the 2-byte length at the beginning of the query
+		elements = append(elements, strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})...)
+	}
+	return elements, nil
+}
+
+// prepareStream returns what ServeStream() does to a new TCP connection before
+// reading its queries: if it's from a trusted load balancer, we first read its
+// PROXY protocol header (which precedes the TLS handshake); if tlsConfig isn't
+// nil, it's a DNS-over-TLS connection. The handshake happens on the first
+// Read(), so the idle timeout covers it, too.
+func prepareStream(proxy *proxyProtocol, tlsConfig *tls.Config, readTimeout time.Duration) func(net.Conn) (net.Conn, error) {
+	return func(conn net.Conn) (net.Conn, error) {
+		streamConn, err := proxy.accept(conn, readTimeout)
 		if err != nil {
-			log.Println(err.Error())
-			continue
+			log.Printf("I couldn't read the PROXY protocol header from %s, so I'm hanging up: %s", conn.RemoteAddr().String(), err.Error())
+			return nil, err
 		}
-		remoteAddrPort := tcpConn.RemoteAddr().String()
-		addr, port, err := net.SplitHostPort(remoteAddrPort)
+		if tlsConfig != nil {
+			streamConn = tls.Server(streamConn, tlsConfig)
+		}
+		return streamConn, nil
+	}
+}
 
-		go func() {
-			defer tcpConn.Close()
-			response, logMessage, err := x.QueryResponse(query, net.ParseIP(addr))
+// listenUDP is net.ListenUDP(), but with our listenConfig. family is "4" (IPv4
+// only), "6" (IPv6 only), or "" (both)
+func listenUDP(family string, addr *net.UDPAddr) (*net.UDPConn, error) {
+	packetConn, err := listenConfig.ListenPacket(context.Background(), "udp"+family, addr.String())
+	if err != nil {
+		return nil, err
+	}
+	return packetConn.(*net.UDPConn), nil
+}
+
+// listenTCP is net.ListenTCP(), but with our listenConfig; see listenUDP()
+func listenTCP(family string, addr *net.TCPAddr) (*net.TCPListener, error) {
+	listener, err := listenConfig.Listen(context.Background(), "tcp"+family, addr.String())
+	if err != nil {
+		return nil, err
+	}
+	return listener.(*net.TCPListener), nil
+}
+
+// multiplyUDPConns opens sockets-1 more sockets on each of the UDP connections' addresses
+func multiplyUDPConns(udpConns []*net.UDPConn, sockets int, family string) []*net.UDPConn {
+	var multiplied []*net.UDPConn
+	for _, udpConn := range udpConns {
+		multiplied = append(multiplied, udpConn)
+		for i := 1; i < sockets; i++ {
+			extraConn, err := listenUDP(family, udpConn.LocalAddr().(*net.UDPAddr))
 			if err != nil {
-				log.Println(err.Error())
-				return
+				log.Printf(`I couldn't open another UDP socket on "%s": %s`, udpConn.LocalAddr().String(), err.Error())
+				break
 			}
-			// insert the 2-byte length to the beginning of the response
-			responseSize := uint16(len(response))
-			responseSizeBigEndianBytes := make([]byte, 2)
-			binary.BigEndian.PutUint16(responseSizeBigEndianBytes, responseSize)
-			response = append(responseSizeBigEndianBytes, response...)
-			_, err = tcpConn.Write(response)
-			if !quiet {
-				log.Printf("%s.%s %s", addr, port, logMessage)
+			multiplied = append(multiplied, extraConn)
+		}
+	}
+	return multiplied
+}
+
+// multiplyTCPListeners opens sockets-1 more listeners on each of the TCP listeners' addresses
+func multiplyTCPListeners(tcpListeners []*net.TCPListener, sockets int, family string) []*net.TCPListener {
+	var multiplied []*net.TCPListener
+	for _, tcpListener := range tcpListeners {
+		multiplied = append(multiplied, tcpListener)
+		for i := 1; i < sockets; i++ {
+			extraListener, err := listenTCP(family, tcpListener.Addr().(*net.TCPAddr))
+			if err != nil {
+				log.Printf(`I couldn't open another TCP listener on "%s": %s`, tcpListener.Addr().String(), err.Error())
+				break
 			}
-			x.Metrics.TCPQueries += 1
-		}()
+			multiplied = append(multiplied, extraListener)
+		}
 	}
+	return multiplied
 }
 
-func bindUDPAddressesIndividually(bindPort int) (udpConns []*net.UDPConn, unboundIPs []string) {
+func bindUDPAddressesIndividually(bindPort int, family string) (udpConns []*net.UDPConn, unboundIPs []string) {
 	ipCIDRs := listLocalIPCIDRs()
 	for _, ipCIDR := range ipCIDRs {
 		ip, _, err := net.ParseCIDR(ipCIDR)
@@ -185,7 +651,10 @@ func bindUDPAddressesIndividually(bindPort int) (udpConns []*net.UDPConn, unboun
 			log.Printf(`I couldn't parse the local interface "%s".`, ipCIDR)
 			continue
 		}
-		udpConn, err := net.ListenUDP("udp", &net.UDPAddr{
+		if !isIPInFamily(ip, family) {
+			continue
+		}
+		udpConn, err := listenUDP(family, &net.UDPAddr{
 			IP:   ip,
 			Port: bindPort,
 			Zone: "",
@@ -199,7 +668,7 @@ func bindUDPAddressesIndividually(bindPort int) (udpConns []*net.UDPConn, unboun
 	return udpConns, unboundIPs
 }
 
-func bindTCPAddressesIndividually(bindPort int) (tcpListeners []*net.TCPListener, unboundIPs []string) {
+func bindTCPAddressesIndividually(bindPort int, family string) (tcpListeners []*net.TCPListener, unboundIPs []string) {
 	ipCIDRs := listLocalIPCIDRs()
 	for _, ipCIDR := range ipCIDRs {
 		ip, _, err := net.ParseCIDR(ipCIDR)
@@ -207,7 +676,10 @@ func bindTCPAddressesIndividually(bindPort int) (tcpListeners []*net.TCPListener
 			log.Printf(`I couldn't parse the local interface "%s".`, ipCIDR)
 			continue
 		}
-		listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: ip, Port: bindPort})
+		if !isIPInFamily(ip, family) {
+			continue
+		}
+		listener, err := listenTCP(family, &net.TCPAddr{IP: ip, Port: bindPort})
 		if err != nil {
 			unboundIPs = append(unboundIPs, ip.String())
 		} else {
@@ -217,6 +689,18 @@ func bindTCPAddressesIndividually(bindPort int) (tcpListeners []*net.TCPListener
 	return tcpListeners, unboundIPs
 }
 
+// isIPInFamily is true if the IP address is IPv4 & family is "4", or IPv6 & family is "6", or family is "" (either)
+func isIPInFamily(ip net.IP, family string) bool {
+	switch family {
+	case "4":
+		return ip.To4() != nil
+	case "6":
+		return ip.To4() == nil
+	default:
+		return true
+	}
+}
+
 // TODO: replace this function with net.InterfaceAddrs() ([]Addr, error)
 // typical addr "10.9.9.161/24"
 func listLocalIPCIDRs() []string {
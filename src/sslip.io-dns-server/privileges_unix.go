@@ -0,0 +1,138 @@
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// dropPrivileges switches to an unprivileged user & group once we've bound to
+// port 53, and, if chrootDir isn't "", confines us to that directory. We look up
+// everything we need from outside the directory (the user & group, the name
+// servers in /etc/resolv.conf, the CA certificates) beforehand so that we can
+// still download the blocklist afterwards.
+func dropPrivileges(userName, groupName, chrootDir string) error {
+	uid, gid, err := lookupUserAndGroup(userName, groupName)
+	if err != nil {
+		return err
+	}
+	if chrootDir != "" {
+		if _, err = x509.SystemCertPool(); err != nil { // the pool is loaded once & cached
+			log.Printf("I couldn't load the CA certificates, so I won't be able to download a blocklist over HTTPS: %s", err.Error())
+		}
+		useResolversFrom("/etc/resolv.conf")
+		if err = syscall.Chroot(chrootDir); err != nil {
+			return fmt.Errorf(`I couldn't chroot to "%s": %w`, chrootDir, err)
+		}
+		if err = os.Chdir("/"); err != nil {
+			return fmt.Errorf(`I couldn't chdir to "/" after I chrooted to "%s": %w`, chrootDir, err)
+		}
+		log.Printf(`I chrooted to "%s"`, chrootDir)
+	}
+	if uid == -1 {
+		return nil
+	}
+	if os.Getuid() == uid && os.Getgid() == gid {
+		// e.g. we're the successor of a server that has already dropped its privileges
+		log.Printf("I'm already running as uid %d, gid %d", uid, gid)
+		return nil
+	}
+	// order matters: once we've given up root, we can't change our groups
+	if err = syscall.Setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("I couldn't set my supplementary groups to gid %d: %w", gid, err)
+	}
+	if err = syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("I couldn't switch to gid %d: %w", gid, err)
+	}
+	if err = syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("I couldn't switch to uid %d: %w", uid, err)
+	}
+	log.Printf("I dropped my privileges: I'm now running as uid %d, gid %d", uid, gid)
+	return nil
+}
+
+// lookupUserAndGroup converts -user & -group, names or numbers, to a uid & gid.
+// -group defaults to the user's primary group. A uid of -1 means "don't switch".
+func lookupUserAndGroup(userName, groupName string) (uid, gid int, err error) {
+	if userName == "" {
+		if groupName != "" {
+			return -1, -1, fmt.Errorf(`I need -user to switch to group "%s"`, groupName)
+		}
+		return -1, -1, nil
+	}
+	u, err := user.Lookup(userName)
+	if err != nil {
+		if _, numErr := strconv.Atoi(userName); numErr != nil {
+			return -1, -1, fmt.Errorf(`I couldn't find user "%s": %w`, userName, err)
+		}
+		u, err = user.LookupId(userName)
+		if err != nil {
+			u = &user.User{Uid: userName} // a uid with no entry in /etc/passwd, e.g. in a container
+		}
+	}
+	if uid, err = strconv.Atoi(u.Uid); err != nil {
+		return -1, -1, fmt.Errorf(`user "%s" has a non-numeric uid "%s"`, userName, u.Uid)
+	}
+	gidString := u.Gid
+	if groupName != "" {
+		gidString = groupName
+		if _, numErr := strconv.Atoi(groupName); numErr != nil {
+			g, err := user.LookupGroup(groupName)
+			if err != nil {
+				return -1, -1, fmt.Errorf(`I couldn't find group "%s": %w`, groupName, err)
+			}
+			gidString = g.Gid
+		}
+	}
+	if gidString == "" {
+		return -1, -1, fmt.Errorf(`user "%s" has no primary group; set -group`, userName)
+	}
+	if gid, err = strconv.Atoi(gidString); err != nil {
+		return -1, -1, fmt.Errorf(`group "%s" has a non-numeric gid "%s"`, groupName, gidString)
+	}
+	return uid, gid, nil
+}
+
+// useResolversFrom points Go's resolver at the name servers in resolvConf. Once
+// we've chrooted, the resolver can't read resolvConf, and falls back to
+// querying localhost, i.e. us, and we don't recurse.
+func useResolversFrom(resolvConf string) {
+	file, err := os.Open(resolvConf)
+	if err != nil {
+		log.Printf(`I couldn't read "%s", so I won't be able to download a blocklist: %s`, resolvConf, err.Error())
+		return
+	}
+	//noinspection GoUnhandledErrorResult
+	defer file.Close()
+	var servers []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" && net.ParseIP(fields[1]) != nil {
+			servers = append(servers, net.JoinHostPort(fields[1], "53"))
+		}
+	}
+	if len(servers) == 0 {
+		return
+	}
+	var dialer net.Dialer
+	net.DefaultResolver.PreferGo = true
+	net.DefaultResolver.Dial = func(ctx context.Context, network, _ string) (conn net.Conn, err error) {
+		for _, server := range servers {
+			if conn, err = dialer.DialContext(ctx, network, server); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
@@ -0,0 +1,9 @@
+package main
+
+import "errors"
+
+// dropPrivileges would switch to an unprivileged user, but Windows has no
+// setuid() or chroot()
+func dropPrivileges(_, _, _ string) error {
+	return errors.New("-user, -group, and -chroot aren't supported on Windows")
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proxyProtocol parses the PROXY protocol header (v1 or v2,
+// https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt) that a TCP load
+// balancer prepends to each connection so that we know who the client really is.
+// We only honor the header from the load balancers we trust; anyone else could
+// claim to be anyone.
+type proxyProtocol struct {
+	trusted []*net.IPNet
+}
+
+// proxyV2Signature starts every PROXY protocol v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyV1MaxLength is the longest v1 header, e.g. "PROXY TCP6 ffff:...:ffff ffff:...:ffff 65535 65535\r\n"
+const proxyV1MaxLength = 107
+
+func newProxyProtocol(trustedCIDRs string) (*proxyProtocol, error) {
+	p := proxyProtocol{}
+	for _, cidr := range strings.Split(trustedCIDRs, ",") {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, err
+		}
+		p.trusted = append(p.trusted, ipNet)
+	}
+	return &p, nil
+}
+
+// trusts is true if the connection comes from one of our trusted load balancers
+func (p *proxyProtocol) trusts(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, ipNet := range p.trusted {
+		if ipNet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn is a connection whose RemoteAddr() is the client's address from the
+// PROXY protocol header, not the load balancer's
+type proxyConn struct {
+	net.Conn
+	reader     *bufio.Reader // may have buffered the bytes following the header
+	remoteAddr net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// accept reads the PROXY protocol header from a trusted load balancer, and
+// returns a connection whose RemoteAddr() is the client's. Connections from
+// untrusted sources are returned as-is. p may be nil (no -proxy-protocol), in
+// which case the connection is returned as-is, too.
+func (p *proxyProtocol) accept(conn net.Conn, timeout time.Duration) (net.Conn, error) {
+	if p == nil || !p.trusts(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	signature, err := reader.Peek(len(proxyV2Signature))
+	if err != nil { // even the shortest header, "PROXY UNKNOWN\r\n", is longer than the signature
+		return nil, err
+	}
+	var remoteAddr net.Addr
+	switch {
+	case bytes.Equal(signature, proxyV2Signature):
+		remoteAddr, err = readProxyV2Header(reader)
+	case bytes.HasPrefix(signature, []byte("PROXY ")):
+		remoteAddr, err = readProxyV1Header(reader)
+	default:
+		return nil, errors.New("the connection didn't start with a PROXY protocol header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if remoteAddr == nil { // "LOCAL" or "UNKNOWN", e.g. the load balancer's health check
+		remoteAddr = conn.RemoteAddr()
+	}
+	return &proxyConn{Conn: conn, reader: reader, remoteAddr: remoteAddr}, nil
+}
+
+// readProxyV1Header reads the human-readable header, e.g. "PROXY TCP4 203.0.113.7 192.0.2.1 56324 53\r\n"
+func readProxyV1Header(reader *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLength {
+			return nil, errors.New("the PROXY protocol v1 header is too long")
+		}
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf(`the PROXY protocol v1 header "%s" is malformed`, strings.TrimSpace(string(line)))
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf(`the PROXY protocol v1 header "%s" has an invalid source address`, strings.TrimSpace(string(line)))
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2Header reads the binary header: the signature, the version &
+// command, the address family & protocol, the length of the rest, then the
+// addresses and, optionally, TLVs, which we skip
+func readProxyV2Header(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxyV2Signature)+4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	versionCommand, familyProtocol := header[12], header[13]
+	rest := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(reader, rest); err != nil {
+		return nil, err
+	}
+	if versionCommand>>4 != 2 {
+		return nil, fmt.Errorf("the PROXY protocol version %d isn't 2", versionCommand>>4)
+	}
+	switch versionCommand & 0xf {
+	case 0: // LOCAL
+		return nil, nil
+	case 1: // PROXY
+	default:
+		return nil, fmt.Errorf("the PROXY protocol v2 command %d is unknown", versionCommand&0xf)
+	}
+	var ipLength int
+	switch familyProtocol >> 4 {
+	case 1: // AF_INET
+		ipLength = net.IPv4len
+	case 2: // AF_INET6
+		ipLength = net.IPv6len
+	default: // AF_UNSPEC, AF_UNIX: no IP address to speak of
+		return nil, nil
+	}
+	// source address, destination address, source port, destination port
+	if len(rest) < 2*ipLength+4 {
+		return nil, errors.New("the PROXY protocol v2 header is too short for its addresses")
+	}
+	ip := net.IP(append([]byte(nil), rest[:ipLength]...))
+	port := binary.BigEndian.Uint16(rest[2*ipLength:])
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
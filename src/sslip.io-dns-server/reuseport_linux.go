@@ -0,0 +1,25 @@
+//go:build linux
+
+package main
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reusePortSupported is true on Linux, whose kernel load-balances the UDP
+// packets & TCP connections across the sockets sharing an address
+const reusePortSupported = true
+
+// reusePort is a net.ListenConfig Control function which sets SO_REUSEPORT
+func reusePort(_, _ string, c syscall.RawConn) error {
+	var sockoptErr error
+	err := c.Control(func(fd uintptr) {
+		sockoptErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockoptErr
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePortSupported is false: macOS & the BSDs have SO_REUSEPORT, but they
+// don't load-balance across the sockets, and Windows doesn't have it at all
+const reusePortSupported = false
+
+func reusePort(_, _ string, _ syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT load-balancing is only supported on Linux")
+}
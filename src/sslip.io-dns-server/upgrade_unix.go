@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// upgradeSignals tell us to hand our sockets to a new copy of ourselves and exit
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// upgradeSignals is empty: Windows has no SIGUSR2, and we can't pass it our sockets anyway
+var upgradeSignals []os.Signal
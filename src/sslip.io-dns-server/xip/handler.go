@@ -0,0 +1,551 @@
+package xip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Handler answers a question. The logMessage describes the answer, e.g.
+// "127.0.0.1" or "NXDOMAIN, SOA …"; we prepend the question to it, e.g.
+// "TypeA 127-0-0-1.sslip.io. ? 127.0.0.1".
+type Handler interface {
+	ServeDNS(ctx context.Context, q dnsmessage.Question, srcAddr net.IP) (response Response, logMessage string, err error)
+}
+
+// HandlerFunc lets an ordinary function be a Handler, like http.HandlerFunc
+type HandlerFunc func(ctx context.Context, q dnsmessage.Question, srcAddr net.IP) (response Response, logMessage string, err error)
+
+// ServeDNS calls f(ctx, q, srcAddr)
+func (f HandlerFunc) ServeDNS(ctx context.Context, q dnsmessage.Question, srcAddr net.IP) (Response, string, error) {
+	return f(ctx, q, srcAddr)
+}
+
+// Middleware wraps the next Handler in the pipeline: it answers the questions
+// it knows about and passes the rest to next.
+type Middleware func(next Handler) Handler
+
+// Plugin is a named stage of the answer pipeline. The questions nobody
+// answers get a negative response: NXDOMAIN, or NODATA if a plugin's Exists
+// says that the name has records of another type.
+type Plugin struct {
+	Name       string // e.g. "embedded-ip"
+	Middleware Middleware
+	// Exists is true if the plugin has records of any type for the name, or
+	// for a name beneath it (which makes it an empty non-terminal, RFC 8020).
+	// x is the query's configuration. nil means it has no records of its own.
+	Exists func(x *Xip, fqdnString string) bool
+}
+
+// NewResponse is the response a Handler starts with: authoritative & NOERROR.
+// We set the ID & RD bit to the query's later.
+func NewResponse() Response {
+	return Response{
+		Header: dnsmessage.Header{
+			ID:                 0, // this will later be replaced with query.ID
+			Response:           true,
+			OpCode:             0,
+			Authoritative:      true, // We're able to white label domains by always being authoritative
+			Truncated:          false,
+			RecursionDesired:   false,                   // this will later be replaced with query.RecursionDesired
+			RecursionAvailable: false,                   // We are not recursing servers, so recursion is never available. Prevents DDOS
+			RCode:              dnsmessage.RCodeSuccess, // assume success, may be replaced later
+		},
+	}
+}
+
+// DefaultPlugins are the built-in stages of the pipeline, in order:
+//
+//   - "acme-challenge" delegates "_acme-challenge." names to the name without it
+//   - "authority" answers NS & SOA questions, and refuses ANY
+//   - "blocklist" answers blocked names' A & AAAA questions with our own address
+//   - "customizations" answers from the customizations, e.g. -addresses, -zonefile
+//   - "embedded-ip" answers A & AAAA with the IP embedded in the name, MX with the
+//     name itself, and PTR
+func (x *Xip) DefaultPlugins() []Plugin {
+	return []Plugin{
+		{Name: "acme-challenge", Middleware: x.acmeChallengeMiddleware},
+		{Name: "authority", Middleware: x.authorityMiddleware, Exists: (*Xip).apexExists},
+		{Name: "blocklist", Middleware: x.blocklistMiddleware},
+		{Name: "customizations", Middleware: x.customizationsMiddleware, Exists: (*Xip).customizationExists},
+		{Name: "embedded-ip", Middleware: x.embeddedIPMiddleware, Exists: (*Xip).embeddedIPExists},
+	}
+}
+
+// Plugins returns the stages of the pipeline, in order
+func (x *Xip) Plugins() []Plugin {
+	x.configMutex.RLock()
+	defer x.configMutex.RUnlock()
+	return append([]Plugin(nil), x.pluginsLocked()...)
+}
+
+// SetPlugins replaces the stages of the pipeline, e.g. to add a stage between
+// two of DefaultPlugins(). The queries in progress finish with the old plugins.
+func (x *Xip) SetPlugins(plugins []Plugin) {
+	x.configMutex.Lock()
+	defer x.configMutex.Unlock()
+	x.setPluginsLocked(plugins)
+}
+
+// Use puts plugins in front of the current ones, so they see every question
+// first, e.g. to answer A questions for "db.internal.example.com." from a
+// service registry. They pass the questions they don't answer to the next stage.
+func (x *Xip) Use(plugins ...Plugin) {
+	x.configMutex.Lock()
+	defer x.configMutex.Unlock()
+	x.setPluginsLocked(append(append([]Plugin(nil), plugins...), x.pluginsLocked()...))
+}
+
+func (x *Xip) pluginsLocked() []Plugin {
+	if x.plugins == nil {
+		return x.DefaultPlugins()
+	}
+	return x.plugins
+}
+
+func (x *Xip) setPluginsLocked(plugins []Plugin) {
+	x.plugins = append([]Plugin{}, plugins...) // not nil, or we'd use DefaultPlugins()
+	x.pipeline = x.chain(x.plugins)
+}
+
+// chain wraps the negative response in the plugins, the first outermost
+func (x *Xip) chain(plugins []Plugin) Handler {
+	var handler Handler = HandlerFunc(x.negativeHandler)
+	for i := len(plugins) - 1; i >= 0; i-- {
+		handler = plugins[i].Middleware(handler)
+	}
+	return handler
+}
+
+// handler is the pipeline; a Xip that hasn't called SetPlugins() or Use()
+// (e.g. "&xip.Xip{}") uses DefaultPlugins()
+func (x *Xip) handler() Handler {
+	if x.pipeline == nil {
+		return x.chain(x.DefaultPlugins())
+	}
+	return x.pipeline
+}
+
+// negativeHandler is the end of the pipeline: no plugin had an answer
+func (x *Xip) negativeHandler(ctx context.Context, q dnsmessage.Question, _ net.IP) (Response, string, error) {
+	return x.snapshotFrom(ctx).negativeResponse(q.Name, q.Name, NewResponse(), "")
+}
+
+// acmeChallengeMiddleware delegates everything to the "_acme-challenge."
+// name's stripped address, e.g. dig _acme-challenge.127-0-0-1.sslip.io mx → NS
+// 127-0-0-1.sslip.io, so that its owner can answer the ACME DNS-01 challenge
+func (x *Xip) acmeChallengeMiddleware(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, q dnsmessage.Question, srcAddr net.IP) (Response, string, error) {
+		x := x.snapshotFrom(ctx)
+		if !x.IsAcmeChallenge(q.Name.String()) {
+			return next.ServeDNS(ctx, q, srcAddr)
+		}
+		response := NewResponse()
+		if !x.blocklist(q.Name.String()) {
+			// thanks, @NormanR
+			response.Header.Authoritative = false // we're delegating, so we're not authoritative
+			return x.NSResponse(q.Name, response, "")
+		}
+		if q.Type != dnsmessage.TypeTXT {
+			return next.ServeDNS(ctx, q, srcAddr)
+		}
+		// a blocked "_acme-challenge." TXT: no Answers, Not Authoritative, Authorities contain NS records
+		response.Header.Authoritative = false
+		nameServers := x.NSResources(q.Name.String())
+		var logMessages []string
+		for _, nameServer := range nameServers {
+			response.Authorities = append(response.Authorities,
+				func(b *dnsmessage.Builder) error {
+					return b.NSResource(dnsmessage.ResourceHeader{
+						Name:   q.Name,
+						Type:   dnsmessage.TypeNS,
+						Class:  dnsmessage.ClassINET,
+						TTL:    x.TTLs().NS,
+						Length: 0,
+					}, nameServer)
+				})
+			logMessages = append(logMessages, nameServer.NS.String())
+		}
+		return response, "nil, NS " + strings.Join(logMessages, ", "), nil
+	})
+}
+
+// authorityMiddleware answers the questions about our authority over the name
+func (x *Xip) authorityMiddleware(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, q dnsmessage.Question, srcAddr net.IP) (Response, string, error) {
+		x := x.snapshotFrom(ctx)
+		response := NewResponse()
+		switch q.Type {
+		case dnsmessage.TypeALL:
+			// We don't implement type ANY, so return "NotImplemented" like CloudFlare (1.1.1.1)
+			// https://blog.cloudflare.com/rfc8482-saying-goodbye-to-any/
+			// Google (8.8.8.8) returns every record they can find (A, AAAA, SOA, NS, MX, ...).
+			response.Header.RCode = dnsmessage.RCodeNotImplemented
+			return response, "NotImplemented", nil
+		case dnsmessage.TypeNS:
+			return x.NSResponse(q.Name, response, "")
+		case dnsmessage.TypeSOA:
+			x.stats().AnsweredQueries.Add(1)
+			soaResource := x.SOAResource(q.Name)
+			response.Answers = append(response.Answers,
+				func(b *dnsmessage.Builder) error {
+					return b.SOAResource(dnsmessage.ResourceHeader{
+						Name:   q.Name,
+						Type:   dnsmessage.TypeSOA,
+						Class:  dnsmessage.ClassINET,
+						TTL:    x.TTLs().SOA,
+						Length: 0,
+					}, soaResource)
+				})
+			return response, soaLogMessage(soaResource), nil
+		}
+		return next.ServeDNS(ctx, q, srcAddr)
+	})
+}
+
+// apexExists is true for the apexes of our zones & tenants, whose NS & SOA
+// records are real, unlike those we answer for any name, and for the names
+// between a zone's apex and a tenant's
+func (x *Xip) apexExists(fqdnString string) bool {
+	name := strings.ToLower(fqdnString)
+	for _, zone := range x.zones {
+		if name == zone {
+			return true
+		}
+	}
+	for _, t := range x.tenants {
+		if isWithin(t.apex, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// blocklistMiddleware answers the A & AAAA questions of blocked names (e.g.
+// phishing sites) with the address of their nameserver (ours, or their
+// tenant's) rather than the embedded one. If we don't know its address (e.g.
+// an embedded Xip without -addresses), it answers with no address at all.
+func (x *Xip) blocklistMiddleware(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, q dnsmessage.Question, srcAddr net.IP) (Response, string, error) {
+		x := x.snapshotFrom(ctx)
+		response := NewResponse()
+		switch {
+		case q.Type == dnsmessage.TypeA && len(x.NameToA(q.Name.String())) > 0 && x.blocklist(q.Name.String()):
+			ours, _ := x.nameServerAddressesFor(q.Name.String())
+			if len(ours) == 0 {
+				x.stats().AnsweredBlockedQueries.Add(1)
+				return x.negativeResponse(q.Name, q.Name, response, "")
+			}
+			x.stats().AnsweredQueries.Add(1)
+			x.stats().AnsweredBlockedQueries.Add(1)
+			response.Answers = append(response.Answers,
+				func(b *dnsmessage.Builder) error {
+					return b.AResource(dnsmessage.ResourceHeader{
+						Name:   q.Name,
+						Type:   dnsmessage.TypeA,
+						Class:  dnsmessage.ClassINET,
+						TTL:    x.TTLs().Blocked,
+						Length: 0,
+					}, ours[0])
+				})
+			return response, net.IP(ours[0].A[:]).String(), nil
+		case q.Type == dnsmessage.TypeAAAA && len(x.NameToAAAA(q.Name.String())) > 0 && x.blocklist(q.Name.String()):
+			_, ours := x.nameServerAddressesFor(q.Name.String())
+			if len(ours) == 0 {
+				x.stats().AnsweredBlockedQueries.Add(1)
+				return x.negativeResponse(q.Name, q.Name, response, "")
+			}
+			x.stats().AnsweredQueries.Add(1)
+			x.stats().AnsweredBlockedQueries.Add(1)
+			response.Answers = append(response.Answers,
+				func(b *dnsmessage.Builder) error {
+					return b.AAAAResource(dnsmessage.ResourceHeader{
+						Name:   q.Name,
+						Type:   dnsmessage.TypeAAAA,
+						Class:  dnsmessage.ClassINET,
+						TTL:    x.TTLs().Blocked,
+						Length: 0,
+					}, ours[0])
+				})
+			return response, net.IP(ours[0].AAAA[:]).String(), nil
+		}
+		return next.ServeDNS(ctx, q, srcAddr)
+	})
+}
+
+// customizationsMiddleware answers from the customizations: the built-in
+// records (e.g. "sslip.io"'s MX), -addresses, -zonefile, and the tenants'.
+// Only customizations have CNAME, TXT, SRV, & CAA records.
+func (x *Xip) customizationsMiddleware(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, q dnsmessage.Question, srcAddr net.IP) (Response, string, error) {
+		x := x.snapshotFrom(ctx)
+		response := NewResponse()
+		name := q.Name.String()
+		switch q.Type {
+		case dnsmessage.TypeA, dnsmessage.TypeAAAA, dnsmessage.TypeMX:
+			if x.isCustomized(name, q.Type) {
+				return x.addressOrMXResponse(q, response)
+			}
+		case dnsmessage.TypeCNAME:
+			// If there is a CNAME, there can only be 1
+			if cname := x.CNAMEResource(name); cname != nil {
+				x.stats().AnsweredQueries.Add(1)
+				response.Answers = append(response.Answers,
+					func(b *dnsmessage.Builder) error {
+						return b.CNAMEResource(dnsmessage.ResourceHeader{
+							Name:   q.Name,
+							Type:   dnsmessage.TypeCNAME,
+							Class:  dnsmessage.ClassINET,
+							TTL:    x.customizationTTL(name, x.TTLs().Customization),
+							Length: 0,
+						}, *cname)
+					})
+				return response, cname.CNAME.String(), nil
+			}
+		case dnsmessage.TypeTXT:
+			txts, err := x.TXTResources(name, srcAddr)
+			if err != nil {
+				return response, "", err
+			}
+			if len(txts) > 0 {
+				x.stats().AnsweredQueries.Add(1)
+				response.Answers = append(response.Answers,
+					// Technically there can be more than one TXT record, but practically there can only be one record
+					// but with multiple strings
+					func(b *dnsmessage.Builder) error {
+						for _, txt := range txts {
+							err := b.TXTResource(dnsmessage.ResourceHeader{
+								Name:   q.Name,
+								Type:   dnsmessage.TypeTXT,
+								Class:  dnsmessage.ClassINET,
+								TTL:    x.customizationTTL(name, x.TTLs().TXT),
+								Length: 0,
+							}, txt)
+							if err != nil {
+								return err
+							}
+						}
+						return nil
+					})
+				var logMessageTXTss []string
+				for _, txt := range txts {
+					logMessageTXTss = append(logMessageTXTss, `["`+strings.Join(txt.TXT, `", "`)+`"]`)
+				}
+				return response, strings.Join(logMessageTXTss, ", "), nil
+			}
+		case dnsmessage.TypeSRV:
+			if srvs := x.SRVResources(name); len(srvs) > 0 {
+				x.stats().AnsweredQueries.Add(1)
+				response.Answers = append(response.Answers,
+					func(b *dnsmessage.Builder) error {
+						for _, srv := range srvs {
+							err := b.SRVResource(dnsmessage.ResourceHeader{
+								Name:   q.Name,
+								Type:   dnsmessage.TypeSRV,
+								Class:  dnsmessage.ClassINET,
+								TTL:    x.customizationTTL(name, x.TTLs().Customization),
+								Length: 0,
+							}, srv)
+							if err != nil {
+								return err
+							}
+						}
+						return nil
+					})
+				var logMessages []string
+				for _, srv := range srvs {
+					logMessages = append(logMessages, fmt.Sprintf("%d %d %d %s", srv.Priority, srv.Weight, srv.Port, srv.Target.String()))
+				}
+				return response, strings.Join(logMessages, ", "), nil
+			}
+		case TypeCAA:
+			if caas := x.CAAResources(name); len(caas) > 0 {
+				x.stats().AnsweredQueries.Add(1)
+				response.Answers = append(response.Answers,
+					func(b *dnsmessage.Builder) error {
+						for _, caa := range caas {
+							err := b.UnknownResource(dnsmessage.ResourceHeader{
+								Name:   q.Name,
+								Type:   TypeCAA,
+								Class:  dnsmessage.ClassINET,
+								TTL:    x.customizationTTL(name, x.TTLs().Customization),
+								Length: 0,
+							}, dnsmessage.UnknownResource{Type: TypeCAA, Data: caa.data()})
+							if err != nil {
+								return err
+							}
+						}
+						return nil
+					})
+				var logMessages []string
+				for _, caa := range caas {
+					logMessages = append(logMessages, fmt.Sprintf(`%d %s "%s"`, caa.Flags, caa.Tag, caa.Value))
+				}
+				return response, strings.Join(logMessages, ", "), nil
+			}
+		}
+		return next.ServeDNS(ctx, q, srcAddr)
+	})
+}
+
+// customizationExists is true for the customized names, e.g. "sslip.io", and
+// for their ancestors, e.g. "_domainkey.sslip.io" of
+// "protonmail._domainkey.sslip.io"
+func (x *Xip) customizationExists(fqdnString string) bool {
+	name := strings.ToLower(fqdnString)
+	for customized := range x.customizations {
+		if isWithin(customized, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// embeddedIPMiddleware answers from the name itself: the A or AAAA of the IP
+// embedded in it (e.g. "127-0-0-1.sslip.io"), an MX pointing to the name, and
+// the PTR of a reverse name (e.g. "1.0.0.127.in-addr.arpa.")
+func (x *Xip) embeddedIPMiddleware(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, q dnsmessage.Question, srcAddr net.IP) (Response, string, error) {
+		x := x.snapshotFrom(ctx)
+		response := NewResponse()
+		switch q.Type {
+		case dnsmessage.TypeA:
+			if len(x.NameToA(q.Name.String())) > 0 {
+				return x.addressOrMXResponse(q, response)
+			}
+		case dnsmessage.TypeAAAA:
+			if len(x.NameToAAAA(q.Name.String())) > 0 {
+				return x.addressOrMXResponse(q, response)
+			}
+		case dnsmessage.TypeMX:
+			return x.addressOrMXResponse(q, response)
+		case dnsmessage.TypePTR:
+			ptr := x.PTRResource([]byte(q.Name.String()))
+			if ptr == nil {
+				soaName := dnsmessage.MustNewName("sslip.io.")
+				if t := x.tenantFor(q.Name.String()); t != nil {
+					soaName = t.apexName
+				}
+				return x.negativeResponse(q.Name, soaName, response, "")
+			}
+			//x.stats().AnsweredQueries.Add(1)
+			response.Answers = append(response.Answers,
+				func(b *dnsmessage.Builder) error {
+					return b.PTRResource(dnsmessage.ResourceHeader{
+						Name:   q.Name,
+						Type:   dnsmessage.TypePTR,
+						Class:  dnsmessage.ClassINET,
+						TTL:    x.TTLs().EmbeddedIP,
+						Length: 0,
+					}, *ptr)
+				})
+			return response, ptr.PTR.String(), nil
+		}
+		return next.ServeDNS(ctx, q, srcAddr)
+	})
+}
+
+// embeddedIPExists is true for the names with an embedded IP, e.g.
+// "127-0-0-1.sslip.io", and for the PTR names, but not for their ancestors:
+// like a wildcard's (RFC 4592), they would make every name exist. Nor is it
+// true for the names we answer MX for, which is every name.
+func (x *Xip) embeddedIPExists(fqdnString string) bool {
+	if len(x.NameToA(fqdnString)) > 0 || len(x.NameToAAAA(fqdnString)) > 0 {
+		return true
+	}
+	ptr, _ := ptrResource([]byte(fqdnString), x.ptrDomainFor(fqdnString))
+	return ptr != nil
+}
+
+// addressOrMXResponse answers A, AAAA, & MX questions, whether from the
+// customizations or from the name
+func (x *Xip) addressOrMXResponse(q dnsmessage.Question, response Response) (Response, string, error) {
+	name := q.Name.String()
+	var logMessages []string
+	switch q.Type {
+	case dnsmessage.TypeA:
+		nameToAs := x.NameToA(name)
+		x.stats().AnsweredQueries.Add(1)
+		x.stats().AnsweredAQueries.Add(1)
+		response.Answers = append(response.Answers,
+			// 1 or more A records; A records > 1 only available via Customizations
+			func(b *dnsmessage.Builder) error {
+				for _, nameToA := range nameToAs {
+					err := b.AResource(dnsmessage.ResourceHeader{
+						Name:   q.Name,
+						Type:   dnsmessage.TypeA,
+						Class:  dnsmessage.ClassINET,
+						TTL:    x.addressTTL(name, dnsmessage.TypeA),
+						Length: 0,
+					}, nameToA)
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		for _, nameToA := range nameToAs {
+			logMessages = append(logMessages, net.IP(nameToA.A[:]).String())
+		}
+	case dnsmessage.TypeAAAA:
+		nameToAAAAs := x.NameToAAAA(name)
+		x.stats().AnsweredQueries.Add(1)
+		x.stats().AnsweredAAAAQueries.Add(1)
+		response.Answers = append(response.Answers,
+			// 1 or more AAAA records; AAAA records > 1 only available via Customizations
+			func(b *dnsmessage.Builder) error {
+				for _, nameToAAAA := range nameToAAAAs {
+					err := b.AAAAResource(dnsmessage.ResourceHeader{
+						Name:   q.Name,
+						Type:   dnsmessage.TypeAAAA,
+						Class:  dnsmessage.ClassINET,
+						TTL:    x.addressTTL(name, dnsmessage.TypeAAAA),
+						Length: 0,
+					}, nameToAAAA)
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		for _, nameToAAAA := range nameToAAAAs {
+			logMessages = append(logMessages, net.IP(nameToAAAA.AAAA[:]).String())
+		}
+	case dnsmessage.TypeMX:
+		mailExchangers := x.MXResources(name)
+		// We can be sure that len(mailExchangers) > 1, but we check anyway
+		if len(mailExchangers) == 0 {
+			return response, "", errors.New("no MX records, but there should be one")
+		}
+		mxTTL := x.TTLs().EmbeddedIP // the MX is the name itself
+		if x.isCustomized(name, dnsmessage.TypeMX) {
+			mxTTL = x.customizationTTL(name, x.TTLs().Customization)
+		}
+		x.stats().AnsweredQueries.Add(1)
+		response.Answers = append(response.Answers,
+			func(b *dnsmessage.Builder) error {
+				for _, mailExchanger := range mailExchangers {
+					err := b.MXResource(dnsmessage.ResourceHeader{
+						Name:   q.Name,
+						Type:   dnsmessage.TypeMX,
+						Class:  dnsmessage.ClassINET,
+						TTL:    mxTTL,
+						Length: 0,
+					}, mailExchanger)
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		for _, mailExchanger := range mailExchangers {
+			logMessages = append(logMessages, strconv.Itoa(int(mailExchanger.Pref))+" "+mailExchanger.MX.String())
+		}
+	}
+	return response, strings.Join(logMessages, ", "), nil
+}
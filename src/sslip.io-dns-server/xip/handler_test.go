@@ -0,0 +1,182 @@
+package xip_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"xip/xip"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var _ = Describe("Plugins", func() {
+	var x *xip.Xip
+	type ctxKey struct{}
+	// registry is a plugin that answers A questions of the services it knows
+	// about, e.g. from Consul, and passes everything else to the next stage
+	registry := xip.Plugin{
+		Name: "registry",
+		Middleware: func(next xip.Handler) xip.Handler {
+			return xip.HandlerFunc(func(ctx context.Context, q dnsmessage.Question, srcAddr net.IP) (xip.Response, string, error) {
+				if q.Type != dnsmessage.TypeA || q.Name.String() != "db.internal.sslip.io." {
+					return next.ServeDNS(ctx, q, srcAddr)
+				}
+				ip := [4]byte{10, 9, 8, 7}
+				if ctx.Value(ctxKey{}) != nil {
+					ip = [4]byte{10, 0, 0, 1} // e.g. a different data center
+				}
+				response := xip.NewResponse()
+				response.Answers = append(response.Answers, func(b *dnsmessage.Builder) error {
+					return b.AResource(dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 5}, dnsmessage.AResource{A: ip})
+				})
+				return response, net.IP(ip[:]).String() + " (registry)", nil
+			})
+		},
+	}
+	query := func(ctx context.Context, name string, qType dnsmessage.Type) (response dnsmessage.Message, logMessage string) {
+		queryBytes, err := (&dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: 1035},
+			Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: qType, Class: dnsmessage.ClassINET}},
+		}).Pack()
+		Expect(err).ToNot(HaveOccurred())
+		responseBytes, logMessage, err := x.QueryResponseContext(ctx, queryBytes, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.Unpack(responseBytes)).To(Succeed())
+		Expect(response.Header.ID).To(Equal(uint16(1035)))
+		return response, logMessage
+	}
+	names := func(plugins []xip.Plugin) (names []string) {
+		for _, plugin := range plugins {
+			names = append(names, plugin.Name)
+		}
+		return names
+	}
+	BeforeEach(func() {
+		x, _ = xip.NewXip("file:///", []string{"ns-aws.sslip.io."}, []string{})
+	})
+	AfterEach(func() {
+		x.Close()
+	})
+
+	It("has the built-in plugins in order", func() {
+		Expect(names(x.Plugins())).To(Equal([]string{"acme-challenge", "authority", "blocklist", "customizations", "embedded-ip"}))
+	})
+	When("Use() adds a plugin", func() {
+		BeforeEach(func() {
+			x.Use(registry)
+		})
+		It("puts it in front of the built-in plugins", func() {
+			Expect(names(x.Plugins())).To(Equal([]string{"registry", "acme-challenge", "authority", "blocklist", "customizations", "embedded-ip"}))
+		})
+		It("answers the questions it knows about", func() {
+			response, logMessage := query(context.Background(), "db.internal.sslip.io.", dnsmessage.TypeA)
+			Expect(response.Header.Authoritative).To(BeTrue())
+			Expect(response.Answers).To(HaveLen(1))
+			Expect(response.Answers[0].Body).To(Equal(&dnsmessage.AResource{A: [4]byte{10, 9, 8, 7}}))
+			Expect(logMessage).To(Equal("TypeA db.internal.sslip.io. ? 10.9.8.7 (registry)"))
+		})
+		It("passes the query's context to it", func() {
+			response, _ := query(context.WithValue(context.Background(), ctxKey{}, true), "db.internal.sslip.io.", dnsmessage.TypeA)
+			Expect(response.Answers[0].Body).To(Equal(&dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}}))
+		})
+		It("leaves the other questions to the built-in plugins", func() {
+			response, logMessage := query(context.Background(), "127-0-0-1.sslip.io.", dnsmessage.TypeA)
+			Expect(response.Answers[0].Body).To(Equal(&dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}}))
+			Expect(logMessage).To(Equal("TypeA 127-0-0-1.sslip.io. ? 127.0.0.1"))
+
+			response, logMessage = query(context.Background(), "db.internal.sslip.io.", dnsmessage.TypeAAAA)
+			Expect(response.Answers).To(BeEmpty())
+			Expect(response.Header.RCode).To(Equal(dnsmessage.RCodeNameError))
+			Expect(logMessage).To(MatchRegexp(`^TypeAAAA db\.internal\.sslip\.io\. \? NXDOMAIN, SOA `))
+		})
+	})
+	When("the added plugin says which names it has records for", func() {
+		BeforeEach(func() {
+			registry := registry
+			registry.Exists = func(_ *xip.Xip, fqdnString string) bool {
+				name := strings.ToLower(fqdnString)
+				return name == "db.internal.sslip.io." || name == "internal.sslip.io." // and the name above it
+			}
+			x.Use(registry)
+		})
+		It("answers NODATA rather than NXDOMAIN for them", func() {
+			response, logMessage := query(context.Background(), "db.internal.sslip.io.", dnsmessage.TypeAAAA)
+			Expect(response.Answers).To(BeEmpty())
+			Expect(response.Header.RCode).To(Equal(dnsmessage.RCodeSuccess))
+			Expect(logMessage).To(MatchRegexp(`^TypeAAAA db\.internal\.sslip\.io\. \? nil, SOA `))
+
+			response, _ = query(context.Background(), "internal.sslip.io.", dnsmessage.TypeA) // empty non-terminal
+			Expect(response.Header.RCode).To(Equal(dnsmessage.RCodeSuccess))
+
+			response, _ = query(context.Background(), "web.internal.sslip.io.", dnsmessage.TypeA)
+			Expect(response.Header.RCode).To(Equal(dnsmessage.RCodeNameError))
+		})
+	})
+	When("a name is blocked, but we have no address of our own to answer with", func() {
+		It("answers with no address rather than the blocked one", func() {
+			x.SetBlocklist([]string{"raiffeisen"}, nil)
+			response, logMessage := query(context.Background(), "raiffeisen.94-0-0-1.sslip.io.", dnsmessage.TypeA)
+			Expect(response.Answers).To(BeEmpty())
+			Expect(logMessage).To(MatchRegexp(`^TypeA raiffeisen\.94-0-0-1\.sslip\.io\. \? nil, SOA `))
+			Expect(x.Metrics().AnsweredBlockedQueries).To(Equal(1))
+		})
+	})
+	When("a name is blocked, and we have the address of its nameserver", func() {
+		BeforeEach(func() {
+			x.Reload("file:///", []string{"ns.example.com."}, []string{"ns.example.com=10.0.0.53", "ns.example.com=2001:db8::53"})
+			x.SetTenants([]xip.TenantConfig{{
+				Apex:        "xip.example.com",
+				NameServers: []string{"ns.xip.example.com"},
+				Addresses:   []string{"ns.xip.example.com=10.0.0.54"},
+			}})
+			x.SetBlocklist([]string{"raiffeisen"}, nil)
+		})
+		It("answers with the address of our nameserver rather than the blocked one", func() {
+			response, logMessage := query(context.Background(), "raiffeisen.94-0-0-1.sslip.io.", dnsmessage.TypeA)
+			Expect(response.Answers).To(HaveLen(1))
+			Expect(response.Answers[0].Body).To(Equal(&dnsmessage.AResource{A: [4]byte{10, 0, 0, 53}}))
+			Expect(logMessage).To(Equal("TypeA raiffeisen.94-0-0-1.sslip.io. ? 10.0.0.53"))
+
+			response, _ = query(context.Background(), "raiffeisen.2001-db8--1.sslip.io.", dnsmessage.TypeAAAA)
+			Expect(response.Answers).To(HaveLen(1))
+			Expect(response.Answers[0].Body.(*dnsmessage.AAAAResource).AAAA).To(Equal([16]byte{0x20, 0x01, 0x0d, 0xb8, 14: 0x00, 15: 0x53}))
+			Expect(x.Metrics().AnsweredBlockedQueries).To(Equal(2))
+		})
+		It("answers a tenant's name with the address of the tenant's nameserver", func() {
+			response, logMessage := query(context.Background(), "raiffeisen.94-0-0-1.xip.example.com.", dnsmessage.TypeA)
+			Expect(response.Answers).To(HaveLen(1))
+			Expect(response.Answers[0].Body).To(Equal(&dnsmessage.AResource{A: [4]byte{10, 0, 0, 54}}))
+			Expect(logMessage).To(Equal("TypeA raiffeisen.94-0-0-1.xip.example.com. ? 10.0.0.54"))
+		})
+	})
+	When("SetPlugins() leaves out a built-in plugin", func() {
+		BeforeEach(func() {
+			var plugins []xip.Plugin
+			for _, plugin := range x.Plugins() {
+				if plugin.Name != "embedded-ip" {
+					plugins = append(plugins, plugin)
+				}
+			}
+			x.SetPlugins(plugins)
+		})
+		It("no longer does what that plugin did", func() {
+			response, logMessage := query(context.Background(), "127-0-0-1.sslip.io.", dnsmessage.TypeA)
+			Expect(response.Answers).To(BeEmpty())
+			Expect(logMessage).To(MatchRegexp(`^TypeA 127-0-0-1\.sslip\.io\. \? NXDOMAIN, SOA `)) // nor has its records
+
+			response, _ = query(context.Background(), "protonmail._domainkey.sslip.io.", dnsmessage.TypeCNAME)
+			Expect(response.Answers).To(HaveLen(1)) // the customizations plugin's
+		})
+	})
+	When("SetPlugins() has no plugins", func() {
+		It("answers every question negatively", func() {
+			x.SetPlugins(nil)
+			Expect(x.Plugins()).To(BeEmpty())
+			response, _ := query(context.Background(), "sslip.io.", dnsmessage.TypeNS)
+			Expect(response.Answers).To(BeEmpty())
+			Expect(response.Header.RCode).To(Equal(dnsmessage.RCodeNameError)) // no plugin has records for it
+		})
+	})
+})
@@ -0,0 +1,644 @@
+package xip
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// The defaults of the settings of a Server from NewServer()
+const (
+	DefaultUDPWorkers        = 256
+	DefaultUDPQueueSize      = 4096
+	DefaultUDPBatchSize      = 32
+	DefaultTCPIdleTimeout    = 10 * time.Second
+	DefaultTCPReadTimeout    = 5 * time.Second
+	DefaultTCPMaxConnections = 1000
+	DefaultShutdownTimeout   = 20 * time.Second
+)
+
+// ErrServerClosed is what a Server's methods return once it has begun shutting down
+var ErrServerClosed = errors.New("xip: Server closed")
+
+// udpQueryBufferSize is large enough for any query we'd care to answer: queries
+// with an EDNS(0) OPT record may be larger than the classic 512-byte limit
+const udpQueryBufferSize = 4096
+
+// maxPipelinedQueries is how many queries from one connection we answer
+// concurrently; beyond that we stop reading until an answer has been sent
+const maxPipelinedQueries = 16
+
+// Server answers DNS queries over UDP & TCP with a Xip, e.g. to embed sslip.io
+// in a test suite or a sidecar:
+//
+//	x, _ := xip.NewXip("file:///", []string{"ns.example.com."}, nil)
+//	defer x.Close()
+//	server := xip.NewServer(x, []string{"127.0.0.1:0"})
+//	if err := server.Start(ctx); err != nil { ... }
+//	defer server.Close()
+//	// query server.UDPAddrs()[0] or server.TCPAddrs()[0]
+//
+// Start() binds to the Addresses; ServeUDP() & ServeTCP() serve sockets that
+// are bound elsewhere, e.g. handed to us by systemd. The Server stops when
+// Start()'s context is cancelled or when Close() (or Shutdown()) is called: it
+// stops taking new queries, finishes answering the ones it has already read,
+// and closes its sockets. It doesn't close the Xip, which may outlive it.
+// Change the settings before starting the Server, not after.
+type Server struct {
+	Xip       *Xip     // answers the queries
+	Addresses []string // what Start() binds UDP & TCP to, e.g. "127.0.0.1:53"; port 0 means a free port (the same for UDP & TCP)
+	Quiet     bool     // don't log each query
+
+	UDPWorkers        int           // number of goroutines answering UDP queries
+	UDPQueueSize      int           // number of UDP queries waiting for a worker; when the queue is full, we shed queries
+	UDPBatchSize      int           // maximum number of UDP packets read or written with one system call (recvmmsg/sendmmsg); Linux only
+	UDPRefuse         bool          // answer the UDP queries we shed with REFUSED rather than drop them silently
+	TCPIdleTimeout    time.Duration // how long a TCP connection may sit idle between queries before we close it
+	TCPReadTimeout    time.Duration // how long a TCP client has to finish sending a query it has started, or to read our response
+	TCPMaxConnections int           // maximum number of concurrent TCP connections; we hang up on new connections beyond that
+	ShutdownTimeout   time.Duration // how long Close() waits for the in-flight queries
+
+	mutex        sync.Mutex
+	started      bool // the UDP workers are running
+	closing      bool
+	udpQueue     chan udpQuery
+	udpReaders   sync.WaitGroup // the UDP readers; once they've exited, we close udpQueue
+	inFlight     sync.WaitGroup // the UDP workers, the TCP acceptors, and the TCP connections
+	udpConns     map[*net.UDPConn]struct{}
+	listeners    map[net.Listener]struct{}
+	conns        map[net.Conn]struct{} // the open TCP connections
+	tcpConnSlots chan struct{}
+	done         chan struct{} // closed once we've shut down
+
+	queries       context.Context // the plugins' context; we cancel it once Shutdown() has stopped waiting for the in-flight queries
+	cancelQueries context.CancelFunc
+}
+
+// udpQuery is a UDP packet we've read, waiting in the queue for a worker to answer it
+type udpQuery struct {
+	conn  batchConn
+	query []byte
+	addr  *net.UDPAddr
+}
+
+// batchConn reads & writes several UDP packets with one system call
+// (recvmmsg(2)/sendmmsg(2)) on Linux, and one packet per call elsewhere. Both
+// *ipv4.PacketConn and *ipv6.PacketConn satisfy it: ipv4.Message & ipv6.Message
+// are the same type.
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+func newBatchConn(conn *net.UDPConn) batchConn {
+	if conn.LocalAddr().(*net.UDPAddr).IP.To4() != nil {
+		return ipv4.NewPacketConn(conn)
+	}
+	return ipv6.NewPacketConn(conn) // includes dual-stack "[::]"
+}
+
+// NewServer follows convention for constructors, like NewXip(); the settings
+// are the defaults
+func NewServer(x *Xip, addresses []string) *Server {
+	queries, cancelQueries := context.WithCancel(context.Background())
+	return &Server{
+		Xip:               x,
+		Addresses:         addresses,
+		UDPWorkers:        DefaultUDPWorkers,
+		UDPQueueSize:      DefaultUDPQueueSize,
+		UDPBatchSize:      DefaultUDPBatchSize,
+		TCPIdleTimeout:    DefaultTCPIdleTimeout,
+		TCPReadTimeout:    DefaultTCPReadTimeout,
+		TCPMaxConnections: DefaultTCPMaxConnections,
+		ShutdownTimeout:   DefaultShutdownTimeout,
+		udpConns:          map[*net.UDPConn]struct{}{},
+		listeners:         map[net.Listener]struct{}{},
+		conns:             map[net.Conn]struct{}{},
+		done:              make(chan struct{}),
+		queries:           queries,
+		cancelQueries:     cancelQueries,
+	}
+}
+
+// Start binds UDP & TCP to each of the Addresses and serves them until ctx is
+// cancelled or the Server is closed. It returns once we're ready to answer
+// queries. If it can't bind to an address, it closes the sockets it has bound
+// and returns the error.
+func (s *Server) Start(ctx context.Context) error {
+	var udpConns []*net.UDPConn
+	var tcpListeners []net.Listener
+	closeAll := func() {
+		for _, udpConn := range udpConns {
+			_ = udpConn.Close()
+		}
+		for _, tcpListener := range tcpListeners {
+			_ = tcpListener.Close()
+		}
+	}
+	for _, address := range s.Addresses {
+		udpConn, tcpListener, err := listenUDPAndTCP(address)
+		if err != nil {
+			closeAll()
+			return err
+		}
+		udpConns = append(udpConns, udpConn)
+		tcpListeners = append(tcpListeners, tcpListener)
+	}
+	for i := range udpConns {
+		if err := s.ServeUDP(udpConns[i]); err != nil {
+			closeAll()
+			return err
+		}
+		if err := s.ServeTCP(tcpListeners[i]); err != nil {
+			closeAll()
+			return err
+		}
+	}
+	done := s.Done()
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = s.Close()
+		case <-done:
+		}
+	}()
+	return nil
+}
+
+// listenUDPAndTCP binds UDP & TCP to the same address. If its port is 0, both
+// get the same free port, which may take a few tries: the port that's free for
+// UDP may not be free for TCP.
+func listenUDPAndTCP(address string) (*net.UDPConn, net.Listener, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, nil, err
+	}
+	for tries := 1; ; tries++ {
+		packetConn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			return nil, nil, err
+		}
+		udpConn := packetConn.(*net.UDPConn)
+		tcpListener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(udpConn.LocalAddr().(*net.UDPAddr).Port)))
+		if err == nil {
+			return udpConn, tcpListener, nil
+		}
+		_ = udpConn.Close()
+		if port != "0" || tries == 10 {
+			return nil, nil, err
+		}
+	}
+}
+
+// UDPAddrs returns the addresses of the UDP sockets we're serving, in no
+// particular order, e.g. to find out which port Start() picked
+func (s *Server) UDPAddrs() (addrs []net.Addr) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for udpConn := range s.udpConns {
+		addrs = append(addrs, udpConn.LocalAddr())
+	}
+	return addrs
+}
+
+// TCPAddrs returns the addresses of the TCP listeners we're serving
+func (s *Server) TCPAddrs() (addrs []net.Addr) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for listener := range s.listeners {
+		addrs = append(addrs, listener.Addr())
+	}
+	return addrs
+}
+
+// Done returns a channel that's closed once the Server has shut down
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// ServeUDP answers the queries that arrive on conn until the Server shuts
+// down, when it closes conn. If conn is closed before then (e.g. its address
+// has disappeared), we stop reading from it.
+func (s *Server) ServeUDP(conn *net.UDPConn) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closing {
+		return ErrServerClosed
+	}
+	s.startLocked()
+	s.udpConns[conn] = struct{}{}
+	s.udpReaders.Add(1)
+	go s.readFromUDP(conn)
+	return nil
+}
+
+// ServeTCP answers the DNS-over-TCP connections that arrive on listener until
+// the Server shuts down, when it closes listener
+func (s *Server) ServeTCP(listener net.Listener) error {
+	return s.ServeStream(listener, nil)
+}
+
+// ServeStream is ServeTCP() for connections that need preparing before we read
+// their queries, e.g. to read a PROXY protocol header or to wrap them in TLS
+// for DNS-over-TLS. prepare runs in the connection's own goroutine; if it
+// returns an error, we hang up. The connections of every listener share
+// TCPMaxConnections.
+func (s *Server) ServeStream(listener net.Listener, prepare func(net.Conn) (net.Conn, error)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closing {
+		return ErrServerClosed
+	}
+	s.startLocked()
+	s.listeners[listener] = struct{}{}
+	s.inFlight.Add(1)
+	go s.acceptStreams(listener, prepare)
+	return nil
+}
+
+// Close shuts the Server down, waiting up to ShutdownTimeout for the in-flight
+// queries; see Shutdown()
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+	defer cancel()
+	return s.Shutdown(ctx)
+}
+
+// Shutdown stops taking new queries and waits until we've answered the ones
+// we've already read, or until ctx is done, whichever comes first. If ctx is
+// done first, we give up on the rest: we cancel the plugins' context, hang up
+// on the TCP connections (e.g. a client that doesn't read its answers), and
+// wait for the goroutines serving them to exit. Then it closes our sockets and
+// returns ctx's error, if any: e.g. context.DeadlineExceeded means that we gave
+// up on some queries.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	if s.closing {
+		s.mutex.Unlock()
+		select {
+		case <-s.done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	s.closing = true
+	// we shut down only the read side of the TCP connections so that the
+	// pending answers can still be written; the client sees EOF after its last answer
+	for conn := range s.conns {
+		closeRead(conn)
+	}
+	for udpConn := range s.udpConns {
+		// we don't Close() yet: we still need to write the answers to the queries we've read
+		_ = udpConn.SetReadDeadline(time.Now())
+	}
+	for listener := range s.listeners {
+		_ = listener.Close()
+	}
+	udpQueue := s.udpQueue
+	s.mutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		// the workers exit once they've emptied the queue, and the queue is closed once the readers exit
+		s.udpReaders.Wait()
+		if udpQueue != nil {
+			close(udpQueue)
+		}
+		s.inFlight.Wait()
+		close(drained)
+	}()
+	var err error
+	select {
+	case <-drained:
+		s.cancelQueries()
+	case <-ctx.Done():
+		err = ctx.Err()
+		s.cancelQueries()
+		s.mutex.Lock()
+		for conn := range s.conns {
+			_ = conn.Close()
+		}
+		s.mutex.Unlock()
+		<-drained
+	}
+	s.mutex.Lock()
+	for udpConn := range s.udpConns {
+		_ = udpConn.Close()
+	}
+	s.udpConns = map[*net.UDPConn]struct{}{}
+	s.mutex.Unlock()
+	close(s.done)
+	return err
+}
+
+// startLocked starts the UDP workers the first time we're given something to
+// serve. The caller must hold the mutex.
+func (s *Server) startLocked() {
+	if s.started {
+		return
+	}
+	s.started = true
+	s.udpQueue = make(chan udpQuery, s.UDPQueueSize)
+	s.tcpConnSlots = make(chan struct{}, s.TCPMaxConnections)
+	for i := 0; i < s.UDPWorkers; i++ {
+		s.inFlight.Add(1)
+		go s.answerUDP(s.udpQueue)
+	}
+}
+
+func (s *Server) isClosing() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closing
+}
+
+// readFromUDP reads UDP queries, up to UDPBatchSize at a time, and queues them
+// for the workers. If the queue is full (e.g. we're being flooded), we drop the
+// query, or, if UDPRefuse is set, we answer REFUSED so the client tries another
+// of our nameservers. Either way, memory & goroutines stay bounded.
+func (s *Server) readFromUDP(conn *net.UDPConn) {
+	defer s.udpReaders.Done()
+	packetConn := newBatchConn(conn)
+	// we reuse these buffers; each query is copied out before it's queued
+	messages := make([]ipv4.Message, s.UDPBatchSize)
+	for i := range messages {
+		messages[i].Buffers = [][]byte{make([]byte, udpQueryBufferSize)}
+	}
+	for {
+		n, err := packetConn.ReadBatch(messages, 0)
+		if err != nil {
+			if s.isClosing() {
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				s.mutex.Lock()
+				delete(s.udpConns, conn)
+				s.mutex.Unlock()
+				return
+			}
+			log.Println(err.Error())
+			continue
+		}
+		var refusals []ipv4.Message
+		for _, message := range messages[:n] {
+			query := append([]byte(nil), message.Buffers[0][:message.N]...)
+			addr := message.Addr.(*net.UDPAddr)
+			select {
+			case s.udpQueue <- udpQuery{conn: packetConn, query: query, addr: addr}:
+				continue
+			default:
+			}
+			s.Xip.stats().DroppedUDPQueries.Add(1)
+			if !s.UDPRefuse {
+				continue
+			}
+			response, logMessage, err := RefusedResponse(query)
+			if err != nil {
+				continue // it's garbage, and we're too busy to care
+			}
+			refusals = append(refusals, ipv4.Message{Buffers: [][]byte{response}, Addr: addr})
+			if !s.Quiet {
+				log.Printf("%v.%d %s", addr.IP, addr.Port, logMessage)
+			}
+		}
+		_ = writeBatch(packetConn, refusals)
+	}
+}
+
+// answerUDP is a worker: it answers the queued UDP queries until the queue is
+// closed. It grabs up to UDPBatchSize queries at a time so that it can send the
+// answers with as few system calls as possible.
+func (s *Server) answerUDP(udpQueue <-chan udpQuery) {
+	defer s.inFlight.Done()
+	batchSize := s.UDPBatchSize
+	for q := range udpQueue {
+		batch := []udpQuery{q}
+	grabQueued:
+		for len(batch) < batchSize {
+			select {
+			case q, ok := <-udpQueue:
+				if !ok {
+					break grabQueued
+				}
+				batch = append(batch, q)
+			default:
+				break grabQueued
+			}
+		}
+		// queries may have arrived on different sockets, and we must reply from the socket they arrived on
+		responses := map[batchConn][]ipv4.Message{}
+		for _, q := range batch {
+			if s.queries.Err() != nil {
+				continue // Shutdown() has given up on the queued queries
+			}
+			response, logMessage, err := s.Xip.UDPQueryResponseContext(s.queries, q.query, q.addr.IP)
+			if err != nil {
+				log.Println(err.Error())
+				continue
+			}
+			responses[q.conn] = append(responses[q.conn], ipv4.Message{Buffers: [][]byte{response}, Addr: q.addr})
+			if !s.Quiet {
+				log.Printf("%v.%d %s", q.addr.IP, q.addr.Port, logMessage)
+			}
+			s.Xip.stats().UDPQueries.Add(1)
+		}
+		for packetConn, messages := range responses {
+			if err := writeBatch(packetConn, messages); err != nil {
+				log.Println(err.Error())
+			}
+		}
+	}
+}
+
+// writeBatch writes all the messages; WriteBatch() may write fewer than we ask it to
+func writeBatch(packetConn batchConn, messages []ipv4.Message) error {
+	for len(messages) > 0 {
+		n, err := packetConn.WriteBatch(messages, 0)
+		if err != nil {
+			return err
+		}
+		messages = messages[n:]
+	}
+	return nil
+}
+
+// acceptStreams accepts TCP (or DNS-over-TLS) connections and serves them
+// until the client hangs up or goes idle. It returns once the listener is closed.
+func (s *Server) acceptStreams(listener net.Listener, prepare func(net.Conn) (net.Conn, error)) {
+	defer s.inFlight.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				s.mutex.Lock()
+				delete(s.listeners, listener)
+				s.mutex.Unlock()
+				return
+			}
+			log.Println(err.Error())
+			continue
+		}
+		s.serveStreamInBackground(conn, prepare)
+	}
+}
+
+// serveStreamInBackground serves the connection in a goroutine if we have a free
+// connection slot and we're not shutting down; otherwise it hangs up
+func (s *Server) serveStreamInBackground(conn net.Conn, prepare func(net.Conn) (net.Conn, error)) {
+	if !s.acquireConnSlot(conn) {
+		return
+	}
+	s.mutex.Lock()
+	if s.closing {
+		s.mutex.Unlock()
+		<-s.tcpConnSlots
+		_ = conn.Close()
+		return
+	}
+	s.conns[conn] = struct{}{}
+	s.inFlight.Add(1)
+	s.mutex.Unlock()
+	go func() {
+		defer func() {
+			s.mutex.Lock()
+			delete(s.conns, conn)
+			s.mutex.Unlock()
+			<-s.tcpConnSlots
+			s.inFlight.Done()
+		}()
+		streamConn := conn
+		if prepare != nil {
+			var err error
+			if streamConn, err = prepare(conn); err != nil {
+				_ = conn.Close()
+				return
+			}
+		}
+		s.serveStream(streamConn)
+	}()
+}
+
+// acquireConnSlot returns true if there's room for another TCP connection;
+// otherwise it hangs up on the client, who'll try another of our nameservers
+func (s *Server) acquireConnSlot(conn net.Conn) bool {
+	select {
+	case s.tcpConnSlots <- struct{}{}:
+		return true
+	default:
+		log.Printf("I'm already serving %d TCP connections, so I'm hanging up on %s", cap(s.tcpConnSlots), conn.RemoteAddr().String())
+		_ = conn.Close()
+		return false
+	}
+}
+
+// serveStream answers length-prefixed queries on a TCP (or TLS) connection
+// until the client closes it, goes idle, or sends garbage. Clients may
+// pipeline queries (send several without waiting for the answers), so we
+// answer them concurrently, and possibly out of order (RFC 7766 section 6.2.1.1).
+func (s *Server) serveStream(conn net.Conn) {
+	defer conn.Close()
+	addr, port, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	readTimeout := s.TCPReadTimeout
+	var writeMutex sync.Mutex
+	var inFlight sync.WaitGroup
+	defer inFlight.Wait() // deferred after Close(), so it runs before Close(): don't hang up mid-answer
+	pipelineSlots := make(chan struct{}, maxPipelinedQueries)
+	for {
+		query, err := readTCPMessage(conn, s.TCPIdleTimeout, readTimeout)
+		if err != nil {
+			// the client hanging up or going quiet is business as usual
+			if !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrDeadlineExceeded) {
+				log.Printf("%s.%s %s", addr, port, err.Error())
+			}
+			return
+		}
+		pipelineSlots <- struct{}{}
+		inFlight.Add(1)
+		go func() {
+			defer func() {
+				<-pipelineSlots
+				inFlight.Done()
+			}()
+			response, logMessage, err := s.Xip.QueryResponseContext(s.queries, query, net.ParseIP(addr))
+			if err != nil {
+				log.Println(err.Error())
+				return
+			}
+			writeMutex.Lock()
+			defer writeMutex.Unlock()
+			if err = conn.SetWriteDeadline(time.Now().Add(readTimeout)); err != nil {
+				log.Println(err.Error())
+				return
+			}
+			if err = writeTCPMessage(conn, response); err != nil {
+				log.Println(err.Error())
+				return
+			}
+			if !s.Quiet {
+				log.Printf("%s.%s %s", addr, port, logMessage)
+			}
+			s.Xip.stats().TCPQueries.Add(1)
+		}()
+	}
+}
+
+// readTCPMessage reads one length-prefixed DNS message (RFC 1035 section 4.2.2),
+// e.g. from a TCP or TLS connection, and returns the message without the length.
+// The client has idleTimeout to start sending, and readTimeout to finish.
+func readTCPMessage(conn net.Conn, idleTimeout time.Duration, readTimeout time.Duration) ([]byte, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(idleTimeout)); err != nil {
+		return nil, err
+	}
+	lengthBytes := make([]byte, 2)
+	// the first byte may take a while (idle), but the rest of the message shouldn't
+	if _, err := io.ReadFull(conn, lengthBytes[:1]); err != nil {
+		return nil, err
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, lengthBytes[1:]); err != nil {
+		return nil, err
+	}
+	message := make([]byte, binary.BigEndian.Uint16(lengthBytes))
+	if _, err := io.ReadFull(conn, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// writeTCPMessage writes a DNS message prefixed with its 2-byte length in a single Write()
+func writeTCPMessage(conn io.Writer, message []byte) error {
+	lengthPrefixedMessage := make([]byte, 2, 2+len(message))
+	binary.BigEndian.PutUint16(lengthPrefixedMessage, uint16(len(message)))
+	lengthPrefixedMessage = append(lengthPrefixedMessage, message...)
+	_, err := conn.Write(lengthPrefixedMessage)
+	return err
+}
+
+// closeRead shuts down the read side of a TCP connection, or of the TCP
+// connection underneath a DNS-over-TLS connection; a blocked Read() returns EOF
+func closeRead(conn net.Conn) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.CloseRead()
+	}
+}
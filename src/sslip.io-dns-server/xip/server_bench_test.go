@@ -0,0 +1,93 @@
+package xip_test
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+	"xip/xip"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// BenchmarkServeUDP measures the throughput of the UDP path: reading the
+// queries in batches (recvmmsg), answering them with the workers, and writing
+// the answers in batches (sendmmsg). Batching pays off under load, so the
+// clients query concurrently, e.g. `go test ./xip -run '^$' -bench ServeUDP -cpu 1,4,16`
+func BenchmarkServeUDP(b *testing.B) {
+	for _, batchSize := range []int{1, xip.DefaultUDPBatchSize} {
+		b.Run("batch size "+strconv.Itoa(batchSize), func(b *testing.B) {
+			benchmarkServeUDP(b, batchSize)
+		})
+	}
+}
+
+func benchmarkServeUDP(b *testing.B, batchSize int) {
+	x, _ := xip.NewXip("file:///", []string{"ns.example.com."}, []string{})
+	defer x.Close()
+	server := xip.NewServer(x, []string{"127.0.0.1:0"})
+	server.Quiet = true
+	server.UDPBatchSize = batchSize
+	if err := server.Start(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+	defer server.Close()
+	queryBytes, err := (&dnsmessage.Message{
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName("127-0-0-1.sslip.io."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		b.Fatal(err)
+	}
+	address := server.UDPAddrs()[0].String()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		// each client has its own socket, as resolvers do
+		conn, err := net.Dial("udp", address)
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		defer conn.Close()
+		responseBytes := make([]byte, 512)
+		for pb.Next() {
+			if _, err = conn.Write(queryBytes); err != nil {
+				b.Error(err)
+				return
+			}
+			if err = conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+				b.Error(err)
+				return
+			}
+			if _, err = conn.Read(responseBytes); err != nil {
+				b.Error(err) // e.g. the query was shed because the queue was full
+				return
+			}
+		}
+	})
+}
+
+// BenchmarkQueryResponse measures answering a query without the sockets, for
+// comparison with BenchmarkServeUDP
+func BenchmarkQueryResponse(b *testing.B) {
+	x, _ := xip.NewXip("file:///", []string{"ns.example.com."}, []string{})
+	defer x.Close()
+	queryBytes, err := (&dnsmessage.Message{
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName("127-0-0-1.sslip.io."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, _, err := x.QueryResponse(queryBytes, nil); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
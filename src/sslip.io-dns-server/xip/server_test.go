@@ -0,0 +1,153 @@
+package xip_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+	"xip/xip"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var _ = Describe("Server", func() {
+	var x *xip.Xip
+	var server *xip.Server
+	var ctx context.Context
+	var cancel context.CancelFunc
+	query := func(name string) []byte {
+		queryBytes, err := (&dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: 1035},
+			Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+		}).Pack()
+		Expect(err).ToNot(HaveOccurred())
+		return queryBytes
+	}
+	answer := func(responseBytes []byte) string {
+		var response dnsmessage.Message
+		Expect(response.Unpack(responseBytes)).To(Succeed())
+		Expect(response.Header.ID).To(Equal(uint16(1035)))
+		Expect(response.Answers).To(HaveLen(1))
+		return net.IP(response.Answers[0].Body.(*dnsmessage.AResource).A[:]).String()
+	}
+	queryUDP := func(name string) string {
+		conn, err := net.Dial("udp", server.UDPAddrs()[0].String())
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Expect(conn.SetDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+		_, err = conn.Write(query(name))
+		Expect(err).ToNot(HaveOccurred())
+		responseBytes := make([]byte, 512)
+		n, err := conn.Read(responseBytes)
+		Expect(err).ToNot(HaveOccurred())
+		return answer(responseBytes[:n])
+	}
+	queryTCP := func(name string) string {
+		conn, err := net.Dial("tcp", server.TCPAddrs()[0].String())
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Expect(conn.SetDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+		queryBytes := query(name)
+		_, err = conn.Write(append([]byte{byte(len(queryBytes) >> 8), byte(len(queryBytes))}, queryBytes...))
+		Expect(err).ToNot(HaveOccurred())
+		lengthBytes := make([]byte, 2)
+		_, err = io.ReadFull(conn, lengthBytes)
+		Expect(err).ToNot(HaveOccurred())
+		responseBytes := make([]byte, binary.BigEndian.Uint16(lengthBytes))
+		_, err = io.ReadFull(conn, responseBytes)
+		Expect(err).ToNot(HaveOccurred())
+		return answer(responseBytes)
+	}
+	BeforeEach(func() {
+		x, _ = xip.NewXip("file:///", []string{"ns.example.com."}, []string{"www.example.com=10.0.0.1"})
+		server = xip.NewServer(x, []string{"127.0.0.1:0"})
+		server.Quiet = true
+		ctx, cancel = context.WithCancel(context.Background())
+		Expect(server.Start(ctx)).To(Succeed())
+	})
+	AfterEach(func() {
+		cancel()
+		Expect(server.Close()).To(Succeed())
+		x.Close()
+	})
+	It("answers queries over UDP & TCP on the same free port", func() {
+		Expect(server.UDPAddrs()).To(HaveLen(1))
+		Expect(server.TCPAddrs()).To(HaveLen(1))
+		Expect(server.UDPAddrs()[0].(*net.UDPAddr).Port).To(Equal(server.TCPAddrs()[0].(*net.TCPAddr).Port))
+		Expect(queryUDP("127-0-0-1.sslip.io.")).To(Equal("127.0.0.1"))
+		Expect(queryTCP("www.example.com.")).To(Equal("10.0.0.1"))
+		Eventually(func() int { return x.Metrics().UDPQueries }).Should(Equal(1))
+		Eventually(func() int { return x.Metrics().TCPQueries }).Should(Equal(1))
+	})
+	It("shuts down when its context is cancelled", func() {
+		tcpAddr := server.TCPAddrs()[0].String()
+		cancel()
+		Eventually(server.Done()).Should(BeClosed())
+		_, err := net.Dial("tcp", tcpAddr)
+		Expect(err).To(HaveOccurred())
+		Expect(server.UDPAddrs()).To(BeEmpty())
+	})
+	When("it's closed", func() {
+		BeforeEach(func() {
+			Expect(server.Close()).To(Succeed())
+		})
+		It("won't serve any more sockets", func() {
+			udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			Expect(err).ToNot(HaveOccurred())
+			defer udpConn.Close()
+			Expect(server.ServeUDP(udpConn)).To(MatchError(xip.ErrServerClosed))
+		})
+	})
+	When("a client keeps a TCP connection open during shutdown", func() {
+		It("hangs up once the deadline has passed, and waits for the goroutines serving it", func() {
+			// the answers are big, and the client doesn't read them, so our writes block
+			bigTXT := []dnsmessage.TXTResource{{TXT: []string{}}}
+			for i := 0; i < 200; i++ {
+				bigTXT[0].TXT = append(bigTXT[0].TXT, strings.Repeat("x", 255))
+			}
+			x.SetCustomizations(xip.DomainCustomizations{"big.example.com.": {
+				TXT: func(_ *xip.Xip, _ net.IP) ([]dnsmessage.TXTResource, error) { return bigTXT, nil },
+			}})
+			slow := xip.NewServer(x, []string{"127.0.0.1:0"})
+			slow.Quiet = true
+			slow.TCPReadTimeout = time.Minute // how long a write may block
+			Expect(slow.Start(context.Background())).To(Succeed())
+			conn, err := net.Dial("tcp", slow.TCPAddrs()[0].String())
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			queryBytes, err := (&dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: 1035},
+				Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName("big.example.com."), Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET}},
+			}).Pack()
+			Expect(err).ToNot(HaveOccurred())
+			for i := 0; i < 400; i++ { // ~20 MB of answers, more than the sockets' buffers
+				_, err = conn.Write(append([]byte{byte(len(queryBytes) >> 8), byte(len(queryBytes))}, queryBytes...))
+				Expect(err).ToNot(HaveOccurred())
+			}
+			time.Sleep(500 * time.Millisecond) // for the buffers to fill up
+
+			shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancelShutdown()
+			Expect(slow.Shutdown(shutdownCtx)).To(MatchError(context.DeadlineExceeded))
+			answered := x.Metrics().TCPQueries
+			// had we left the connection open, its blocked writes would finish once the client reads
+			Expect(conn.SetReadDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+			_, err = io.Copy(io.Discard, conn)
+			Expect(errors.Is(err, os.ErrDeadlineExceeded)).To(BeFalse())
+			Expect(x.Metrics().TCPQueries).To(Equal(answered))
+		})
+	})
+	When("it can't bind to an address", func() {
+		It("returns the error", func() {
+			other := xip.NewServer(x, []string{server.UDPAddrs()[0].String()})
+			Expect(other.Start(context.Background())).To(MatchError(ContainSubstring("address already in use")))
+			Expect(other.UDPAddrs()).To(BeEmpty())
+		})
+	})
+})
@@ -5,8 +5,10 @@ package xip
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"log"
 	"net"
@@ -16,6 +18,8 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/dns/dnsmessage"
@@ -25,15 +29,127 @@ import (
 
 // Xip is meant to be a singleton that holds global state for the DNS server
 type Xip struct {
-	DnsAmplificationAttackDelay chan struct{}           // for throttling metrics.status.sslip.io
-	Metrics                     Metrics                 // DNS server metrics
-	BlocklistStrings            []string                // list of blacklisted strings that shouldn't appear in public hostnames
-	BlocklistCIDRs              []net.IPNet             // list of blacklisted CIDRs; no A/AAAA records should resolve to IPs in these CIDRs
-	BlocklistUpdated            time.Time               // The most recent time the Blocklist was updated
-	NameServers                 []dnsmessage.NSResource // The list of authoritative name servers (NS)
+	DnsAmplificationAttackDelay chan struct{} // for throttling metrics.status.sslip.io
+	start                       time.Time     // when we started, for the metrics' uptime
+	counters                    counters      // see Metrics()
+	Version                     Version       // what we answer to "version.status.sslip.io" queries
+
+	// configMutex guards the configuration that Reload(), SetTenants(),
+	// SetZoneFile(), & SetPlugins() replace, config. Each query answers from a snapshot() of it, so that it sees one
+	// configuration from start to finish without holding the lock.
+	configMutex sync.RWMutex
+	config
+	root *Xip // the Xip a snapshot() was taken of, whose counters we count; nil for the Xip itself
+
+	done        chan struct{} // closed by Close() to stop the goroutines NewXip() started
+	closeOnce   sync.Once
+	refreshOnce sync.Once // starts the hourly blocklist download, once we have a blocklist URL
+}
+
+// config is the configuration a query needs besides Xip's exported fields,
+// which don't change once NewXip() returns. Its setters replace its slices & maps rather than modify them, so a copy of
+// it is a snapshot.
+type config struct {
+	nameServers        []dnsmessage.NSResource // the authoritative nameservers (NS); see NameServers()
+	blocklistURL       string
+	blocklistStrings   []string               // strings that shouldn't appear in public hostnames; see Blocklist()
+	blocklistCIDRs     []net.IPNet            // CIDRs that no A/AAAA records should resolve to
+	blocklistUpdated   time.Time              // the most recent time the blocklist was downloaded
+	baseCustomizations DomainCustomizations   // the built-in records (DefaultCustomizations(), or SetCustomizations()'s)
+	customizations     DomainCustomizations   // baseCustomizations with the zone file's, the tenants', and the -addresses records added
+	zoneFileRecords    DomainCustomizations   // SetZoneFile()'s records, which replace baseCustomizations' records of the same names
+	zoneFileContents   string                 // the zone file, from which we also derive configSerial
+	addresses          []string               // the -addresses, which we re-apply when the tenants change
+	tenants            []tenant               // SetTenants()'s white-label zones
+	tenantsContents    string                 // the tenants' configuration, from which we also derive configSerial
+	soa                dnsmessage.SOAResource // SetSOA()'s SOA; an empty NS means our first nameserver, a 0 Serial means configSerial
+	ttls               TTLs                   // SetTTLs()'s TTLs; the zero value means DefaultTTLs
+	zones              []string               // the zones we're authoritative for, lower-cased, e.g. "sslip.io."; empty means every zone
+	answerOutOfZone    bool                   // answer queries outside zones anyway (the legacy behavior) rather than refuse them
+	configContents     string                 // the configuration from which we derive configSerial
+	configSerial       uint32                 // the automatic SOA serial; it changes when the configuration does
+	plugins            []Plugin               // SetPlugins()'s & Use()'s stages of the pipeline; nil means DefaultPlugins()
+	pipeline           Handler                // plugins chained together, ending in a negative response
+}
+
+// Version identifies the build of the server
+type Version struct {
+	Semantic string // e.g. "2.2.1"
+	Date     string // e.g. "2021/10/03-15:08:54+0100"
+	GitHash  string // e.g. "9339c0d"
+}
+
+// TTLs are the TTLs of our records, by category
+type TTLs struct {
+	EmbeddedIP    uint32 // records derived from the name: the A/AAAA of its embedded IP, the MX pointing to it, PTR
+	Customization uint32 // A, AAAA, CNAME, and MX records from Customizations (e.g. -addresses)
+	TXT           uint32 // TXT records, which may change from query to query, e.g. "ip.sslip.io"
+	NS            uint32 // NS records, and the nameservers' addresses in the additional section
+	SOA           uint32 // the SOA record when it's queried
+	Negative      uint32 // the SOA of NXDOMAIN & NODATA answers; resolvers use the lesser of it & the SOA's MINIMUM (RFC 2308)
+	Blocked       uint32 // the address we return in place of a blocked one
+}
+
+// DefaultTTLs are short for what may change (e.g. embedded IPs, in case we need
+// to block them) and long for what doesn't (e.g. our nameservers)
+var DefaultTTLs = TTLs{
+	EmbeddedIP:    3600,   // 60 * 60 == 1 hour; short TTL in case we need to block them
+	Customization: 3600,   // 60 * 60 == 1 hour
+	TXT:           180,    // 3 minutes to allow key-value to propagate
+	NS:            604800, // 60 * 60 * 24 * 7 == 1 week; long TTL, these IP addrs don't change
+	SOA:           604800, // 60 * 60 * 24 * 7 == 1 week; it's not gonna change
+	Negative:      180,    // the same as the SOA's MINIMUM
+	Blocked:       604800, // 60 * 60 * 24 * 7 == 1 week; a blocked name stays blocked
+}
+
+// SOAConfig is our SOA record's configuration (see SetSOA())
+type SOAConfig struct {
+	MName   string // the primary nameserver; "" means the first of NameServers
+	MBox    string // the administrator's email address with "." instead of "@", e.g. "briancunnie.gmail.com."
+	Serial  uint32 // 0 means it's derived from our configuration, and changes when the configuration does
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	MinTTL  uint32 // the SOA's MINIMUM, which is also how long resolvers cache negative answers (RFC 2308)
+}
+
+// DefaultSOAConfig is the SOA of the sslip.io nameservers. We cribbed the
+// Refresh/Retry/Expire from google.com. MinTTL was 300, but I dropped it to 180
+// for faster key-value propagation.
+var DefaultSOAConfig = SOAConfig{
+	MBox:    "briancunnie.gmail.com.",
+	Refresh: 900,
+	Retry:   900,
+	Expire:  1800,
+	MinTTL:  180,
+}
+
+// TenantConfig is a white-label zone, e.g. "xip.example.com", which example.com
+// has delegated to us (see docs/wildcard.md), and which we answer with its own
+// NS, SOA, records, and PTR domain rather than sslip.io's. A query belongs to
+// the tenant whose apex is the longest suffix of its name; names that belong to
+// no tenant are sslip.io's. To answer reverse lookups for a tenant, give it a
+// reverse zone, e.g. "10.in-addr.arpa", whose PTRDomain is the tenant's apex.
+type TenantConfig struct {
+	Apex           string               `json:"apex"`        // e.g. "xip.example.com."
+	NameServers    []string             `json:"nameservers"` // e.g. "ns1.example.com."; none means ours (-nameservers)
+	MBox           string               `json:"mbox"`        // its SOA contact, e.g. "hostmaster.example.com."; "" means ours
+	PTRDomain      string               `json:"ptr_domain"`  // the domain its PTR records point into, e.g. "xip.example.com."; "" means "sslip.io."
+	Addresses      []string             `json:"addresses"`   // records in the format of -addresses, e.g. "www.xip.example.com=10.0.0.1"
+	Customizations DomainCustomizations `json:"-"`           // records -addresses can't express, e.g. MX; the keys must be within Apex
+}
+
+// tenant is a parsed TenantConfig
+type tenant struct {
+	apex           string // absolute & lower-cased, e.g. "xip.example.com."
+	apexName       dnsmessage.Name
+	nameServers    []dnsmessage.NSResource
+	mbox           dnsmessage.Name // an empty Name means ours
+	ptrDomain      string          // absolute & lower-cased, e.g. "xip.example.com."
+	customizations DomainCustomizations
 }
 
-// Metrics contains the counters of the important/interesting queries
+// Metrics is a snapshot of the counters of the important/interesting queries
 type Metrics struct {
 	Start                           time.Time
 	Queries                         int
@@ -48,6 +164,72 @@ type Metrics struct {
 	AnsweredBlockedQueries          int
 	AnsweredPTRQueriesIPv4          int
 	AnsweredPTRQueriesIPv6          int
+	DroppedUDPQueries               int // UDP queries we shed (dropped or REFUSED) because our queue was full
+	FormatErrorQueries              int // malformed queries, e.g. without a question, we answered FORMERR
+	NotImplementedQueries           int // queries with an OpCode other than QUERY, e.g. NOTIFY or UPDATE, we answered NOTIMP
+	RefusedQueries                  int // queries for a class other than IN, e.g. CHAOS, we answered REFUSED
+	OutOfZoneQueries                int // queries for names outside the zones we're authoritative for, whether we refused or answered them
+}
+
+// counters are the Metrics as we count them: the UDP workers, the TCP
+// connections, & DNS-over-HTTPS count concurrently
+type counters struct {
+	Queries                         atomic.Int64
+	TCPQueries                      atomic.Int64
+	UDPQueries                      atomic.Int64
+	AnsweredQueries                 atomic.Int64
+	AnsweredAQueries                atomic.Int64
+	AnsweredAAAAQueries             atomic.Int64
+	AnsweredTXTSrcIPQueries         atomic.Int64
+	AnsweredTXTVersionQueries       atomic.Int64
+	AnsweredNSDNS01ChallengeQueries atomic.Int64
+	AnsweredBlockedQueries          atomic.Int64
+	AnsweredPTRQueriesIPv4          atomic.Int64
+	AnsweredPTRQueriesIPv6          atomic.Int64
+	DroppedUDPQueries               atomic.Int64
+	FormatErrorQueries              atomic.Int64
+	NotImplementedQueries           atomic.Int64
+	RefusedQueries                  atomic.Int64
+	OutOfZoneQueries                atomic.Int64
+}
+
+// Metrics returns a snapshot of the counters
+func (x *Xip) Metrics() Metrics {
+	return Metrics{
+		Start:                           x.start,
+		Queries:                         int(x.stats().Queries.Load()),
+		TCPQueries:                      int(x.stats().TCPQueries.Load()),
+		UDPQueries:                      int(x.stats().UDPQueries.Load()),
+		AnsweredQueries:                 int(x.stats().AnsweredQueries.Load()),
+		AnsweredAQueries:                int(x.stats().AnsweredAQueries.Load()),
+		AnsweredAAAAQueries:             int(x.stats().AnsweredAAAAQueries.Load()),
+		AnsweredTXTSrcIPQueries:         int(x.stats().AnsweredTXTSrcIPQueries.Load()),
+		AnsweredTXTVersionQueries:       int(x.stats().AnsweredTXTVersionQueries.Load()),
+		AnsweredNSDNS01ChallengeQueries: int(x.stats().AnsweredNSDNS01ChallengeQueries.Load()),
+		AnsweredBlockedQueries:          int(x.stats().AnsweredBlockedQueries.Load()),
+		AnsweredPTRQueriesIPv4:          int(x.stats().AnsweredPTRQueriesIPv4.Load()),
+		AnsweredPTRQueriesIPv6:          int(x.stats().AnsweredPTRQueriesIPv6.Load()),
+		DroppedUDPQueries:               int(x.stats().DroppedUDPQueries.Load()),
+		FormatErrorQueries:              int(x.stats().FormatErrorQueries.Load()),
+		NotImplementedQueries:           int(x.stats().NotImplementedQueries.Load()),
+		RefusedQueries:                  int(x.stats().RefusedQueries.Load()),
+		OutOfZoneQueries:                int(x.stats().OutOfZoneQueries.Load()),
+	}
+}
+
+// stats are the counters we count: the Xip's own, or, if x is a snapshot(),
+// those of the Xip it was taken of
+func (x *Xip) stats() *counters {
+	if x.root != nil {
+		return &x.root.counters
+	}
+	return &x.counters
+}
+
+// CountTCPQuery counts a query that came over a stream, e.g. TCP or
+// DNS-over-HTTPS, for transports outside this package
+func (x *Xip) CountTCPQuery() {
+	x.stats().TCPQueries.Add(1)
 }
 
 // DomainCustomization is a value that is returned for a specific query.
@@ -56,16 +238,21 @@ type Metrics struct {
 // but when querying for MX records for generic queries, e.g. "127.0.0.1.sslip.io", return the
 // default (which happens to be no MX records).
 //
-// Noticeably absent are the NS records and SOA records. They don't need to be customized
+// Noticeably absent are the SOA records. They don't need to be customized
 // because they are always the same, regardless of the domain being queried.
+// The NS records usually are, too, but a zone file (see ParseZoneFile()) may set them.
 type DomainCustomization struct {
 	A     []dnsmessage.AResource
 	AAAA  []dnsmessage.AAAAResource
 	CNAME dnsmessage.CNAMEResource
 	MX    []dnsmessage.MXResource
+	NS    []dnsmessage.NSResource // if set, they replace our nameservers for this name
+	SRV   []dnsmessage.SRVResource
+	CAA   []CAAResource
 	TXT   func(*Xip, net.IP) ([]dnsmessage.TXTResource, error)
 	// Unlike the other record types, TXT is a function in order to enable more complex behavior
 	// e.g. IP address of the query's source
+	TTL uint32 // if not 0, the TTL of all the above records, overriding TTLs.Customization & TTLs.TXT
 }
 
 // DomainCustomizations is a lookup table for specially-crafted records
@@ -87,20 +274,38 @@ var (
 	ipv6ReverseRE    = regexp.MustCompile(`^(([[:xdigit:]]\.){32})ip6\.arpa\.`)
 	dns01ChallengeRE = regexp.MustCompile(`(?i)_acme-challenge\.`) // (?i) → non-capturing case insensitive
 
-	mbox, _  = dnsmessage.NewName("briancunnie.gmail.com.")
+	mbox, _  = dnsmessage.NewName(DefaultSOAConfig.MBox)
 	mx1, _   = dnsmessage.NewName("mail.protonmail.ch.")
 	mx2, _   = dnsmessage.NewName("mailsec.protonmail.ch.")
 	dkim1, _ = dnsmessage.NewName("protonmail.domainkey.dw4gykv5i2brtkjglrf34wf6kbxpa5hgtmg2xqopinhgxn5axo73a.domains.proton.ch.")
 	dkim2, _ = dnsmessage.NewName("protonmail2.domainkey.dw4gykv5i2brtkjglrf34wf6kbxpa5hgtmg2xqopinhgxn5axo73a.domains.proton.ch.")
 	dkim3, _ = dnsmessage.NewName("protonmail3.domainkey.dw4gykv5i2brtkjglrf34wf6kbxpa5hgtmg2xqopinhgxn5axo73a.domains.proton.ch.")
 
+	// VersionSemantic, VersionDate, and VersionGitHash are set when we build
+	// (-ldflags="-X xip/xip.VersionSemantic=..."); NewXip() copies them into
+	// Xip.Version
 	VersionSemantic = "0.0.0"
 	VersionDate     = "0001/01/01-99:99:99-0800"
 	VersionGitHash  = "cafexxx"
 
 	MetricsBufferSize = 200 // big enough to run our tests, and small enough to prevent DNS amplification attacks
 
-	Customizations = DomainCustomizations{
+	// MinUDPPayloadSize is the largest UDP response a client without EDNS(0) can receive (RFC 1035)
+	MinUDPPayloadSize = 512
+	// EDNSUDPPayloadSize is the UDP payload size we advertise in our OPT records; 1232 is
+	// DNS Flag Day 2020's recommendation to avoid IP fragmentation
+	EDNSUDPPayloadSize = 1232
+	// RCodeBadVersion is the extended RCODE for an unsupported EDNS version (RFC 6891)
+	RCodeBadVersion dnsmessage.RCode = 16
+	// TypeCAA is the CAA record's type (RFC 8659), which dnsmessage doesn't define
+	TypeCAA dnsmessage.Type = 257
+)
+
+// DefaultCustomizations returns sslip.io's records, e.g. the MX records of
+// "sslip.io." and the TXT record of "ip.sslip.io.". Each call returns a new
+// map, so that a Xip may change its own without affecting the others'.
+func DefaultCustomizations() DomainCustomizations {
+	return DomainCustomizations{
 		"sslip.io.": {
 			MX: []dnsmessage.MXResource{
 				{
@@ -137,11 +342,11 @@ var (
 		},
 		"version.status.sslip.io.": {
 			TXT: func(x *Xip, _ net.IP) ([]dnsmessage.TXTResource, error) {
-				x.Metrics.AnsweredTXTVersionQueries++
+				x.stats().AnsweredTXTVersionQueries.Add(1)
 				return []dnsmessage.TXTResource{
-					{TXT: []string{VersionSemantic}}, // e.g. "2.2.1'
-					{TXT: []string{VersionDate}},     // e.g. "2021/10/03-15:08:54+0100"
-					{TXT: []string{VersionGitHash}},  // e.g. "9339c0d"
+					{TXT: []string{x.Version.Semantic}}, // e.g. "2.2.1'
+					{TXT: []string{x.Version.Date}},     // e.g. "2021/10/03-15:08:54+0100"
+					{TXT: []string{x.Version.GitHash}},  // e.g. "9339c0d"
 				}, nil
 			},
 		},
@@ -149,7 +354,7 @@ var (
 			TXT: TXTMetrics,
 		},
 	}
-)
+}
 
 // Response Why do I have a crazy struct of fields of arrays of functions?
 // It's because I can't use dnsmessage.Builder as I had hoped; specifically
@@ -167,22 +372,645 @@ type Response struct {
 
 // NewXip follows convention for constructors: https://go.dev/doc/effective_go#allocation_new
 func NewXip(blocklistURL string, nameservers []string, addresses []string) (x *Xip, logmessages []string) {
-	x = &Xip{Metrics: Metrics{Start: time.Now()}}
+	x = &Xip{
+		start:   time.Now(),
+		Version: Version{Semantic: VersionSemantic, Date: VersionDate, GitHash: VersionGitHash},
+		done:    make(chan struct{}),
+	}
+
+	// Download the blocklist, unless we don't want one
+	x.blocklistURL = blocklistURL
+	if blocklistURL != "" {
+		logmessages = append(logmessages, x.downloadBlockList(blocklistURL))
+		x.refreshBlocklistHourly()
+	}
+
+	// Parse and set our nameservers
+	var nameServerLogmessages, addressLogmessages []string
+	x.nameServers, nameServerLogmessages, _ = parseNameServers(nameservers)
+	logmessages = append(logmessages, nameServerLogmessages...)
+	// Parse and set our addresses
+	x.baseCustomizations = DefaultCustomizations()
+	x.addresses = addresses
+	x.customizations, addressLogmessages, _ = customizationsWithAddresses(x.baseCustomizations, addresses)
+	logmessages = append(logmessages, addressLogmessages...)
+	x.soa = defaultSOA()
+	x.configContents = configContents(blocklistURL, nameservers, addresses)
+	x.updateConfigSerial()
 
-	// Download the blocklist
-	logmessages = append(logmessages, x.downloadBlockList(blocklistURL))
-	// re-download the blocklist every hour so I don't need to restart servers after updating blocklist
+	// We want to make sure that our DNS server isn't used in a DNS amplification attack.
+	// The endpoint we're worried about is metrics.status.sslip.io, whose reply is
+	// ~400 bytes with a query of ~100 bytes (4x amplification). We accomplish this by
+	// using channels with a quarter-second delay. Max throughput 1.2 kBytes/sec.
+	//
+	// We want to balance this delay against our desire to run tests quickly, so we buffer
+	// the channel with enough room to accommodate our tests.
+	//
+	// We also want to have fun playing with channels
+	dnsAmplificationAttackDelay := make(chan struct{}, MetricsBufferSize)
+	x.DnsAmplificationAttackDelay = dnsAmplificationAttackDelay
 	go func() {
+		// fill up the channel's buffer so that our tests aren't slowed down (~85 tests)
+		for i := 0; i < MetricsBufferSize; i++ {
+			select {
+			case dnsAmplificationAttackDelay <- struct{}{}:
+			case <-x.done:
+				return
+			}
+		}
+		// now put on the brakes for users trying to leverage our server in a DNS amplification attack
 		for {
-			time.Sleep(1 * time.Hour)
-			_ = x.downloadBlockList(blocklistURL) // uh-oh, I lose the log message.
+			select {
+			case dnsAmplificationAttackDelay <- struct{}{}:
+			case <-x.done:
+				return
+			}
+			select {
+			case <-time.After(250 * time.Millisecond):
+			case <-x.done:
+				return
+			}
 		}
 	}()
+	return x, logmessages
+}
 
-	// Parse and set our nameservers
+// refreshBlocklistHourly re-downloads the blocklist every hour so I don't need
+// to restart servers after updating the blocklist. Only the first call starts
+// the goroutine.
+func (x *Xip) refreshBlocklistHourly() {
+	x.refreshOnce.Do(func() {
+		go func() {
+			for {
+				select {
+				case <-time.After(1 * time.Hour):
+				case <-x.done:
+					return
+				}
+				x.configMutex.RLock()
+				blocklistURL := x.blocklistURL // it may have been reloaded
+				x.configMutex.RUnlock()
+				if blocklistURL == "" {
+					continue
+				}
+				_ = x.downloadBlockList(blocklistURL) // uh-oh, I lose the log message.
+			}
+		}()
+	})
+}
+
+// Close stops the goroutines that NewXip() started (the hourly blocklist
+// download and the metrics throttle), e.g. when an application that embeds us
+// no longer needs x. It's safe to call more than once.
+func (x *Xip) Close() {
+	x.closeOnce.Do(func() {
+		if x.done != nil {
+			close(x.done)
+		}
+	})
+}
+
+// Reload replaces the blocklist URL, the nameservers, and the -addresses
+// records, e.g. when we receive SIGHUP. It builds the new configuration first
+// and then swaps it in all at once: queries in progress finish with the old
+// configuration, and later queries see only the new one. If the blocklist
+// can't be downloaded, we keep the old blocklist; an empty blocklistURL means
+// no blocklist.
+func (x *Xip) Reload(blocklistURL string, nameservers []string, addresses []string) (logmessages []string) {
+	nameServers, logmessages, _ := parseNameServers(nameservers)
+	b := x.fetchBlocklistFor(blocklistURL)
+
+	x.configMutex.Lock()
+	defer x.configMutex.Unlock()
+	return append(logmessages, x.reloadLocked(b, nameServers, nameservers, addresses)...)
+}
+
+// Configuration is what Reconfigure() replaces
+type Configuration struct {
+	BlocklistURL    string // "" means no blocklist
+	NameServers     []string
+	Addresses       []string
+	ZoneFile        string // the path of an RFC 1035 zone file (see SetZoneFile()); "" means none
+	Tenants         []TenantConfig
+	Zones           []string // see SetZones()
+	AnswerOutOfZone bool
+}
+
+// Reconfigure replaces what Reload(), SetZoneFile(), SetTenants(), & SetZones()
+// do, e.g. when we receive SIGHUP, but all at once: it reads & parses
+// everything first, and then swaps it in under one lock, so that no query sees
+// half of the new configuration. If it can't read or parse the zone file, it
+// returns the error and keeps the whole previous configuration. If the
+// blocklist can't be downloaded, we keep the old blocklist.
+func (x *Xip) Reconfigure(c Configuration) (logmessages []string, err error) {
+	var zoneFileRecords DomainCustomizations
+	var zoneFileContents string
+	if c.ZoneFile != "" {
+		if zoneFileRecords, zoneFileContents, err = readZoneFile(c.ZoneFile); err != nil {
+			return nil, err
+		}
+		logmessages = append(logmessages, fmt.Sprintf(`Loaded %d names from zone file "%s"`, len(zoneFileRecords), c.ZoneFile))
+	}
+	tenants, tenantsContents, tenantLogmessages := parseTenants(c.Tenants)
+	logmessages = append(logmessages, tenantLogmessages...)
+	zones, zoneLogmessages := parseZones(c.Zones)
+	logmessages = append(logmessages, zoneLogmessages...)
+	nameServers, nameServerLogmessages, _ := parseNameServers(c.NameServers)
+	logmessages = append(logmessages, nameServerLogmessages...)
+	b := x.fetchBlocklistFor(c.BlocklistURL)
+
+	x.configMutex.Lock()
+	defer x.configMutex.Unlock()
+	x.zoneFileRecords = zoneFileRecords
+	x.zoneFileContents = zoneFileContents
+	x.tenants = tenants
+	x.tenantsContents = tenantsContents
+	x.setZonesLocked(zones, c.AnswerOutOfZone)
+	return append(logmessages, x.reloadLocked(b, nameServers, c.NameServers, c.Addresses)...), nil
+}
+
+// blocklist is a downloaded blocklist, or why we couldn't download it
+type blocklist struct {
+	url     string // "" means no blocklist
+	strings []string
+	cidrs   []net.IPNet
+	err     error
+}
+
+// fetchBlocklistFor downloads the blocklist, if any, without the lock, so that
+// queries aren't held up by a slow download
+func (x *Xip) fetchBlocklistFor(blocklistURL string) (b blocklist) {
+	b.url = blocklistURL
+	if blocklistURL != "" {
+		b.strings, b.cidrs, b.err = fetchBlocklist(blocklistURL)
+		x.refreshBlocklistHourly()
+	}
+	return b
+}
+
+// reloadLocked sets the blocklist, the nameservers, and the -addresses
+// records, which it adds to the other records, so the caller sets the zone
+// file & the tenants first. The caller must hold configMutex's write lock.
+func (x *Xip) reloadLocked(b blocklist, nameServers []dnsmessage.NSResource, nameservers []string, addresses []string) (logmessages []string) {
+	customizations, logmessages, _ := customizationsWithAddresses(x.customizationsWithoutAddresses(), addresses)
+	x.nameServers = nameServers
+	x.addresses = addresses
+	x.customizations = customizations
+	x.blocklistURL = b.url
+	x.configContents = configContents(b.url, nameservers, addresses)
+	x.updateConfigSerial()
+	if b.err != nil {
+		return append(logmessages, b.err.Error()+"; keeping the previous blocklist")
+	}
+	x.blocklistStrings = b.strings
+	x.blocklistCIDRs = b.cidrs
+	if b.url == "" {
+		x.blocklistUpdated = time.Time{}
+		return logmessages
+	}
+	x.blocklistUpdated = time.Now()
+	return append(logmessages, fmt.Sprintf("Successfully downloaded blocklist from %s: %v, %v", b.url, b.strings, b.cidrs))
+}
+
+// NameServers returns our nameservers (NS), which Reload() replaces
+func (x *Xip) NameServers() []dnsmessage.NSResource {
+	x.configMutex.RLock()
+	defer x.configMutex.RUnlock()
+	return x.nameServers
+}
+
+// Blocklist returns the strings that mustn't appear in public hostnames, the
+// CIDRs that no A/AAAA records may resolve to, and when we last downloaded
+// them (zero if we haven't)
+func (x *Xip) Blocklist() (blocklistStrings []string, blocklistCIDRs []net.IPNet, updated time.Time) {
+	x.configMutex.RLock()
+	defer x.configMutex.RUnlock()
+	return x.blocklistStrings, x.blocklistCIDRs, x.blocklistUpdated
+}
+
+// SetBlocklist replaces the blocklist, e.g. with one that ReadBlocklist()
+// read, until the next download
+func (x *Xip) SetBlocklist(blocklistStrings []string, blocklistCIDRs []net.IPNet) {
+	x.configMutex.Lock()
+	defer x.configMutex.Unlock()
+	x.blocklistStrings = blocklistStrings
+	x.blocklistCIDRs = blocklistCIDRs
+	x.blocklistUpdated = time.Now()
+}
+
+// SetSOA replaces our SOA record's configuration. We apply the same SOA to
+// every name we answer for except the MNAME, which, unless configured, is our
+// first nameserver.
+func (x *Xip) SetSOA(soaConfig SOAConfig) (logmessage string, err error) {
+	soa := dnsmessage.SOAResource{
+		Serial:  soaConfig.Serial,
+		Refresh: soaConfig.Refresh,
+		Retry:   soaConfig.Retry,
+		Expire:  soaConfig.Expire,
+		MinTTL:  soaConfig.MinTTL,
+	}
+	if soaConfig.MName != "" {
+		if soa.NS, err = absoluteName(soaConfig.MName); err != nil {
+			return "", fmt.Errorf(`invalid SOA MNAME "%s": %w`, soaConfig.MName, err)
+		}
+	}
+	if soa.MBox, err = absoluteName(soaConfig.MBox); err != nil {
+		return "", fmt.Errorf(`invalid SOA MBOX "%s": %w`, soaConfig.MBox, err)
+	}
+	x.configMutex.Lock()
+	defer x.configMutex.Unlock()
+	x.soa = soa
+	x.updateConfigSerial()
+	mname := "(our first nameserver)"
+	if soa.NS.Length > 0 {
+		mname = soa.NS.String()
+	}
+	serial := strconv.Itoa(int(soa.Serial))
+	if soa.Serial == 0 {
+		serial = "(automatic)"
+	}
+	return fmt.Sprintf("SOA: %s %s %s %d %d %d %d", mname, soa.MBox.String(), serial, soa.Refresh, soa.Retry, soa.Expire, soa.MinTTL), nil
+}
+
+// absoluteName is dnsmessage.NewName, but appends the trailing "." if it's
+// missing, and checks the labels, which NewName leaves to packing
+func absoluteName(name string) (dnsmessage.Name, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return dnsmessage.Name{}, errors.New("each label must be 1 to 63 characters long")
+		}
+	}
+	return dnsmessage.NewName(name)
+}
+
+// SetZones replaces the zones we're authoritative for, e.g. "sslip.io." and
+// the apexes of the domains we white-label. We refuse queries for names outside
+// them unless answerOutOfZone, in which case we answer them as if they were
+// inside (the legacy behavior); either way, we count them. No zones means
+// we're authoritative for every name.
+func (x *Xip) SetZones(zones []string, answerOutOfZone bool) (logmessages []string) {
+	parsedZones, logmessages := parseZones(zones)
+	x.configMutex.Lock()
+	defer x.configMutex.Unlock()
+	x.setZonesLocked(parsedZones, answerOutOfZone)
+	return logmessages
+}
+
+// parseZones lower-cases the zones & makes them absolute, skipping (and
+// logging) the invalid ones
+func parseZones(zones []string) (parsedZones []string, logmessages []string) {
+	for _, zone := range zones {
+		if zone == "" {
+			continue
+		}
+		zoneName, err := absoluteName(zone)
+		if err != nil {
+			logmessages = append(logmessages, fmt.Sprintf(`-zones: ignoring invalid zone "%s": %s`, zone, err.Error()))
+			continue
+		}
+		parsedZones = append(parsedZones, strings.ToLower(zoneName.String()))
+		logmessages = append(logmessages, fmt.Sprintf(`Adding zone "%s"`, zoneName.String()))
+	}
+	return parsedZones, logmessages
+}
+
+// setZonesLocked sets the zones. The caller must hold configMutex's write lock.
+func (x *Xip) setZonesLocked(zones []string, answerOutOfZone bool) {
+	x.zones = zones
+	x.answerOutOfZone = answerOutOfZone
+}
+
+// isInZone is true if fqdnString is one of our zones or is beneath one of
+// them; a tenant's apex is one of our zones, too
+func (x *Xip) isInZone(fqdnString string) bool {
+	if len(x.zones) == 0 || x.tenantFor(fqdnString) != nil {
+		return true
+	}
+	fqdnString = strings.ToLower(fqdnString)
+	for _, zone := range x.zones {
+		if isWithin(fqdnString, zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetTenants replaces our white-label zones, skipping (and logging) the
+// invalid ones. The tenants' records take effect at once, alongside the
+// -addresses records.
+func (x *Xip) SetTenants(tenantConfigs []TenantConfig) (logmessages []string) {
+	tenants, contents, logmessages := parseTenants(tenantConfigs)
+	x.configMutex.Lock()
+	defer x.configMutex.Unlock()
+	x.setTenantsLocked(tenants, contents)
+	return logmessages
+}
+
+// parseTenants parses the tenants, skipping (and logging) the invalid ones.
+// The contents are the tenants' configuration, from which we derive
+// configSerial.
+func parseTenants(tenantConfigs []TenantConfig) (tenants []tenant, contents string, logmessages []string) {
+	var tenantContents []string
+	for _, tenantConfig := range tenantConfigs {
+		t, tenantLogmessages, _, err := parseTenant(tenantConfig)
+		logmessages = append(logmessages, tenantLogmessages...)
+		if err != nil {
+			logmessages = append(logmessages, fmt.Sprintf(`tenants: ignoring tenant "%s": %s`, tenantConfig.Apex, err.Error()))
+			continue
+		}
+		tenants = append(tenants, t)
+		tenantContents = append(tenantContents, fmt.Sprintf("%s %v %s %s %v", t.apex, tenantConfig.NameServers, tenantConfig.MBox, t.ptrDomain, tenantConfig.Addresses))
+		logmessages = append(logmessages, fmt.Sprintf(`Adding tenant "%s"`, t.apex))
+	}
+	return tenants, strings.Join(tenantContents, "\n"), logmessages
+}
+
+// setTenantsLocked sets the tenants & their records. The caller must hold
+// configMutex's write lock.
+func (x *Xip) setTenantsLocked(tenants []tenant, contents string) {
+	x.tenants = tenants
+	x.customizations, _, _ = customizationsWithAddresses(x.customizationsWithoutAddresses(), x.addresses) // we logged the -addresses the first time around
+	x.tenantsContents = contents
+	x.updateConfigSerial()
+}
+
+// Validate returns an error if SetTenants() would ignore the tenant, or the
+// errors (see errors.Join()) of the nameservers & records it would ignore
+func (tenantConfig TenantConfig) Validate() error {
+	_, _, errs, err := parseTenant(tenantConfig)
+	if err != nil {
+		return fmt.Errorf(`tenant "%s": %w`, tenantConfig.Apex, err)
+	}
+	return errors.Join(errs...)
+}
+
+// parseTenant validates a TenantConfig's names and parses its records. err
+// means we must ignore the whole tenant; errs are the nameservers & records we
+// ignore, and they're in logmessages, too.
+func parseTenant(tenantConfig TenantConfig) (t tenant, logmessages []string, errs []error, err error) {
+	if t.apexName, err = absoluteName(tenantConfig.Apex); err != nil {
+		return t, nil, nil, err
+	}
+	t.apex = strings.ToLower(t.apexName.String())
+	prefix := fmt.Sprintf(`tenant "%s": `, t.apex)
+	var nameServerLogmessages, addressLogmessages []string
+	var nameServerErrs, addressErrs []error
+	t.nameServers, nameServerLogmessages, nameServerErrs = parseNameServers(tenantConfig.NameServers)
+	for _, logmessage := range nameServerLogmessages {
+		logmessages = append(logmessages, prefix+logmessage)
+	}
+	for _, err := range nameServerErrs {
+		errs = append(errs, fmt.Errorf("%s%w", prefix, err))
+	}
+	if tenantConfig.MBox != "" {
+		if t.mbox, err = absoluteName(tenantConfig.MBox); err != nil {
+			return t, logmessages, errs, fmt.Errorf(`invalid mbox "%s": %w`, tenantConfig.MBox, err)
+		}
+	}
+	t.ptrDomain = "sslip.io."
+	if tenantConfig.PTRDomain != "" {
+		ptrDomain, err := absoluteName(tenantConfig.PTRDomain)
+		if err != nil {
+			return t, logmessages, errs, fmt.Errorf(`invalid ptr_domain "%s": %w`, tenantConfig.PTRDomain, err)
+		}
+		t.ptrDomain = strings.ToLower(ptrDomain.String())
+	}
+	t.customizations, addressLogmessages, addressErrs = customizationsWithAddresses(tenantConfig.Customizations, tenantConfig.Addresses)
+	for _, logmessage := range addressLogmessages {
+		logmessages = append(logmessages, prefix+logmessage)
+	}
+	for _, err := range addressErrs {
+		errs = append(errs, fmt.Errorf("%s%w", prefix, err))
+	}
+	for host := range t.customizations {
+		// we merge the tenants' records into Customizations, so a tenant mustn't
+		// be able to hijack another zone's names
+		if !isWithin(strings.ToLower(host), t.apex) {
+			err := fmt.Errorf(`%signoring record "%s", which isn't within the tenant`, prefix, host)
+			errs = append(errs, err)
+			logmessages = append(logmessages, err.Error())
+			delete(t.customizations, host)
+		}
+	}
+	return t, logmessages, errs, nil
+}
+
+// isWithin is true if the lower-cased, absolute fqdnString is zone or is beneath it
+func isWithin(fqdnString string, zone string) bool {
+	return fqdnString == zone || strings.HasSuffix(fqdnString, "."+zone)
+}
+
+// customizationsWithTenants returns a copy of base with the tenants' records added
+func customizationsWithTenants(base DomainCustomizations, tenants []tenant) (customizations DomainCustomizations) {
+	customizations = DomainCustomizations{}
+	for host, hostEntry := range base {
+		customizations[host] = hostEntry
+	}
+	for _, t := range tenants {
+		for host, hostEntry := range t.customizations {
+			customizations[strings.ToLower(host)] = hostEntry
+		}
+	}
+	return customizations
+}
+
+// SetZoneFile loads the records of an RFC 1035 zone file (see
+// ParseZoneFile()), replacing those of the previous zone file, if any. They
+// replace the hard-coded records of the same names, e.g. "sslip.io.", and
+// the tenants' & -addresses records are added to them. If the zone file can't
+// be read or parsed, we keep the previous one.
+func (x *Xip) SetZoneFile(path string) (logmessage string, err error) {
+	records, contents, err := readZoneFile(path)
+	if err != nil {
+		return "", err
+	}
+	x.configMutex.Lock()
+	defer x.configMutex.Unlock()
+	x.zoneFileRecords = records
+	x.zoneFileContents = contents
+	x.customizations, _, _ = customizationsWithAddresses(x.customizationsWithoutAddresses(), x.addresses) // we logged the -addresses the first time around
+	x.updateConfigSerial()
+	return fmt.Sprintf(`Loaded %d names from zone file "%s"`, len(records), path), nil
+}
+
+// readZoneFile reads & parses a zone file; contents is the file, from which
+// we also derive configSerial
+func readZoneFile(path string) (records DomainCustomizations, contents string, err error) {
+	contentBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if records, err = ParseZoneFile(strings.NewReader(string(contentBytes))); err != nil {
+		return nil, "", fmt.Errorf(`zone file "%s": %w`, path, err)
+	}
+	return records, string(contentBytes), nil
+}
+
+// SetCustomizations replaces the built-in records (DefaultCustomizations()),
+// e.g. to answer for a domain other than sslip.io. The zone file's, the
+// tenants', and the -addresses records are added to them.
+func (x *Xip) SetCustomizations(customizations DomainCustomizations) {
+	x.configMutex.Lock()
+	defer x.configMutex.Unlock()
+	x.baseCustomizations = customizations
+	x.customizations, _, _ = customizationsWithAddresses(x.customizationsWithoutAddresses(), x.addresses) // we logged the -addresses the first time around
+}
+
+// customizationsWithoutAddresses returns the built-in records with the zone
+// file's and the tenants' records added; the caller adds the -addresses
+// records. The caller must hold configMutex's write lock.
+func (x *Xip) customizationsWithoutAddresses() DomainCustomizations {
+	customizations := DomainCustomizations{}
+	for host, hostEntry := range x.baseCustomizations {
+		customizations[host] = hostEntry
+	}
+	for host, hostEntry := range x.zoneFileRecords {
+		customizations[host] = hostEntry
+	}
+	return customizationsWithTenants(customizations, x.tenants)
+}
+
+// tenantFor returns the tenant whose apex is the longest suffix of fqdnString,
+// or nil if the name is sslip.io's
+func (x *Xip) tenantFor(fqdnString string) *tenant {
+	fqdnString = strings.ToLower(fqdnString)
+	var longest *tenant
+	for i, t := range x.tenants {
+		if isWithin(fqdnString, t.apex) && (longest == nil || len(t.apex) > len(longest.apex)) {
+			longest = &x.tenants[i]
+		}
+	}
+	return longest
+}
+
+// nameServersFor returns the nameservers of fqdnString from the zone file, or
+// those of its tenant, or ours
+func (x *Xip) nameServersFor(fqdnString string) []dnsmessage.NSResource {
+	if domain, ok := x.customizations[strings.ToLower(fqdnString)]; ok && len(domain.NS) > 0 {
+		return domain.NS
+	}
+	if t := x.tenantFor(fqdnString); t != nil && len(t.nameServers) > 0 {
+		return t.nameServers
+	}
+	return x.nameServers
+}
+
+// nameServerAddressesFor returns the first addresses we have of
+// fqdnString's nameservers, e.g. from -addresses or its tenant's addresses
+func (x *Xip) nameServerAddressesFor(fqdnString string) (a []dnsmessage.AResource, aaaa []dnsmessage.AAAAResource) {
+	for _, nameServer := range x.nameServersFor(fqdnString) {
+		domain := x.customizations[strings.ToLower(nameServer.NS.String())]
+		if len(a) == 0 {
+			a = domain.A
+		}
+		if len(aaaa) == 0 {
+			aaaa = domain.AAAA
+		}
+	}
+	return a, aaaa
+}
+
+// ptrDomainFor returns the domain into which the PTR record of fqdnString
+// (e.g. "1.0.0.10.in-addr.arpa.") points, e.g. "sslip.io."
+func (x *Xip) ptrDomainFor(fqdnString string) string {
+	if t := x.tenantFor(fqdnString); t != nil {
+		return t.ptrDomain
+	}
+	return "sslip.io."
+}
+
+// SetTTLs replaces the TTLs of our records
+func (x *Xip) SetTTLs(ttls TTLs) {
+	x.configMutex.Lock()
+	defer x.configMutex.Unlock()
+	x.ttls = ttls
+}
+
+// TTLs returns the TTLs of our records
+func (x *Xip) TTLs() TTLs {
+	if x.ttls == (TTLs{}) {
+		return DefaultTTLs // e.g. a Xip that didn't come from NewXip()
+	}
+	return x.ttls
+}
+
+// customizationTTL returns the TTL of the customized records of fqdnString:
+// the customization's own TTL, if it has one, otherwise the default
+func (x *Xip) customizationTTL(fqdnString string, defaultTTL uint32) uint32 {
+	if domain, ok := x.customizations[strings.ToLower(fqdnString)]; ok && domain.TTL != 0 {
+		return domain.TTL
+	}
+	return defaultTTL
+}
+
+// isCustomized is true if fqdnString's records of the given type come from Customizations
+func (x *Xip) isCustomized(fqdnString string, qType dnsmessage.Type) bool {
+	domain, ok := x.customizations[strings.ToLower(fqdnString)]
+	if !ok {
+		return false
+	}
+	switch qType {
+	case dnsmessage.TypeA:
+		return len(domain.A) > 0
+	case dnsmessage.TypeAAAA:
+		return len(domain.AAAA) > 0
+	case dnsmessage.TypeMX:
+		return len(domain.MX) > 0
+	}
+	return false
+}
+
+// addressTTL is the TTL of fqdnString's A or AAAA records, which are either customized or embedded in the name
+func (x *Xip) addressTTL(fqdnString string, qType dnsmessage.Type) uint32 {
+	if x.isCustomized(fqdnString, qType) {
+		return x.customizationTTL(fqdnString, x.TTLs().Customization)
+	}
+	return x.TTLs().EmbeddedIP
+}
+
+// defaultSOA is DefaultSOAConfig as an SOA record
+func defaultSOA() dnsmessage.SOAResource {
+	return dnsmessage.SOAResource{
+		MBox:    mbox,
+		Refresh: DefaultSOAConfig.Refresh,
+		Retry:   DefaultSOAConfig.Retry,
+		Expire:  DefaultSOAConfig.Expire,
+		MinTTL:  DefaultSOAConfig.MinTTL,
+	}
+}
+
+// configContents is the configuration from which we derive the SOA serial
+func configContents(blocklistURL string, nameservers []string, addresses []string) string {
+	return blocklistURL + "\n\n" + strings.Join(nameservers, "\n") + "\n\n" + strings.Join(addresses, "\n")
+}
+
+// updateConfigSerial derives the automatic SOA serial from the configuration.
+// It's a hash rather than a timestamp so that nameservers with the same
+// configuration have the same serial even if they started (or reloaded) at
+// different times. We don't do zone transfers, so it needn't increase.
+// The caller must hold configMutex's write lock.
+func (x *Xip) updateConfigSerial() {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(x.configContents))
+	_, _ = hash.Write([]byte(x.tenantsContents))
+	_, _ = hash.Write([]byte(x.zoneFileContents))
+	_, _ = hash.Write([]byte(soaLogMessage(x.soa)))
+	x.configSerial = hash.Sum32()
+}
+
+// parseNameServers parses -nameservers, skipping the invalid ones; errs are
+// why we skipped them, and they're in logmessages, too
+func parseNameServers(nameservers []string) (nameServers []dnsmessage.NSResource, logmessages []string, errs []error) {
+	skip := func(err error) {
+		errs = append(errs, err)
+		logmessages = append(logmessages, err.Error())
+	}
 	for _, ns := range nameservers {
 		if len(ns) == 0 {
-			logmessages = append(logmessages, fmt.Sprintf(`-nameservers: ignoring zero-length nameserver ""`))
+			skip(errors.New(`-nameservers: ignoring zero-length nameserver ""`))
 			continue
 		}
 		// all nameservers must be absolute (end in ".")
@@ -192,18 +1020,33 @@ func NewXip(blocklistURL string, nameservers []string, addresses []string) (x *X
 		// nameservers must be DNS-compliant
 		nsName, err := dnsmessage.NewName(ns)
 		if err != nil {
-			logmessages = append(logmessages, fmt.Sprintf(`-nameservers: ignoring invalid nameserver "%s"`, ns))
+			skip(fmt.Errorf(`-nameservers: ignoring invalid nameserver "%s"`, ns))
 			continue
 		}
-		x.NameServers = append(x.NameServers, dnsmessage.NSResource{
+		nameServers = append(nameServers, dnsmessage.NSResource{
 			NS: nsName})
 		logmessages = append(logmessages, fmt.Sprintf(`Adding nameserver "%s"`, ns))
 	}
-	// Parse and set our addresses
+	return nameServers, logmessages, errs
+}
+
+// customizationsWithAddresses returns a copy of base with the -addresses
+// records added; base is left untouched so that we can start afresh on
+// Reload(). errs are why we skipped the invalid records, and they're in
+// logmessages, too.
+func customizationsWithAddresses(base DomainCustomizations, addresses []string) (customizations DomainCustomizations, logmessages []string, errs []error) {
+	skip := func(err error) {
+		errs = append(errs, err)
+		logmessages = append(logmessages, err.Error())
+	}
+	customizations = DomainCustomizations{}
+	for host, hostEntry := range base {
+		customizations[host] = hostEntry
+	}
 	for _, address := range addresses {
 		hostAddr := strings.Split(address, "=")
 		if len(hostAddr) != 2 {
-			logmessages = append(logmessages, fmt.Sprintf(`-addresses: arguments should be in the format "host=ip", not "%s"`, address))
+			skip(fmt.Errorf(`-addresses: arguments should be in the format "host=ip", not "%s"`, address))
 			continue
 		}
 		host := hostAddr[0]
@@ -213,63 +1056,32 @@ func NewXip(blocklistURL string, nameservers []string, addresses []string) (x *X
 			host += "."
 		}
 		if ip == nil { // bad IP address
-			logmessages = append(logmessages, fmt.Sprintf(`-addresses: "%s" is not assigned a valid IP "%s"`, hostAddr, ip.String()))
+			skip(fmt.Errorf(`-addresses: "%s" is not assigned a valid IP "%s"`, hostAddr, ip.String()))
 			continue
 		}
+		// Thanks https://stackoverflow.com/questions/42605337/cannot-assign-to-struct-field-in-a-map
+		hostEntry := customizations[host]
 		if ip.To4() != nil { // we have an IPv4
 			var ABytes [4]byte
 			// copy the _last_ four bytes of the 16-byte IP, not the first four bytes. Cost me 2 hours.
 			copy(ABytes[0:4], ip[12:])
-			// Thanks https://stackoverflow.com/questions/42605337/cannot-assign-to-struct-field-in-a-map
-			var hostEntry = DomainCustomization{}
-			if _, ok := Customizations[host]; ok {
-				hostEntry = Customizations[host]
-			}
-			hostEntry.A = append(hostEntry.A, dnsmessage.AResource{A: ABytes})
-			Customizations[host] = hostEntry
+			// the three-index slice makes append() copy rather than scribble on base's records
+			hostEntry.A = append(hostEntry.A[:len(hostEntry.A):len(hostEntry.A)], dnsmessage.AResource{A: ABytes})
 		} else {
 			// We're pretty sure it's IPv6 at this point, but we check anyway
 			if ip.To16() == nil { // it's not IPv6, and I don't know what it is
-				logmessages = append(logmessages, fmt.Sprintf(`-addresses: "%s" is not IPv4 or IPv6 "%s"`, hostAddr, ip.String()))
+				skip(fmt.Errorf(`-addresses: "%s" is not IPv4 or IPv6 "%s"`, hostAddr, ip.String()))
 				continue
 			}
 			var AAAABytes [16]byte
 			copy(AAAABytes[0:16], ip)
-			// Thanks https://stackoverflow.com/questions/42605337/cannot-assign-to-struct-field-in-a-map
-			var hostEntry = DomainCustomization{}
-			if _, ok := Customizations[host]; ok {
-				hostEntry = Customizations[host]
-			}
-			hostEntry.AAAA = append(hostEntry.AAAA, dnsmessage.AAAAResource{AAAA: AAAABytes})
-			Customizations[host] = hostEntry
+			hostEntry.AAAA = append(hostEntry.AAAA[:len(hostEntry.AAAA):len(hostEntry.AAAA)], dnsmessage.AAAAResource{AAAA: AAAABytes})
 		}
+		customizations[host] = hostEntry
 		// print out the added records in a manner similar to the way they're set on the cmdline
 		logmessages = append(logmessages, fmt.Sprintf(`Adding record "%s=%s"`, host, ip))
 	}
-
-	// We want to make sure that our DNS server isn't used in a DNS amplification attack.
-	// The endpoint we're worried about is metrics.status.sslip.io, whose reply is
-	// ~400 bytes with a query of ~100 bytes (4x amplification). We accomplish this by
-	// using channels with a quarter-second delay. Max throughput 1.2 kBytes/sec.
-	//
-	// We want to balance this delay against our desire to run tests quickly, so we buffer
-	// the channel with enough room to accommodate our tests.
-	//
-	// We also want to have fun playing with channels
-	dnsAmplificationAttackDelay := make(chan struct{}, MetricsBufferSize)
-	x.DnsAmplificationAttackDelay = dnsAmplificationAttackDelay
-	go func() {
-		// fill up the channel's buffer so that our tests aren't slowed down (~85 tests)
-		for i := 0; i < MetricsBufferSize; i++ {
-			dnsAmplificationAttackDelay <- struct{}{}
-		}
-		// now put on the brakes for users trying to leverage our server in a DNS amplification attack
-		for {
-			dnsAmplificationAttackDelay <- struct{}{}
-			time.Sleep(250 * time.Millisecond)
-		}
-	}()
-	return x, logmessages
+	return customizations, logmessages, errs
 }
 
 // QueryResponse takes in a raw (packed) DNS query and returns a raw (packed)
@@ -278,6 +1090,10 @@ func NewXip(blocklistURL string, nameservers []string, addresses []string) (x *X
 // possible from main(). main() is hard to unit test, but functions like
 // QueryResponse are not as hard.
 //
+// QueryResponse doesn't limit the size of the response, so it's meant for
+// stream transports (TCP, DNS-over-TLS, DNS-over-HTTPS); use UDPQueryResponse
+// for UDP.
+//
 // Examples of log strings returned:
 //
 //	78.46.204.247.33654: TypeA 127-0-0-1.sslip.io ? 127.0.0.1
@@ -286,56 +1102,195 @@ func NewXip(blocklistURL string, nameservers []string, addresses []string) (x *X
 //	78.46.204.247.33654: TypeSOA www.example.com ? SOA
 //	2600::.33654: TypeAAAA --1.sslip.io ? ::1
 func (x *Xip) QueryResponse(queryBytes []byte, srcAddr net.IP) (responseBytes []byte, logMessage string, err error) {
+	return x.queryResponse(context.Background(), queryBytes, srcAddr, false)
+}
+
+// QueryResponseContext is QueryResponse, but the plugins can use ctx to give
+// up on slow lookups, e.g. when the client has gone away.
+func (x *Xip) QueryResponseContext(ctx context.Context, queryBytes []byte, srcAddr net.IP) (responseBytes []byte, logMessage string, err error) {
+	return x.queryResponse(ctx, queryBytes, srcAddr, false)
+}
+
+// UDPQueryResponse is QueryResponse for UDP: it makes sure the response fits
+// in the client's UDP payload size (512 bytes, or larger if the client
+// advertised a larger size via EDNS(0)). If the response doesn't fit, it
+// first drops the additional section, and if that's not enough, it sets the
+// TC (truncated) bit and drops the answers so the client retries over TCP.
+func (x *Xip) UDPQueryResponse(queryBytes []byte, srcAddr net.IP) (responseBytes []byte, logMessage string, err error) {
+	return x.queryResponse(context.Background(), queryBytes, srcAddr, true)
+}
+
+// UDPQueryResponseContext is UDPQueryResponse with a context, like QueryResponseContext
+func (x *Xip) UDPQueryResponseContext(ctx context.Context, queryBytes []byte, srcAddr net.IP) (responseBytes []byte, logMessage string, err error) {
+	return x.queryResponse(ctx, queryBytes, srcAddr, true)
+}
+
+// RefusedResponse returns a REFUSED response to the query without looking up
+// any records. It's for when we're too busy to answer, so it's cheap: it only
+// parses the header & the question.
+func RefusedResponse(queryBytes []byte) (responseBytes []byte, logMessage string, err error) {
+	var p dnsmessage.Parser
+	queryHeader, err := p.Start(queryBytes)
+	if err != nil {
+		return nil, "", err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, "", err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:               queryHeader.ID,
+		Response:         true,
+		OpCode:           queryHeader.OpCode,
+		RecursionDesired: queryHeader.RecursionDesired,
+		RCode:            dnsmessage.RCodeRefused,
+	})
+	if err = b.StartQuestions(); err != nil {
+		return nil, "", err
+	}
+	if err = b.Question(q); err != nil {
+		return nil, "", err
+	}
+	if responseBytes, err = b.Finish(); err != nil {
+		return nil, "", err
+	}
+	return responseBytes, q.Type.String() + " " + q.Name.String() + " ? Refused", nil
+}
+
+func (x *Xip) queryResponse(ctx context.Context, queryBytes []byte, srcAddr net.IP, udp bool) (responseBytes []byte, logMessage string, err error) {
 	var queryHeader dnsmessage.Header
 	var p dnsmessage.Parser
 	var response Response
 
+	defer func() {
+		if err == nil {
+			x.stats().Queries.Add(1) // each query we respond to, whatever the response
+		}
+	}()
 	if queryHeader, err = p.Start(queryBytes); err != nil {
-		return nil, "", err
+		return nil, "", err // it's too short to have a header, so we can't even echo its ID
 	}
+	// A query has one and only one question (RFC 9619); we echo it in our
+	// response if it does, and answer FORMERR if it doesn't
 	var q dnsmessage.Question
-	// we only answer the first question even though there technically may be more than one;
-	// de facto there's one and only one question
-	if q, err = p.Question(); err != nil {
-		return nil, "", err
+	var questions []dnsmessage.Question
+	var malformed string
+	switch q, err = p.Question(); {
+	case err == dnsmessage.ErrSectionDone:
+		malformed = "no question"
+	case err != nil:
+		malformed = "unparseable question"
+	default:
+		if _, err = p.Question(); err != dnsmessage.ErrSectionDone {
+			malformed = "more than one question"
+		} else {
+			questions = []dnsmessage.Question{q}
+		}
 	}
-	response, logMessage, err = x.processQuestion(q, srcAddr)
-	if err != nil {
-		return nil, "", err
+	if queryHeader.OpCode != 0 {
+		// we only answer QUERY; not NOTIFY, UPDATE, IQUERY, etc.
+		x.stats().NotImplementedQueries.Add(1)
+		logMessage = fmt.Sprintf("OpCode %d ", queryHeader.OpCode)
+		if questions != nil {
+			logMessage += q.Type.String() + " " + q.Name.String() + " "
+		}
+		return rcodeResponse(queryHeader, questions, nil, dnsmessage.RCodeNotImplemented, logMessage+"? NotImplemented")
+	}
+	if malformed != "" {
+		x.stats().FormatErrorQueries.Add(1)
+		return rcodeResponse(queryHeader, nil, nil, dnsmessage.RCodeFormatError, "? FormatError ("+malformed+")")
+	}
+	var edns *EDNS
+	if edns, err = parseEDNS(&p); err != nil {
+		x.stats().FormatErrorQueries.Add(1)
+		return rcodeResponse(queryHeader, questions, nil, dnsmessage.RCodeFormatError,
+			q.Type.String()+" "+q.Name.String()+" ? FormatError (unparseable additional section)")
+	}
+	if q.Class != dnsmessage.ClassINET && q.Class != dnsmessage.ClassANY {
+		// e.g. CHAOS "version.bind"; we only have Internet records
+		x.stats().RefusedQueries.Add(1)
+		return rcodeResponse(queryHeader, questions, edns, dnsmessage.RCodeRefused,
+			q.Class.String()+" "+q.Type.String()+" "+q.Name.String()+" ? Refused")
+	}
+	// the response's builders read the configuration, too, so we answer & build from the same snapshot
+	x = x.snapshot()
+	ctx = context.WithValue(ctx, snapshotKey{}, x)
+	inZone := x.isInZone(q.Name.String())
+	if !inZone {
+		x.stats().OutOfZoneQueries.Add(1)
+		if !x.answerOutOfZone {
+			return rcodeResponse(queryHeader, questions, edns, dnsmessage.RCodeRefused,
+				q.Type.String()+" "+q.Name.String()+" ? Refused (out of zone)")
+		}
+	}
+	if edns != nil && edns.Version > 0 {
+		// RFC 6891 section 6.1.3: we only speak EDNS version 0, so we reply BADVERS with no answers
+		response = Response{Header: dnsmessage.Header{Response: true, Authoritative: true}}
+		logMessage = q.Type.String() + " " + q.Name.String() + " ? BADVERS"
+	} else {
+		response, logMessage, err = x.processQuestion(ctx, q, srcAddr)
+		if err != nil {
+			return nil, "", err
+		}
 	}
 	response.Header.ID = queryHeader.ID
 	response.Header.RecursionDesired = queryHeader.RecursionDesired
-	x.Metrics.Queries++
+	if !inZone {
+		logMessage += " (out of zone)"
+	}
 
-	b := dnsmessage.NewBuilder(nil, response.Header)
-	b.EnableCompression()
-	if err = b.StartQuestions(); err != nil {
+	if responseBytes, err = buildResponse(response, q, edns, true, true); err != nil {
 		return nil, "", err
 	}
-	if err = b.Question(q); err != nil {
-		return
+	if !udp {
+		return responseBytes, logMessage, nil
 	}
-	if err = b.StartAnswers(); err != nil {
+	maxSize := edns.maxUDPPayloadSize()
+	if len(responseBytes) <= maxSize {
+		return responseBytes, logMessage, nil
+	}
+	// The additional section (e.g. the nameservers' addresses) is merely helpful, so we
+	// drop it without setting the TC bit (RFC 2181 section 9)
+	if responseBytes, err = buildResponse(response, q, edns, true, false); err != nil {
 		return nil, "", err
 	}
-	for _, answer := range response.Answers {
-		if err = answer(&b); err != nil {
-			return nil, "", err
-		}
+	if len(responseBytes) <= maxSize {
+		return responseBytes, logMessage, nil
+	}
+	response.Header.Truncated = true
+	if responseBytes, err = buildResponse(response, q, edns, false, false); err != nil {
+		return nil, "", err
 	}
-	if err = b.StartAuthorities(); err != nil {
+	return responseBytes, logMessage + " (truncated)", nil
+}
+
+// rcodeResponse answers a query we can't or won't look up with merely an
+// RCODE (e.g. FORMERR), echoing the query's ID & question, if any
+func rcodeResponse(queryHeader dnsmessage.Header, questions []dnsmessage.Question, edns *EDNS, rcode dnsmessage.RCode, logMessage string) (responseBytes []byte, _ string, err error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:               queryHeader.ID,
+		Response:         true,
+		OpCode:           queryHeader.OpCode,
+		RecursionDesired: queryHeader.RecursionDesired,
+		RCode:            rcode,
+	})
+	if err = b.StartQuestions(); err != nil {
 		return nil, "", err
 	}
-	for _, authority := range response.Authorities {
-		if err = authority(&b); err != nil {
+	for _, q := range questions {
+		if err = b.Question(q); err != nil {
 			return nil, "", err
 		}
 	}
-	if err = b.StartAdditionals(); err != nil {
-		return nil, "", err
-	}
-	for _, additionals := range response.Additionals {
-		if err = additionals(&b); err != nil {
+	if edns != nil {
+		if err = b.StartAdditionals(); err != nil {
+			return nil, "", err
+		}
+		var optHeader dnsmessage.ResourceHeader
+		if err = optHeader.SetEDNS0(EDNSUDPPayloadSize, dnsmessage.RCodeSuccess, edns.DNSSECOK); err != nil {
+			return nil, "", err
+		}
+		if err = b.OPTResource(optHeader, dnsmessage.OPTResource{}); err != nil {
 			return nil, "", err
 		}
 	}
@@ -345,255 +1300,183 @@ func (x *Xip) QueryResponse(queryBytes []byte, srcAddr net.IP) (responseBytes []
 	return responseBytes, logMessage, nil
 }
 
-func (x *Xip) processQuestion(q dnsmessage.Question, srcAddr net.IP) (response Response, logMessage string, err error) {
-	logMessage = q.Type.String() + " " + q.Name.String() + " ? "
-	response = Response{
-		Header: dnsmessage.Header{
-			ID:                 0, // this will later be replaced with query.ID
-			Response:           true,
-			OpCode:             0,
-			Authoritative:      true, // We're able to white label domains by always being authoritative
-			Truncated:          false,
-			RecursionDesired:   false,                   // this will later be replaced with query.RecursionDesired
-			RecursionAvailable: false,                   // We are not recursing servers, so recursion is never available. Prevents DDOS
-			RCode:              dnsmessage.RCodeSuccess, // assume success, may be replaced later
-		},
+// buildResponse packs the response. The OPT record, if any, is always in the
+// additional section, even when we omit the rest of the additional section.
+func buildResponse(response Response, q dnsmessage.Question, edns *EDNS, withAnswers bool, withAdditionals bool) (responseBytes []byte, err error) {
+	header := response.Header
+	var extRCode dnsmessage.RCode
+	if edns != nil && edns.Version > 0 {
+		extRCode = RCodeBadVersion
+		header.RCode = RCodeBadVersion & 0xf // the lower 4 bits go in the header; the upper 8 in the OPT record
 	}
-	if IsAcmeChallenge(q.Name.String()) && !x.blocklist(q.Name.String()) {
-		// thanks, @NormanR
-		// delegate everything to its stripped (remove "_acme-challenge.") address, e.g.
-		// dig _acme-challenge.127-0-0-1.sslip.io mx → NS 127-0-0-1.sslip.io
-		response.Header.Authoritative = false // we're delegating, so we're not authoritative
-		return x.NSResponse(q.Name, response, logMessage)
+	b := dnsmessage.NewBuilder(nil, header)
+	b.EnableCompression()
+	if err = b.StartQuestions(); err != nil {
+		return nil, err
 	}
-	switch q.Type {
-	case dnsmessage.TypeA:
-		{
-			return x.nameToAwithBlocklist(q, response, logMessage)
+	if err = b.Question(q); err != nil {
+		return nil, err
+	}
+	if withAnswers {
+		if err = b.StartAnswers(); err != nil {
+			return nil, err
 		}
-	case dnsmessage.TypeAAAA:
-		{
-			return x.nameToAAAAwithBlocklist(q, response, logMessage)
+		for _, answer := range response.Answers {
+			if err = answer(&b); err != nil {
+				return nil, err
+			}
 		}
-	case dnsmessage.TypeALL:
-		{
-			// We don't implement type ANY, so return "NotImplemented" like CloudFlare (1.1.1.1)
-			// https://blog.cloudflare.com/rfc8482-saying-goodbye-to-any/
-			// Google (8.8.8.8) returns every record they can find (A, AAAA, SOA, NS, MX, ...).
-			response.Header.RCode = dnsmessage.RCodeNotImplemented
-			return response, logMessage + "NotImplemented", nil
+		if err = b.StartAuthorities(); err != nil {
+			return nil, err
 		}
-	case dnsmessage.TypeCNAME:
-		{
-			// If there is a CNAME, there can only be 1, and only from Customizations
-			var cname *dnsmessage.CNAMEResource
-			cname = CNAMEResource(q.Name.String())
-			if cname == nil {
-				// No Answers, only 1 Authorities
-				soaHeader, soaResource := SOAAuthority(q.Name)
-				response.Authorities = append(response.Authorities,
-					func(b *dnsmessage.Builder) error {
-						if err = b.SOAResource(soaHeader, soaResource); err != nil {
-							return err
-						}
-						return nil
-					})
-				return response, logMessage + "nil, SOA " + soaLogMessage(soaResource), nil
+		for _, authority := range response.Authorities {
+			if err = authority(&b); err != nil {
+				return nil, err
 			}
-			x.Metrics.AnsweredQueries++
-			response.Answers = append(response.Answers,
-				// 1 CNAME record, via Customizations
-				func(b *dnsmessage.Builder) error {
-					err = b.CNAMEResource(dnsmessage.ResourceHeader{
-						Name:   q.Name,
-						Type:   dnsmessage.TypeCNAME,
-						Class:  dnsmessage.ClassINET,
-						TTL:    604800, // 60 * 60 * 24 * 7 == 1 week; long TTL, these IP addrs don't change
-						Length: 0,
-					}, *cname)
-					if err != nil {
-						return err
-					}
-					return nil
-				})
-			return response, logMessage + cname.CNAME.String(), nil
 		}
-	case dnsmessage.TypeMX:
-		{
-			mailExchangers := MXResources(q.Name.String())
-			var logMessages []string
-
-			// We can be sure that len(mailExchangers) > 1, but we check anyway
-			if len(mailExchangers) == 0 {
-				return response, "", errors.New("no MX records, but there should be one")
-			}
-			x.Metrics.AnsweredQueries++
-			response.Answers = append(response.Answers,
-				// 1 or more A records; A records > 1 only available via Customizations
-				func(b *dnsmessage.Builder) error {
-					for _, mailExchanger := range mailExchangers {
-						err = b.MXResource(dnsmessage.ResourceHeader{
-							Name:   q.Name,
-							Type:   dnsmessage.TypeMX,
-							Class:  dnsmessage.ClassINET,
-							TTL:    604800, // 60 * 60 * 24 * 7 == 1 week; long TTL, these IP addrs don't change
-							Length: 0,
-						}, mailExchanger)
-					}
-					if err != nil {
-						return err
-					}
-					return nil
-				})
-			for _, mailExchanger := range mailExchangers {
-				logMessages = append(logMessages, strconv.Itoa(int(mailExchanger.Pref))+" "+mailExchanger.MX.String())
+	}
+	if err = b.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	if withAdditionals {
+		for _, additionals := range response.Additionals {
+			if err = additionals(&b); err != nil {
+				return nil, err
 			}
-			return response, logMessage + strings.Join(logMessages, ", "), nil
 		}
-	case dnsmessage.TypeNS:
-		{
-			return x.NSResponse(q.Name, response, logMessage)
+	}
+	if edns != nil {
+		var optHeader dnsmessage.ResourceHeader
+		if err = optHeader.SetEDNS0(EDNSUDPPayloadSize, extRCode, edns.DNSSECOK); err != nil {
+			return nil, err
 		}
-	case dnsmessage.TypeSOA:
-		{
-			x.Metrics.AnsweredQueries++
-			soaResource := SOAResource(q.Name)
-			response.Answers = append(response.Answers,
-				func(b *dnsmessage.Builder) error {
-					err = b.SOAResource(dnsmessage.ResourceHeader{
-						Name:   q.Name,
-						Type:   dnsmessage.TypeSOA,
-						Class:  dnsmessage.ClassINET,
-						TTL:    604800, // 60 * 60 * 24 * 7 == 1 week; long TTL, these IP addrs don't change
-						Length: 0,
-					}, soaResource)
-					if err != nil {
-						return err
-					}
-					return nil
-				})
-			return response, logMessage + soaLogMessage(soaResource), nil
+		if err = b.OPTResource(optHeader, dnsmessage.OPTResource{}); err != nil {
+			return nil, err
 		}
-	case dnsmessage.TypeTXT:
-		{
-			// if it's an "_acme-challenge." TXT, we return no answer but an NS authority & not authoritative
-			// if it's customized records, we return them in the Answers
-			// otherwise we return no Answers and Authorities SOA
-			if IsAcmeChallenge(q.Name.String()) {
-				// No Answers, Not Authoritative, Authorities contain NS records
-				response.Header.Authoritative = false
-				nameServers := x.NSResources(q.Name.String())
-				var logMessages []string
-				for _, nameServer := range nameServers {
-					response.Authorities = append(response.Authorities,
-						// 1 or more A records; A records > 1 only available via Customizations
-						func(b *dnsmessage.Builder) error {
-							err = b.NSResource(dnsmessage.ResourceHeader{
-								Name:   q.Name,
-								Type:   dnsmessage.TypeNS,
-								Class:  dnsmessage.ClassINET,
-								TTL:    604800, // 60 * 60 * 24 * 7 == 1 week; long TTL, these IP addrs don't change
-								Length: 0,
-							}, nameServer)
-							if err != nil {
-								return err
-							}
-							return nil
-						})
-					logMessages = append(logMessages, nameServer.NS.String())
-				}
-				return response, logMessage + "nil, NS " + strings.Join(logMessages, ", "), nil
-			}
-			var txts []dnsmessage.TXTResource
-			txts, err = x.TXTResources(q.Name.String(), srcAddr)
-			if err != nil {
-				return response, "", err
-			}
-			if len(txts) > 0 {
-				x.Metrics.AnsweredQueries++
-			}
-			response.Answers = append(response.Answers,
-				// 1 or more TXT records via Customizations
-				// Technically there can be more than one TXT record, but practically there can only be one record
-				// but with multiple strings
-				func(b *dnsmessage.Builder) error {
-					for _, txt := range txts {
-						err = b.TXTResource(dnsmessage.ResourceHeader{
-							Name:   q.Name,
-							Type:   dnsmessage.TypeTXT,
-							Class:  dnsmessage.ClassINET,
-							TTL:    180, // 3 minutes to allow key-value to propagate
-							Length: 0,
-						}, txt)
-						if err != nil {
-							return err
-						}
-					}
-					return nil
-				})
-			var logMessageTXTss []string
-			for _, txt := range txts {
-				var logMessageTXTs []string
-				for _, TXTstring := range txt.TXT {
-					logMessageTXTs = append(logMessageTXTs, TXTstring)
-				}
-				logMessageTXTss = append(logMessageTXTss, `["`+strings.Join(logMessageTXTs, `", "`)+`"]`)
-			}
-			if len(logMessageTXTss) == 0 {
-				return response, logMessage + "nil, SOA " + soaLogMessage(SOAResource(q.Name)), nil
-			}
-			return response, logMessage + strings.Join(logMessageTXTss, ", "), nil
+	}
+	return b.Finish()
+}
+
+// EDNS holds the interesting bits of the query's OPT pseudo-record (RFC 6891)
+type EDNS struct {
+	UDPPayloadSize int   // the largest UDP response the client can reassemble
+	Version        uint8 // we only support version 0
+	DNSSECOK       bool  // the "DO" bit; we don't do DNSSEC, but we must copy it to the response (RFC 3225)
+}
+
+// parseEDNS returns the query's EDNS information, or nil if the query has
+// no OPT record. The parser must be positioned after the first question.
+func parseEDNS(p *dnsmessage.Parser) (*EDNS, error) {
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, err
+	}
+	if err := p.SkipAllAnswers(); err != nil {
+		return nil, err
+	}
+	if err := p.SkipAllAuthorities(); err != nil {
+		return nil, err
+	}
+	for {
+		header, err := p.AdditionalHeader()
+		if err == dnsmessage.ErrSectionDone {
+			return nil, nil
 		}
-	case dnsmessage.TypePTR:
-		{
-			var ptr *dnsmessage.PTRResource
-			ptr = x.PTRResource([]byte(q.Name.String()))
-			if ptr == nil {
-				// No Answers, only 1 Authorities
-				soaHeader, soaResource := SOAAuthority(dnsmessage.MustNewName("sslip.io."))
-				response.Authorities = append(response.Authorities,
-					func(b *dnsmessage.Builder) error {
-						if err = b.SOAResource(soaHeader, soaResource); err != nil {
-							return err
-						}
-						return nil
-					})
-				return response, logMessage + "nil, SOA " + soaLogMessage(soaResource), nil
+		if err != nil {
+			return nil, err
+		}
+		if header.Type != dnsmessage.TypeOPT {
+			if err = p.SkipAdditional(); err != nil {
+				return nil, err
 			}
-			//x.Metrics.AnsweredQueries++
-			response.Answers = append(response.Answers,
-				// 1 CNAME record, via Customizations
-				func(b *dnsmessage.Builder) error {
-					err = b.PTRResource(dnsmessage.ResourceHeader{
-						Name:   q.Name,
-						Type:   dnsmessage.TypePTR,
-						Class:  dnsmessage.ClassINET,
-						TTL:    604800, // 60 * 60 * 24 * 7 == 1 week; long TTL, these IP addrs don't change
-						Length: 0,
-					}, *ptr)
-					if err != nil {
-						return err
-					}
-					return nil
-				})
-			return response, logMessage + ptr.PTR.String(), nil
+			continue
 		}
-	default:
-		{
-			// default is the same case as an A/AAAA record which is not found,
-			// i.e. we return no answers, but we return an authority section
-			// No Answers, only 1 Authorities
-			soaHeader, soaResource := SOAAuthority(q.Name)
-			response.Authorities = append(response.Authorities,
-				func(b *dnsmessage.Builder) error {
-					if err = b.SOAResource(soaHeader, soaResource); err != nil {
-						return err
-					}
-					return nil
-				})
-			return response, logMessage + "nil, SOA " + soaLogMessage(soaResource), nil
+		return &EDNS{
+			UDPPayloadSize: int(header.Class), // the OPT record repurposes CLASS as the UDP payload size
+			Version:        uint8(header.TTL >> 16),
+			DNSSECOK:       header.DNSSECAllowed(),
+		}, nil
+	}
+}
+
+// maxUDPPayloadSize returns the largest UDP response we're willing to send to
+// the client: never less than 512 bytes, never more than we advertise
+func (edns *EDNS) maxUDPPayloadSize() int {
+	if edns == nil || edns.UDPPayloadSize <= MinUDPPayloadSize {
+		return MinUDPPayloadSize
+	}
+	if edns.UDPPayloadSize > EDNSUDPPayloadSize {
+		return EDNSUDPPayloadSize
+	}
+	return edns.UDPPayloadSize
+}
+
+// snapshot copies the configuration while holding the read lock only briefly,
+// so that a query sees one configuration from start to finish, and a setter
+// (e.g. Reload()) needn't wait for the queries in progress, e.g. those waiting
+// in TXTMetrics() for the throttle.
+func (x *Xip) snapshot() *Xip {
+	x.configMutex.RLock()
+	defer x.configMutex.RUnlock()
+	root := x
+	if x.root != nil {
+		root = x.root
+	}
+	return &Xip{
+		DnsAmplificationAttackDelay: x.DnsAmplificationAttackDelay,
+		start:                       x.start,
+		Version:                     x.Version,
+		config:                      x.config,
+		root:                        root,
+		done:                        x.done,
+	}
+}
+
+// snapshotKey is the context key of the query's snapshot()
+type snapshotKey struct{}
+
+// snapshotFrom returns the snapshot() of x that the query is answered from,
+// so that the built-in plugins, which are bound to x, answer from it, too. If
+// there's none (e.g. a test calls the Handler directly), it returns x.
+func (x *Xip) snapshotFrom(ctx context.Context) *Xip {
+	if snapshot, ok := ctx.Value(snapshotKey{}).(*Xip); ok && snapshot.root == x {
+		return snapshot
+	}
+	return x
+}
+
+// processQuestion passes the question down the pipeline of plugins
+func (x *Xip) processQuestion(ctx context.Context, q dnsmessage.Question, srcAddr net.IP) (response Response, logMessage string, err error) {
+	response, logMessage, err = x.handler().ServeDNS(ctx, q, srcAddr)
+	return response, q.Type.String() + " " + q.Name.String() + " ? " + logMessage, err
+}
+
+// negativeResponse answers a query for which we have no records: NXDOMAIN if
+// the name doesn't exist (see nameExists()), NODATA (NOERROR, but no answers)
+// if it merely has none of the queried type, e.g. AAAA for "1-2-3-4.sslip.io".
+// Either way, the SOA in the authority section tells the resolver how long to
+// cache the negative answer (RFC 2308).
+func (x *Xip) negativeResponse(name dnsmessage.Name, soaName dnsmessage.Name, response Response, logMessage string) (Response, string, error) {
+	soaHeader, soaResource := x.SOAAuthority(soaName)
+	response.Authorities = append(response.Authorities,
+		func(b *dnsmessage.Builder) error {
+			return b.SOAResource(soaHeader, soaResource)
+		})
+	if !x.nameExists(name.String()) {
+		response.Header.RCode = dnsmessage.RCodeNameError
+		return response, logMessage + "NXDOMAIN, SOA " + soaLogMessage(soaResource), nil
+	}
+	return response, logMessage + "nil, SOA " + soaLogMessage(soaResource), nil
+}
+
+// nameExists is true if one of the plugins has records of any type for the
+// name, or for a name beneath it (see Plugin.Exists)
+func (x *Xip) nameExists(fqdnString string) bool {
+	for _, plugin := range x.pluginsLocked() {
+		if plugin.Exists != nil && plugin.Exists(x, fqdnString) {
+			return true
 		}
 	}
+	return false
 }
 
 // NSResponse sets the Answers/Authorities depending upon whether we're delegating or authoritative
@@ -606,37 +1489,37 @@ func (x *Xip) NSResponse(name dnsmessage.Name, response Response, logMessage str
 		// we're authoritative, so we reply with the answers
 		response.Answers = append(response.Answers,
 			func(b *dnsmessage.Builder) error {
-				return buildNSRecords(b, name, x.NameServers)
+				return buildNSRecords(b, name, x.nameServersFor(name.String()), x.TTLs().NS)
 			})
 	} else {
 		// we're NOT authoritative, so we reply who is authoritative
 		response.Authorities = append(response.Authorities,
 			func(b *dnsmessage.Builder) error {
-				return buildNSRecords(b, name, nameServers)
+				return buildNSRecords(b, name, nameServers, x.TTLs().NS)
 			})
 		logMessage += "nil, NS " // we're not supplying an answer; we're supplying the NS record that's authoritative
 	}
 	response.Additionals = append(response.Additionals,
 		func(b *dnsmessage.Builder) error {
 			for _, nameServer := range nameServers {
-				for _, aResource := range NameToA(nameServer.NS.String()) {
+				for _, aResource := range x.NameToA(nameServer.NS.String()) {
 					err := b.AResource(dnsmessage.ResourceHeader{
 						Name:   nameServer.NS,
 						Type:   dnsmessage.TypeA,
 						Class:  dnsmessage.ClassINET,
-						TTL:    604800, // 60 * 60 * 24 * 7 == 1 week; long TTL, these IP addrs don't change
+						TTL:    x.TTLs().NS,
 						Length: 0,
 					}, aResource)
 					if err != nil {
 						return err
 					}
 				}
-				for _, aaaaResource := range NameToAAAA(nameServer.NS.String()) {
+				for _, aaaaResource := range x.NameToAAAA(nameServer.NS.String()) {
 					err := b.AAAAResource(dnsmessage.ResourceHeader{
 						Name:   nameServer.NS,
 						Type:   dnsmessage.TypeAAAA,
 						Class:  dnsmessage.ClassINET,
-						TTL:    604800, // 60 * 60 * 24 * 7 == 1 week; long TTL, these IP addrs don't change
+						TTL:    x.TTLs().NS,
 						Length: 0,
 					}, aaaaResource)
 					if err != nil {
@@ -652,13 +1535,13 @@ func (x *Xip) NSResponse(name dnsmessage.Name, response Response, logMessage str
 	return response, logMessage + strings.Join(logMessages, ", "), nil
 }
 
-func buildNSRecords(b *dnsmessage.Builder, name dnsmessage.Name, nameServers []dnsmessage.NSResource) error {
+func buildNSRecords(b *dnsmessage.Builder, name dnsmessage.Name, nameServers []dnsmessage.NSResource, ttl uint32) error {
 	for _, nameServer := range nameServers {
 		err := b.NSResource(dnsmessage.ResourceHeader{
 			Name:   name,
 			Type:   dnsmessage.TypeNS,
 			Class:  dnsmessage.ClassINET,
-			TTL:    604800, // 60 * 60 * 24 * 7 == 1 week; long TTL, these IP addrs don't change
+			TTL:    ttl,
 			Length: 0,
 		}, nameServer)
 		if err != nil {
@@ -670,10 +1553,10 @@ func buildNSRecords(b *dnsmessage.Builder, name dnsmessage.Name, nameServers []d
 
 // NameToA returns an []AResource that matched the hostname; it returns an
 // array of zero-or-one records
-func NameToA(fqdnString string) []dnsmessage.AResource {
+func (x *Xip) NameToA(fqdnString string) []dnsmessage.AResource {
 	fqdn := []byte(fqdnString)
 	// is it a customized A record? If so, return early
-	if domain, ok := Customizations[strings.ToLower(fqdnString)]; ok && len(domain.A) > 0 {
+	if domain, ok := x.customizations[strings.ToLower(fqdnString)]; ok && len(domain.A) > 0 {
 		return domain.A
 	}
 	for _, ipv4RE := range []*regexp.Regexp{ipv4REDashes, ipv4REDots} {
@@ -696,10 +1579,10 @@ func NameToA(fqdnString string) []dnsmessage.AResource {
 }
 
 // NameToAAAA returns an []AAAAResource that matched the hostname
-func NameToAAAA(fqdnString string) []dnsmessage.AAAAResource {
+func (x *Xip) NameToAAAA(fqdnString string) []dnsmessage.AAAAResource {
 	fqdn := []byte(fqdnString)
 	// is it a customized AAAA record? If so, return early
-	if domain, ok := Customizations[strings.ToLower(fqdnString)]; ok && len(domain.AAAA) > 0 {
+	if domain, ok := x.customizations[strings.ToLower(fqdnString)]; ok && len(domain.AAAA) > 0 {
 		return domain.AAAA
 	}
 	if !ipv6RE.Match(fqdn) {
@@ -724,8 +1607,8 @@ func NameToAAAA(fqdnString string) []dnsmessage.AAAAResource {
 }
 
 // CNAMEResource returns the CNAME via Customizations, otherwise nil
-func CNAMEResource(fqdnString string) *dnsmessage.CNAMEResource {
-	if domain, ok := Customizations[strings.ToLower(fqdnString)]; ok && domain.CNAME != (dnsmessage.CNAMEResource{}) {
+func (x *Xip) CNAMEResource(fqdnString string) *dnsmessage.CNAMEResource {
+	if domain, ok := x.customizations[strings.ToLower(fqdnString)]; ok && domain.CNAME != (dnsmessage.CNAMEResource{}) {
 		return &domain.CNAME
 	}
 	return nil
@@ -733,8 +1616,8 @@ func CNAMEResource(fqdnString string) *dnsmessage.CNAMEResource {
 
 // MXResources returns either 1 or more MX records set via Customizations or
 // an MX record pointing to the queried record
-func MXResources(fqdnString string) []dnsmessage.MXResource {
-	if domain, ok := Customizations[strings.ToLower(fqdnString)]; ok && len(domain.MX) > 0 {
+func (x *Xip) MXResources(fqdnString string) []dnsmessage.MXResource {
+	if domain, ok := x.customizations[strings.ToLower(fqdnString)]; ok && len(domain.MX) > 0 {
 		return domain.MX
 	}
 	mx, _ := dnsmessage.NewName(fqdnString)
@@ -746,10 +1629,20 @@ func MXResources(fqdnString string) []dnsmessage.MXResource {
 	}
 }
 
-func IsAcmeChallenge(fqdnString string) bool {
+// SRVResources returns the SRV records set via Customizations, if any
+func (x *Xip) SRVResources(fqdnString string) []dnsmessage.SRVResource {
+	return x.customizations[strings.ToLower(fqdnString)].SRV
+}
+
+// CAAResources returns the CAA records set via Customizations, if any
+func (x *Xip) CAAResources(fqdnString string) []CAAResource {
+	return x.customizations[strings.ToLower(fqdnString)].CAA
+}
+
+func (x *Xip) IsAcmeChallenge(fqdnString string) bool {
 	if dns01ChallengeRE.MatchString(fqdnString) {
-		ipv4s := NameToA(fqdnString)
-		ipv6s := NameToAAAA(fqdnString)
+		ipv4s := x.NameToA(fqdnString)
+		ipv6s := x.NameToAAAA(fqdnString)
 		if len(ipv4s) > 0 || len(ipv6s) > 0 {
 			return true
 		}
@@ -759,24 +1652,24 @@ func IsAcmeChallenge(fqdnString string) bool {
 
 func (x *Xip) NSResources(fqdnString string) []dnsmessage.NSResource {
 	if x.blocklist(fqdnString) {
-		x.Metrics.AnsweredQueries++
-		x.Metrics.AnsweredBlockedQueries++
-		return x.NameServers
+		x.stats().AnsweredQueries.Add(1)
+		x.stats().AnsweredBlockedQueries.Add(1)
+		return x.nameServersFor(fqdnString)
 	}
-	if IsAcmeChallenge(fqdnString) {
-		x.Metrics.AnsweredNSDNS01ChallengeQueries++
+	if x.IsAcmeChallenge(fqdnString) {
+		x.stats().AnsweredNSDNS01ChallengeQueries.Add(1)
 		strippedFqdn := dns01ChallengeRE.ReplaceAllString(fqdnString, "")
 		ns, _ := dnsmessage.NewName(strippedFqdn)
 		return []dnsmessage.NSResource{{NS: ns}}
 	}
-	x.Metrics.AnsweredQueries++
-	return x.NameServers
+	x.stats().AnsweredQueries.Add(1)
+	return x.nameServersFor(fqdnString)
 }
 
 // TXTResources returns TXT records from Customizations
 func (x *Xip) TXTResources(fqdn string, ip net.IP) ([]dnsmessage.TXTResource, error) {
-	if domain, ok := Customizations[strings.ToLower(fqdn)]; ok {
-		// Customizations[strings.ToLower(fqdn)] returns a _function_,
+	if domain, ok := x.customizations[strings.ToLower(fqdn)]; ok {
+		// x.customizations[strings.ToLower(fqdn)] returns a _function_,
 		// we call that function, which has the same return signature as this method
 		if domain.TXT != nil {
 			return domain.TXT(x, ip)
@@ -785,41 +1678,80 @@ func (x *Xip) TXTResources(fqdn string, ip net.IP) ([]dnsmessage.TXTResource, er
 	return nil, nil
 }
 
-func SOAAuthority(name dnsmessage.Name) (dnsmessage.ResourceHeader, dnsmessage.SOAResource) {
+// SOAAuthority returns the SOA for the authority section of a negative
+// response. Its TTL is the negative TTL, but no more than the SOA's MINIMUM:
+// resolvers cache the negative answer for the lesser of the two (RFC 2308
+// section 5), so a longer TTL would be pointless.
+func (x *Xip) SOAAuthority(name dnsmessage.Name) (dnsmessage.ResourceHeader, dnsmessage.SOAResource) {
+	soaResource := x.SOAResource(name)
+	ttl := x.TTLs().Negative
+	if soaResource.MinTTL < ttl {
+		ttl = soaResource.MinTTL
+	}
 	return dnsmessage.ResourceHeader{
 		Name:   name,
 		Type:   dnsmessage.TypeSOA,
 		Class:  dnsmessage.ClassINET,
-		TTL:    604800, // 60 * 60 * 24 * 7 == 1 week; it's not gonna change
+		TTL:    ttl,
 		Length: 0,
-	}, SOAResource(name)
+	}, soaResource
 }
 
-// SOAResource returns the hard-coded (except MNAME) SOA
-func SOAResource(name dnsmessage.Name) dnsmessage.SOAResource {
-	return dnsmessage.SOAResource{
-		NS:     name,
-		MBox:   mbox,
-		Serial: 2023093000,
-		// cribbed the Refresh/Retry/Expire from google.com.
-		// MinTTL was 300, but I dropped to 180 for faster
-		// key-value propagation
-		Refresh: 900,
-		Retry:   900,
-		Expire:  1800,
-		MinTTL:  180,
+// SOAResource returns the SOA, which is the same for every name, except a
+// tenant's names have the tenant's contact and nameservers. Its MNAME is
+// the primary (first) nameserver unless configured otherwise, or, if there are
+// no nameservers, the name itself.
+func (x *Xip) SOAResource(name dnsmessage.Name) dnsmessage.SOAResource {
+	soa := x.soa
+	if soa.MBox.Length == 0 {
+		soa = defaultSOA() // e.g. a Xip that didn't come from NewXip()
 	}
+	if t := x.tenantFor(name.String()); t != nil {
+		if len(t.nameServers) > 0 {
+			soa.NS = t.nameServers[0].NS
+		}
+		if t.mbox.Length > 0 {
+			soa.MBox = t.mbox
+		}
+	}
+	if soa.NS.Length == 0 {
+		soa.NS = name
+		if len(x.nameServers) > 0 {
+			soa.NS = x.nameServers[0].NS
+		}
+	}
+	if soa.Serial == 0 {
+		soa.Serial = x.configSerial
+	}
+	return soa
 }
 
 // PTRResource returns the PTR record, otherwise nil
 func (x *Xip) PTRResource(fqdn []byte) *dnsmessage.PTRResource {
+	ptr, ipv6 := ptrResource(fqdn, x.ptrDomainFor(string(fqdn)))
+	switch {
+	case ptr == nil:
+	case ipv6:
+		x.stats().AnsweredQueries.Add(1)
+		x.stats().AnsweredPTRQueriesIPv6.Add(1)
+	default:
+		x.stats().AnsweredQueries.Add(1)
+		x.stats().AnsweredPTRQueriesIPv4.Add(1)
+	}
+	return ptr
+}
+
+// ptrResource is PTRResource without the metrics, and tells whether the PTR
+// record is for an IPv6 address. The PTR record points into ptrDomain, e.g.
+// "sslip.io."
+func ptrResource(fqdn []byte, ptrDomain string) (ptr *dnsmessage.PTRResource, ipv6 bool) {
 	// "reverse", for example, means "1.0.0.127", as in "1.0.0.127.in-addr.arpa"
 	// the regular IP would be "127.0.0.1"
 	if ipv4ReverseRE.Match(fqdn) {
 		reversedIPv4 := ipv4ReverseRE.FindSubmatch(fqdn)[1]
 		reversedIPv4address := net.ParseIP(string(reversedIPv4)).To4()
 		if reversedIPv4address == nil {
-			return nil
+			return nil, false
 		}
 		ip := netip.AddrFrom4([4]byte{
 			reversedIPv4address[3],
@@ -827,15 +1759,13 @@ func (x *Xip) PTRResource(fqdn []byte) *dnsmessage.PTRResource {
 			reversedIPv4address[1],
 			reversedIPv4address[0],
 		})
-		ptrName, err := dnsmessage.NewName(strings.ReplaceAll(ip.String(), ".", "-") + ".sslip.io.")
+		ptrName, err := dnsmessage.NewName(strings.ReplaceAll(ip.String(), ".", "-") + "." + ptrDomain)
 		if err != nil {
-			return nil
+			return nil, false
 		}
-		x.Metrics.AnsweredQueries++
-		x.Metrics.AnsweredPTRQueriesIPv4++
 		return &dnsmessage.PTRResource{
 			PTR: ptrName,
-		}
+		}, false
 	}
 	if ipv6ReverseRE.Match(fqdn) {
 		b := ipv6ReverseRE.FindSubmatch(fqdn)[1]
@@ -851,19 +1781,17 @@ func (x *Xip) PTRResource(fqdn []byte) *dnsmessage.PTRResource {
 		}
 		ip := net.ParseIP(string(reversed)).To16()
 		if ip == nil {
-			return nil
+			return nil, false
 		}
-		ptrName, err := dnsmessage.NewName(strings.ReplaceAll(ip.String(), ":", "-") + ".sslip.io.")
+		ptrName, err := dnsmessage.NewName(strings.ReplaceAll(ip.String(), ":", "-") + "." + ptrDomain)
 		if err != nil {
-			return nil
+			return nil, false
 		}
-		x.Metrics.AnsweredQueries++
-		x.Metrics.AnsweredPTRQueriesIPv6++
 		return &dnsmessage.PTRResource{
 			PTR: ptrName,
-		}
+		}, true
 	}
-	return nil
+	return nil, false
 }
 
 // TXTSslipIoSPF SFP records for sslio.io
@@ -879,36 +1807,52 @@ func TXTSslipIoSPF(_ *Xip, _ net.IP) ([]dnsmessage.TXTResource, error) {
 
 // TXTIp when TXT for "ip.sslip.io" is queried, return the IP address of the querier
 func TXTIp(x *Xip, srcAddr net.IP) ([]dnsmessage.TXTResource, error) {
-	x.Metrics.AnsweredTXTSrcIPQueries++
+	x.stats().AnsweredTXTSrcIPQueries.Add(1)
 	return []dnsmessage.TXTResource{{TXT: []string{srcAddr.String()}}}, nil
 }
 
 // TXTMetrics when TXT for "metrics.sslip.io" is queried, return the cumulative metrics
 func TXTMetrics(x *Xip, _ net.IP) (txtResources []dnsmessage.TXTResource, err error) {
-	<-x.DnsAmplificationAttackDelay
-	var metrics []string
-	uptime := time.Since(x.Metrics.Start)
-	metrics = append(metrics, fmt.Sprintf("Uptime: %.0f", uptime.Seconds()))
-	metrics = append(metrics, fmt.Sprintf("Blocklist: %s %d,%d",
-		x.BlocklistUpdated.Format("2006-01-02 15:04:05-07"),
-		len(x.BlocklistStrings),
-		len(x.BlocklistCIDRs)))
-	metrics = append(metrics, fmt.Sprintf("Queries: %d (%.1f/s)", x.Metrics.Queries, float64(x.Metrics.Queries)/uptime.Seconds()))
-	metrics = append(metrics, fmt.Sprintf("TCP/UDP: %d/%d", x.Metrics.TCPQueries, x.Metrics.UDPQueries))
-	metrics = append(metrics, fmt.Sprintf("Answered Queries: %d (%.1f/s)", x.Metrics.AnsweredQueries, float64(x.Metrics.AnsweredQueries)/uptime.Seconds()))
-	metrics = append(metrics, fmt.Sprintf("A: %d", x.Metrics.AnsweredAQueries))
-	metrics = append(metrics, fmt.Sprintf("AAAA: %d", x.Metrics.AnsweredAAAAQueries))
-	metrics = append(metrics, fmt.Sprintf("TXT Source: %d", x.Metrics.AnsweredTXTSrcIPQueries))
-	metrics = append(metrics, fmt.Sprintf("TXT Version: %d", x.Metrics.AnsweredTXTVersionQueries))
-	metrics = append(metrics, fmt.Sprintf("PTR IPv4/IPv6: %d/%d", x.Metrics.AnsweredPTRQueriesIPv4, x.Metrics.AnsweredPTRQueriesIPv6))
-	metrics = append(metrics, fmt.Sprintf("NS DNS-01: %d", x.Metrics.AnsweredNSDNS01ChallengeQueries))
-	metrics = append(metrics, fmt.Sprintf("Blocked: %d", x.Metrics.AnsweredBlockedQueries))
-	for _, metric := range metrics {
+	select {
+	case <-x.DnsAmplificationAttackDelay:
+	case <-x.done: // the throttle has stopped
+	}
+	for _, metric := range x.MetricsSummary() {
 		txtResources = append(txtResources, dnsmessage.TXTResource{TXT: []string{metric}})
 	}
 	return txtResources, nil
 }
 
+// MetricsSummary returns the cumulative metrics as human-readable strings, one
+// metric per string, e.g. "Queries: 1 (0.0/s)". Also used when logging the
+// final metrics at shutdown.
+func (x *Xip) MetricsSummary() (metrics []string) {
+	m := x.Metrics()
+	x.configMutex.RLock()
+	blocklistUpdated, blocklistStrings, blocklistCIDRs := x.blocklistUpdated, x.blocklistStrings, x.blocklistCIDRs
+	x.configMutex.RUnlock()
+	uptime := time.Since(m.Start)
+	metrics = append(metrics, fmt.Sprintf("Uptime: %.0f", uptime.Seconds()))
+	metrics = append(metrics, fmt.Sprintf("Blocklist: %s %d,%d",
+		blocklistUpdated.Format("2006-01-02 15:04:05-07"),
+		len(blocklistStrings),
+		len(blocklistCIDRs)))
+	metrics = append(metrics, fmt.Sprintf("Queries: %d (%.1f/s)", m.Queries, float64(m.Queries)/uptime.Seconds()))
+	metrics = append(metrics, fmt.Sprintf("TCP/UDP: %d/%d", m.TCPQueries, m.UDPQueries))
+	metrics = append(metrics, fmt.Sprintf("Answered Queries: %d (%.1f/s)", m.AnsweredQueries, float64(m.AnsweredQueries)/uptime.Seconds()))
+	metrics = append(metrics, fmt.Sprintf("A: %d", m.AnsweredAQueries))
+	metrics = append(metrics, fmt.Sprintf("AAAA: %d", m.AnsweredAAAAQueries))
+	metrics = append(metrics, fmt.Sprintf("TXT Source: %d", m.AnsweredTXTSrcIPQueries))
+	metrics = append(metrics, fmt.Sprintf("TXT Version: %d", m.AnsweredTXTVersionQueries))
+	metrics = append(metrics, fmt.Sprintf("PTR IPv4/IPv6: %d/%d", m.AnsweredPTRQueriesIPv4, m.AnsweredPTRQueriesIPv6))
+	metrics = append(metrics, fmt.Sprintf("NS DNS-01: %d", m.AnsweredNSDNS01ChallengeQueries))
+	metrics = append(metrics, fmt.Sprintf("Blocked: %d", m.AnsweredBlockedQueries))
+	metrics = append(metrics, fmt.Sprintf("Dropped UDP: %d", m.DroppedUDPQueries))
+	metrics = append(metrics, fmt.Sprintf("FORMERR/NOTIMP/REFUSED: %d/%d/%d", m.FormatErrorQueries, m.NotImplementedQueries, m.RefusedQueries))
+	metrics = append(metrics, fmt.Sprintf("Out of zone: %d", m.OutOfZoneQueries))
+	return metrics
+}
+
 // soaLogMessage returns an easy-to-read string for logging SOA Answers/Authorities
 func soaLogMessage(soaResource dnsmessage.SOAResource) string {
 	return soaResource.NS.String() + " " +
@@ -933,14 +1877,32 @@ func (a Metrics) MostlyEquals(b Metrics) bool {
 		a.AnsweredPTRQueriesIPv4 == b.AnsweredPTRQueriesIPv4 &&
 		a.AnsweredPTRQueriesIPv6 == b.AnsweredPTRQueriesIPv6 &&
 		a.AnsweredNSDNS01ChallengeQueries == b.AnsweredNSDNS01ChallengeQueries &&
-		a.AnsweredBlockedQueries == b.AnsweredBlockedQueries {
+		a.AnsweredBlockedQueries == b.AnsweredBlockedQueries &&
+		a.DroppedUDPQueries == b.DroppedUDPQueries &&
+		a.FormatErrorQueries == b.FormatErrorQueries &&
+		a.NotImplementedQueries == b.NotImplementedQueries &&
+		a.RefusedQueries == b.RefusedQueries &&
+		a.OutOfZoneQueries == b.OutOfZoneQueries {
 		return true
 	}
 	return false
 }
 
 func (x *Xip) downloadBlockList(blocklistURL string) string {
-	var err error
+	blocklistStrings, blocklistCIDRs, err := fetchBlocklist(blocklistURL)
+	if err != nil {
+		return err.Error()
+	}
+	x.configMutex.Lock()
+	defer x.configMutex.Unlock()
+	x.blocklistStrings = blocklistStrings
+	x.blocklistCIDRs = blocklistCIDRs
+	x.blocklistUpdated = time.Now()
+	return fmt.Sprintf("Successfully downloaded blocklist from %s: %v, %v", blocklistURL, x.blocklistStrings, x.blocklistCIDRs)
+}
+
+// fetchBlocklist downloads (or, for "file://" URLs, reads) and parses the blocklist
+func fetchBlocklist(blocklistURL string) (blocklistStrings []string, blocklistCIDRs []net.IPNet, err error) {
 	var blocklistReader io.ReadCloser
 	// file protocol's purpose: so I can run tests while flying with no internet
 	// secondary purpose: don't hammer GitHub when running tests
@@ -949,30 +1911,27 @@ func (x *Xip) downloadBlockList(blocklistURL string) string {
 		blocklistPath := strings.TrimPrefix(blocklistURL, "file://")
 		blocklistReader, err = os.Open(blocklistPath)
 		if err != nil {
-			return fmt.Sprintf(`failed to open blocklist "%s": %s`, blocklistPath, err.Error())
+			return nil, nil, fmt.Errorf(`failed to open blocklist "%s": %s`, blocklistPath, err.Error())
 		}
 		//noinspection GoUnhandledErrorResult
 		defer blocklistReader.Close()
 	} else {
 		resp, err := http.Get(blocklistURL)
 		if err != nil {
-			return fmt.Sprintf(`failed to download blocklist "%s": %s`, blocklistURL, err.Error())
+			return nil, nil, fmt.Errorf(`failed to download blocklist "%s": %s`, blocklistURL, err.Error())
 		}
 		blocklistReader = resp.Body
 		//noinspection GoUnhandledErrorResult
 		defer blocklistReader.Close()
 		if resp.StatusCode > 299 {
-			return fmt.Sprintf(`failed to download blocklist "%s", HTTP status: "%d"`, blocklistURL, resp.StatusCode)
+			return nil, nil, fmt.Errorf(`failed to download blocklist "%s", HTTP status: "%d"`, blocklistURL, resp.StatusCode)
 		}
 	}
-	blocklistStrings, blocklistCIDRs, err := ReadBlocklist(blocklistReader)
+	blocklistStrings, blocklistCIDRs, err = ReadBlocklist(blocklistReader)
 	if err != nil {
-		return fmt.Sprintf(`failed to parse blocklist "%s": %s`, blocklistURL, err.Error())
+		return nil, nil, fmt.Errorf(`failed to parse blocklist "%s": %s`, blocklistURL, err.Error())
 	}
-	x.BlocklistStrings = blocklistStrings
-	x.BlocklistCIDRs = blocklistCIDRs
-	x.BlocklistUpdated = time.Now()
-	return fmt.Sprintf("Successfully downloaded blocklist from %s: %v, %v", blocklistURL, x.BlocklistStrings, x.BlocklistCIDRs)
+	return blocklistStrings, blocklistCIDRs, nil
 }
 
 // ReadBlocklist "sanitizes" the block list, removing comments, invalid characters
@@ -1007,8 +1966,8 @@ func ReadBlocklist(blocklist io.Reader) (stringBlocklists []string, cidrBlocklis
 }
 
 func (x *Xip) blocklist(hostname string) bool {
-	aResources := NameToA(hostname)
-	aaaaResources := NameToAAAA(hostname)
+	aResources := x.NameToA(hostname)
+	aaaaResources := x.NameToAAAA(hostname)
 	var ip net.IP
 	if len(aResources) == 1 {
 		ip = aResources[0].A[:]
@@ -1022,139 +1981,15 @@ func (x *Xip) blocklist(hostname string) bool {
 	if ip.IsPrivate() {
 		return false
 	}
-	for _, blockstring := range x.BlocklistStrings {
+	for _, blockstring := range x.blocklistStrings {
 		if strings.Contains(hostname, blockstring) {
 			return true
 		}
 	}
-	for _, blockCIDR := range x.BlocklistCIDRs {
+	for _, blockCIDR := range x.blocklistCIDRs {
 		if blockCIDR.Contains(ip) {
 			return true
 		}
 	}
 	return false
 }
-
-func (x *Xip) nameToAwithBlocklist(q dnsmessage.Question, response Response, logMessage string) (_ Response, _ string, err error) {
-	var nameToAs []dnsmessage.AResource
-	nameToAs = NameToA(q.Name.String())
-	if len(nameToAs) == 0 {
-		// No Answers, only 1 Authorities
-		soaHeader, soaResource := SOAAuthority(q.Name)
-		response.Authorities = append(response.Authorities,
-			func(b *dnsmessage.Builder) error {
-				if err = b.SOAResource(soaHeader, soaResource); err != nil {
-					return err
-				}
-				return nil
-			})
-		return response, logMessage + "nil, SOA " + soaLogMessage(soaResource), nil
-	}
-	if x.blocklist(q.Name.String()) {
-		x.Metrics.AnsweredQueries++
-		x.Metrics.AnsweredBlockedQueries++
-		response.Answers = append(response.Answers,
-			// 1 or more A records; A records > 1 only available via Customizations
-			func(b *dnsmessage.Builder) error {
-				err = b.AResource(dnsmessage.ResourceHeader{
-					Name:   q.Name,
-					Type:   dnsmessage.TypeA,
-					Class:  dnsmessage.ClassINET,
-					TTL:    604800, // 60 * 60 * 24 * 7 == 1 week; long TTL, these IP addrs don't change
-					Length: 0,
-				}, Customizations["ns-aws.sslip.io."].A[0])
-				if err != nil {
-					return err
-				}
-				return nil
-			})
-		return response, logMessage + net.IP(Customizations["ns-aws.sslip.io."].A[0].A[:]).String(), nil
-	}
-	x.Metrics.AnsweredQueries++
-	x.Metrics.AnsweredAQueries++
-	response.Answers = append(response.Answers,
-		// 1 or more A records; A records > 1 only available via Customizations
-		func(b *dnsmessage.Builder) error {
-			for _, nameToA := range nameToAs {
-				err = b.AResource(dnsmessage.ResourceHeader{
-					Name:   q.Name,
-					Type:   dnsmessage.TypeA,
-					Class:  dnsmessage.ClassINET,
-					TTL:    3600, // 60 * 60 == 1 hour; short TTL in case we need to block them
-					Length: 0,
-				}, nameToA)
-				if err != nil {
-					return err
-				}
-			}
-			return nil
-		})
-	var logMessages []string
-	for _, nameToA := range nameToAs {
-		ip := net.IP(nameToA.A[:])
-		logMessages = append(logMessages, ip.String())
-	}
-	return response, logMessage + strings.Join(logMessages, ", "), nil
-}
-
-func (x *Xip) nameToAAAAwithBlocklist(q dnsmessage.Question, response Response, logMessage string) (_ Response, _ string, err error) {
-	var nameToAAAAs []dnsmessage.AAAAResource
-	nameToAAAAs = NameToAAAA(q.Name.String())
-	if len(nameToAAAAs) == 0 {
-		// No Answers, only 1 Authorities
-		soaHeader, soaResource := SOAAuthority(q.Name)
-		response.Authorities = append(response.Authorities,
-			func(b *dnsmessage.Builder) error {
-				if err = b.SOAResource(soaHeader, soaResource); err != nil {
-					return err
-				}
-				return nil
-			})
-		return response, logMessage + "nil, SOA " + soaLogMessage(soaResource), nil
-	}
-	if x.blocklist(q.Name.String()) {
-		x.Metrics.AnsweredQueries++
-		x.Metrics.AnsweredBlockedQueries++
-		response.Answers = append(response.Answers,
-			// 1 or more A records; A records > 1 only available via Customizations
-			func(b *dnsmessage.Builder) error {
-				err = b.AAAAResource(dnsmessage.ResourceHeader{
-					Name:   q.Name,
-					Type:   dnsmessage.TypeA,
-					Class:  dnsmessage.ClassINET,
-					TTL:    604800, // 60 * 60 * 24 * 7 == 1 week; long TTL, these IP addrs don't change
-					Length: 0,
-				}, Customizations["ns-aws.sslip.io."].AAAA[0])
-				if err != nil {
-					return err
-				}
-				return nil
-			})
-		return response, logMessage + net.IP(Customizations["ns-aws.sslip.io."].AAAA[0].AAAA[:]).String(), nil
-	}
-	x.Metrics.AnsweredQueries++
-	x.Metrics.AnsweredAAAAQueries++
-	response.Answers = append(response.Answers,
-		// 1 or more AAAA records; AAAA records > 1 only available via Customizations
-		func(b *dnsmessage.Builder) error {
-			for _, nameToAAAA := range nameToAAAAs {
-				err = b.AAAAResource(dnsmessage.ResourceHeader{
-					Name:   q.Name,
-					Type:   dnsmessage.TypeAAAA,
-					Class:  dnsmessage.ClassINET,
-					TTL:    3600, // 60 * 60 == 1 hour; short TTL in case we need to block them
-					Length: 0,
-				}, nameToAAAA)
-				if err != nil {
-					return err
-				}
-			}
-			return nil
-		})
-	var logMessages []string
-	for _, nameToAAAA := range nameToAAAAs {
-		ip := net.IP(nameToAAAA.AAAA[:])
-		logMessages = append(logMessages, ip.String())
-	}
-	return response, logMessage + strings.Join(logMessages, ", "), nil
-}
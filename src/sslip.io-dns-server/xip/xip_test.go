@@ -15,34 +15,37 @@ import (
 var _ = Describe("Xip", func() {
 	var (
 		err error
+		x   *xip.Xip
 	)
 	rand.Seed(GinkgoRandomSeed()) // Set to ginkgo's seed so that it's different each test & we can reproduce failures if necessary
+	BeforeEach(func() {
+		x, _ = xip.NewXip("file:///", []string{"ns-aws.sslip.io."}, []string{})
+	})
 
 	Describe("CNAMEResources()", func() {
 		It("returns nil by default", func() {
 			randomDomain := testhelper.Random8ByteString() + ".com."
-			cname := xip.CNAMEResource(randomDomain)
+			cname := x.CNAMEResource(randomDomain)
 			Expect(cname).To(BeNil())
 		})
 		When("querying one of sslip.io's DKIM CNAME's", func() {
 			It("returns the CNAME", func() {
-				cname := xip.CNAMEResource("protonmail._domainkey.SSlip.Io.")
+				cname := x.CNAMEResource("protonmail._domainkey.SSlip.Io.")
 				Expect(cname.CNAME.String()).To(MatchRegexp("^protonmail\\.domainkey.*.domains\\.proton\\.ch\\.$"))
 			})
 		})
 		When("a domain has been customized but has no CNAMEs", func() {
 			It("returns nil", func() {
 				customizedDomain := testhelper.Random8ByteString() + ".com."
-				xip.Customizations[customizedDomain] = xip.DomainCustomization{}
-				cname := xip.CNAMEResource(customizedDomain)
+				customize(x, customizedDomain, xip.DomainCustomization{})
+				cname := x.CNAMEResource(customizedDomain)
 				Expect(cname).To(BeNil())
-				delete(xip.Customizations, customizedDomain)
 			})
 		})
 		When("a domain has been customized with CNAMES", func() {
 			It("returns CNAME resources", func() {
 				customizedDomain := testhelper.Random8ByteString() + ".com."
-				xip.Customizations[strings.ToLower(customizedDomain)] = xip.DomainCustomization{
+				customize(x, customizedDomain, xip.DomainCustomization{
 					CNAME: dnsmessage.CNAMEResource{
 						CNAME: dnsmessage.Name{
 							// google.com.
@@ -52,10 +55,9 @@ var _ = Describe("Xip", func() {
 							},
 						},
 					},
-				}
-				cname := xip.CNAMEResource(customizedDomain)
+				})
+				cname := x.CNAMEResource(customizedDomain)
 				Expect(cname.CNAME.String()).To(Equal("google.com."))
-				delete(xip.Customizations, customizedDomain) // clean-up
 			})
 		})
 	})
@@ -63,16 +65,16 @@ var _ = Describe("Xip", func() {
 	Describe("MXResources()", func() {
 		It("returns the MX resource", func() {
 			randomDomain := testhelper.Random8ByteString() + ".com."
-			mx := xip.MXResources(randomDomain)
+			mx := x.MXResources(randomDomain)
 			mxHostName := dnsmessage.MustNewName(randomDomain)
 			Expect(len(mx)).To(Equal(1))
 			Expect(mx[0].MX).To(Equal(mxHostName))
 		})
 		When("sslip.io is the domain being queried", func() {
 			It("returns sslip.io's custom MX records", func() {
-				mx := xip.MXResources("sslIP.iO.")
+				mx := x.MXResources("sslIP.iO.")
 				Expect(len(mx)).To(Equal(2))
-				Expect(mx[0].MX.Data).To(Equal(xip.Customizations["sslip.io."].MX[0].MX.Data))
+				Expect(mx[0].MX.Data).To(Equal(xip.DefaultCustomizations()["sslip.io."].MX[0].MX.Data))
 			})
 		})
 	})
@@ -95,7 +97,7 @@ var _ = Describe("Xip", func() {
 						ns := x.NSResources("_acme-challenge." + randomDomain)
 						Expect(len(ns)).To(Equal(1))
 						Expect(ns[0].NS.String()).To(Equal(randomDomain))
-						aResources := xip.NameToA(randomDomain)
+						aResources := x.NameToA(randomDomain)
 						Expect(len(aResources)).To(Equal(1))
 						Expect(err).ToNot(HaveOccurred())
 						Expect(aResources[0].A).To(Equal([4]byte{192, 168, 0, 1}))
@@ -124,17 +126,670 @@ var _ = Describe("Xip", func() {
 		})
 	})
 
+	Describe("NewXip()", func() {
+		When("the blocklist URL is empty", func() {
+			It("doesn't try to download a blocklist", func() {
+				x, logmessages := xip.NewXip("", []string{"ns-aws.sslip.io."}, []string{})
+				defer x.Close()
+				Expect(logmessages).To(Equal([]string{`Adding nameserver "ns-aws.sslip.io."`}))
+				blocklistStrings, _, updated := x.Blocklist()
+				Expect(blocklistStrings).To(BeEmpty())
+				Expect(updated.IsZero()).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("Reload()", func() {
+		It("replaces the nameservers, the addresses, and the blocklist", func() {
+			x, _ := xip.NewXip("file:///", []string{"ns-old.example.com."}, []string{"old.example.com.=10.0.0.1"})
+			Expect(x.NameToA("old.example.com.")).To(HaveLen(1))
+			blocklistStrings, _, _ := x.Blocklist()
+			Expect(blocklistStrings).To(BeEmpty())
+
+			logmessages := x.Reload("file://../../../etc/blocklist.txt",
+				[]string{"ns-new.example.com."},
+				[]string{"new.example.com.=10.0.0.2", "new.example.com.=2001:db8::2"})
+			Expect(logmessages).To(ContainElements(
+				`Adding nameserver "ns-new.example.com."`,
+				`Adding record "new.example.com.=10.0.0.2"`,
+				`Adding record "new.example.com.=2001:db8::2"`,
+				MatchRegexp(`^Successfully downloaded blocklist from file://../../../etc/blocklist.txt`)))
+			ns := x.NSResources(testhelper.Random8ByteString() + ".com.")
+			Expect(ns).To(HaveLen(1))
+			Expect(ns[0].NS.String()).To(Equal("ns-new.example.com."))
+			Expect(x.NameToA("old.example.com.")).To(BeEmpty())
+			Expect(x.NameToA("new.example.com.")).To(Equal([]dnsmessage.AResource{{A: [4]byte{10, 0, 0, 2}}}))
+			Expect(x.NameToAAAA("new.example.com.")).To(HaveLen(1))
+			blocklistStrings, _, _ = x.Blocklist()
+			Expect(blocklistStrings).ToNot(BeEmpty())
+			Expect(x.NameServers()).To(Equal(ns))
+			// the hard-coded customizations survive
+			Expect(x.MXResources("sslip.io.")).To(HaveLen(2))
+		})
+		When("the blocklist can't be downloaded", func() {
+			It("keeps the previous blocklist, but reloads the rest", func() {
+				x, _ := xip.NewXip("file://../../../etc/blocklist.txt", []string{"ns-old.example.com."}, []string{})
+				blocklistStrings, _, _ := x.Blocklist()
+				Expect(blocklistStrings).ToNot(BeEmpty())
+
+				logmessages := x.Reload("file:///non-existent", []string{"ns-new.example.com."}, []string{})
+				Expect(logmessages).To(ContainElement(MatchRegexp(`^failed to open blocklist "/non-existent": .*; keeping the previous blocklist$`)))
+				reloadedStrings, _, _ := x.Blocklist()
+				Expect(reloadedStrings).To(Equal(blocklistStrings))
+				Expect(x.NSResources(testhelper.Random8ByteString() + ".com.")[0].NS.String()).To(Equal("ns-new.example.com."))
+			})
+		})
+		When("the blocklist URL is empty", func() {
+			It("drops the blocklist rather than trying to download one", func() {
+				x, _ := xip.NewXip("file://../../../etc/blocklist.txt", []string{"ns-aws.sslip.io."}, []string{})
+				blocklistStrings, _, _ := x.Blocklist()
+				Expect(blocklistStrings).ToNot(BeEmpty())
+
+				logmessages := x.Reload("", []string{"ns-aws.sslip.io."}, []string{})
+				Expect(logmessages).To(Equal([]string{`Adding nameserver "ns-aws.sslip.io."`}))
+				blocklistStrings, blocklistCIDRs, updated := x.Blocklist()
+				Expect(blocklistStrings).To(BeEmpty())
+				Expect(blocklistCIDRs).To(BeEmpty())
+				Expect(updated.IsZero()).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("SetCustomizations()", func() {
+		It("replaces the built-in records", func() {
+			x.SetCustomizations(xip.DomainCustomizations{"example.com.": {MX: []dnsmessage.MXResource{{Pref: 5, MX: dnsmessage.MustNewName("mail.example.com.")}}}})
+			Expect(x.MXResources("example.com.")[0].MX.String()).To(Equal("mail.example.com."))
+			Expect(x.MXResources("sslip.io.")[0].MX.String()).To(Equal("sslip.io.")) // the MX pointing to the name
+			Expect(x.CNAMEResource("protonmail._domainkey.sslip.io.")).To(BeNil())
+		})
+		It("keeps the -addresses records", func() {
+			x, _ = xip.NewXip("file:///", []string{"ns-aws.sslip.io."}, []string{"www.example.com=10.0.0.1"})
+			x.SetCustomizations(xip.DomainCustomizations{})
+			Expect(x.NameToA("www.example.com.")).To(Equal([]dnsmessage.AResource{{A: [4]byte{10, 0, 0, 1}}}))
+		})
+		It("doesn't affect other Xips", func() {
+			other, _ := xip.NewXip("file:///", []string{"ns-aws.sslip.io."}, []string{"www.example.com=10.0.0.2"})
+			customize(x, "custom.example.com.", xip.DomainCustomization{A: []dnsmessage.AResource{{A: [4]byte{10, 0, 0, 3}}}})
+			Expect(x.NameToA("www.example.com.")).To(BeEmpty())
+			Expect(other.NameToA("custom.example.com.")).To(BeEmpty())
+			Expect(other.MXResources("sslip.io.")).To(HaveLen(2))
+		})
+	})
+
+	Describe("Version", func() {
+		It("is what we answer to version.status.sslip.io", func() {
+			x.Version = xip.Version{Semantic: "1.2.3", Date: "2024/01/01-00:00:00+0000", GitHash: "abc1234"}
+			txts, err := x.TXTResources("version.status.sslip.io.", nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(txts).To(Equal([]dnsmessage.TXTResource{{TXT: []string{"1.2.3"}}, {TXT: []string{"2024/01/01-00:00:00+0000"}}, {TXT: []string{"abc1234"}}}))
+		})
+	})
+
+	Describe("UDPQueryResponse()", func() {
+		var customizedDomain string
+		query := func(name string, edns *dnsmessage.ResourceHeader) []byte {
+			msg := dnsmessage.Message{
+				Header: dnsmessage.Header{ID: 1035},
+				Questions: []dnsmessage.Question{{
+					Name:  dnsmessage.MustNewName(name),
+					Type:  dnsmessage.TypeA,
+					Class: dnsmessage.ClassINET,
+				}},
+			}
+			if edns != nil {
+				msg.Additionals = []dnsmessage.Resource{{Header: *edns, Body: &dnsmessage.OPTResource{}}}
+			}
+			queryBytes, err := msg.Pack()
+			Expect(err).ToNot(HaveOccurred())
+			return queryBytes
+		}
+		ednsHeader := func(udpPayloadSize int) *dnsmessage.ResourceHeader {
+			var header dnsmessage.ResourceHeader
+			Expect(header.SetEDNS0(udpPayloadSize, dnsmessage.RCodeSuccess, true)).To(Succeed())
+			return &header
+		}
+		unpack := func(responseBytes []byte) (response dnsmessage.Message) {
+			Expect(response.Unpack(responseBytes)).To(Succeed())
+			return response
+		}
+		BeforeEach(func() {
+			// 64 A records * 16 bytes apiece won't fit in 512 bytes
+			customizedDomain = strings.ToLower(testhelper.Random8ByteString()) + ".com."
+			var aResources []dnsmessage.AResource
+			for i := 0; i < 64; i++ {
+				aResources = append(aResources, dnsmessage.AResource{A: [4]byte{10, 0, 0, byte(i)}})
+			}
+			customize(x, customizedDomain, xip.DomainCustomization{A: aResources})
+		})
+		When("the query doesn't have an OPT record", func() {
+			It("doesn't add an OPT record to the response", func() {
+				responseBytes, _, err := x.UDPQueryResponse(query("127.0.0.1.sslip.io.", nil), nil)
+				Expect(err).ToNot(HaveOccurred())
+				response := unpack(responseBytes)
+				Expect(response.Header.ID).To(Equal(uint16(1035)))
+				Expect(response.Answers).To(HaveLen(1))
+				Expect(response.Additionals).To(BeEmpty())
+			})
+			It("truncates responses larger than 512 bytes", func() {
+				responseBytes, logMessage, err := x.UDPQueryResponse(query(customizedDomain, nil), nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(len(responseBytes)).To(BeNumerically("<=", 512))
+				response := unpack(responseBytes)
+				Expect(response.Header.Truncated).To(BeTrue())
+				Expect(response.Answers).To(BeEmpty())
+				Expect(logMessage).To(HaveSuffix(" (truncated)"))
+			})
+		})
+		When("the query has an OPT record", func() {
+			It("echoes an OPT record with our UDP payload size and the DO bit", func() {
+				responseBytes, _, err := x.UDPQueryResponse(query("127.0.0.1.sslip.io.", ednsHeader(4096)), nil)
+				Expect(err).ToNot(HaveOccurred())
+				response := unpack(responseBytes)
+				Expect(response.Additionals).To(HaveLen(1))
+				Expect(response.Additionals[0].Header.Type).To(Equal(dnsmessage.TypeOPT))
+				Expect(int(response.Additionals[0].Header.Class)).To(Equal(xip.EDNSUDPPayloadSize))
+				Expect(response.Additionals[0].Header.DNSSECAllowed()).To(BeTrue())
+			})
+			It("doesn't truncate responses that fit in the advertised UDP payload size", func() {
+				responseBytes, _, err := x.UDPQueryResponse(query(customizedDomain, ednsHeader(4096)), nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(len(responseBytes)).To(BeNumerically(">", 512))
+				response := unpack(responseBytes)
+				Expect(response.Header.Truncated).To(BeFalse())
+				Expect(response.Answers).To(HaveLen(64))
+			})
+			It("truncates responses that don't fit in the advertised UDP payload size, but keeps the OPT record", func() {
+				responseBytes, _, err := x.UDPQueryResponse(query(customizedDomain, ednsHeader(600)), nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(len(responseBytes)).To(BeNumerically("<=", 600))
+				response := unpack(responseBytes)
+				Expect(response.Header.Truncated).To(BeTrue())
+				Expect(response.Answers).To(BeEmpty())
+				Expect(response.Additionals).To(HaveLen(1))
+				Expect(response.Additionals[0].Header.Type).To(Equal(dnsmessage.TypeOPT))
+			})
+			It("returns BADVERS when the EDNS version isn't 0", func() {
+				badVersion := ednsHeader(4096)
+				badVersion.TTL |= 1 << 16 // EDNS version 1
+				responseBytes, logMessage, err := x.UDPQueryResponse(query("127.0.0.1.sslip.io.", badVersion), nil)
+				Expect(err).ToNot(HaveOccurred())
+				response := unpack(responseBytes)
+				Expect(response.Answers).To(BeEmpty())
+				Expect(response.Additionals[0].Header.ExtendedRCode(response.Header.RCode)).To(Equal(xip.RCodeBadVersion))
+				Expect(logMessage).To(HaveSuffix("BADVERS"))
+			})
+		})
+		When("it's not UDP", func() {
+			It("doesn't truncate the response", func() {
+				responseBytes, _, err := x.QueryResponse(query(customizedDomain, nil), nil)
+				Expect(err).ToNot(HaveOccurred())
+				response := unpack(responseBytes)
+				Expect(response.Header.Truncated).To(BeFalse())
+				Expect(response.Answers).To(HaveLen(64))
+			})
+		})
+	})
+
+	Describe("QueryResponse()", func() {
+		var x xip.Xip
+		question := dnsmessage.Question{
+			Name:  dnsmessage.MustNewName("127-0-0-1.sslip.io."),
+			Type:  dnsmessage.TypeA,
+			Class: dnsmessage.ClassINET,
+		}
+		pack := func(msg dnsmessage.Message) []byte {
+			queryBytes, err := msg.Pack()
+			Expect(err).ToNot(HaveOccurred())
+			return queryBytes
+		}
+		respond := func(queryBytes []byte) (response dnsmessage.Message, logMessage string) {
+			responseBytes, logMessage, err := x.QueryResponse(queryBytes, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.Unpack(responseBytes)).To(Succeed())
+			Expect(response.Header.ID).To(Equal(uint16(53)))
+			Expect(response.Header.Response).To(BeTrue())
+			Expect(response.Answers).To(BeEmpty())
+			return response, logMessage
+		}
+		BeforeEach(func() {
+			x = xip.Xip{}
+		})
+		When("the query has no question", func() {
+			It("returns FORMERR", func() {
+				response, logMessage := respond(pack(dnsmessage.Message{Header: dnsmessage.Header{ID: 53}}))
+				Expect(response.Header.RCode).To(Equal(dnsmessage.RCodeFormatError))
+				Expect(response.Questions).To(BeEmpty())
+				Expect(logMessage).To(Equal("? FormatError (no question)"))
+				Expect(x.Metrics().FormatErrorQueries).To(Equal(1))
+				Expect(x.Metrics().Queries).To(Equal(1))
+			})
+		})
+		When("the query has more than one question", func() {
+			It("returns FORMERR", func() {
+				response, logMessage := respond(pack(dnsmessage.Message{Header: dnsmessage.Header{ID: 53}, Questions: []dnsmessage.Question{question, question}}))
+				Expect(response.Header.RCode).To(Equal(dnsmessage.RCodeFormatError))
+				Expect(logMessage).To(Equal("? FormatError (more than one question)"))
+				Expect(x.Metrics().FormatErrorQueries).To(Equal(1))
+			})
+		})
+		When("the question is unparseable", func() {
+			It("returns FORMERR", func() {
+				queryBytes := pack(dnsmessage.Message{Header: dnsmessage.Header{ID: 53}, Questions: []dnsmessage.Question{question}})
+				response, logMessage := respond(queryBytes[:len(queryBytes)-3]) // lop off the type & class
+				Expect(response.Header.RCode).To(Equal(dnsmessage.RCodeFormatError))
+				Expect(logMessage).To(Equal("? FormatError (unparseable question)"))
+			})
+		})
+		When("the query is too short to have a header", func() {
+			It("returns an error because it can't echo the ID", func() {
+				_, _, err := x.QueryResponse([]byte{0, 53, 0}, nil)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+		When("the OpCode isn't QUERY", func() {
+			It("returns NOTIMP, echoing the OpCode & the question", func() {
+				const opCodeNotify = 4
+				response, logMessage := respond(pack(dnsmessage.Message{Header: dnsmessage.Header{ID: 53, OpCode: opCodeNotify}, Questions: []dnsmessage.Question{question}}))
+				Expect(response.Header.RCode).To(Equal(dnsmessage.RCodeNotImplemented))
+				Expect(response.Header.OpCode).To(Equal(dnsmessage.OpCode(opCodeNotify)))
+				Expect(response.Questions).To(Equal([]dnsmessage.Question{question}))
+				Expect(logMessage).To(Equal("OpCode 4 TypeA 127-0-0-1.sslip.io. ? NotImplemented"))
+				Expect(x.Metrics().NotImplementedQueries).To(Equal(1))
+			})
+		})
+		When("the name has no records of any type", func() {
+			It("returns NXDOMAIN, with the SOA's MINIMUM as the negative TTL", func() {
+				nonexistent := dnsmessage.Question{Name: dnsmessage.MustNewName("non-existent.sslip.io."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+				response, logMessage := respond(pack(dnsmessage.Message{Header: dnsmessage.Header{ID: 53}, Questions: []dnsmessage.Question{nonexistent}}))
+				Expect(response.Header.RCode).To(Equal(dnsmessage.RCodeNameError))
+				Expect(response.Authorities).To(HaveLen(1))
+				soa := response.Authorities[0].Body.(*dnsmessage.SOAResource)
+				Expect(response.Authorities[0].Header.TTL).To(Equal(soa.MinTTL))
+				Expect(logMessage).To(HavePrefix("TypeA non-existent.sslip.io. ? NXDOMAIN, SOA "))
+			})
+		})
+		When("the name has records, but not of the queried type", func() {
+			It("returns NODATA (NOERROR, no answers), with the SOA's MINIMUM as the negative TTL", func() {
+				response, logMessage := respond(pack(dnsmessage.Message{Header: dnsmessage.Header{ID: 53}, Questions: []dnsmessage.Question{{
+					Name: dnsmessage.MustNewName("1-2-3-4.sslip.io."), Type: dnsmessage.TypeAAAA, Class: dnsmessage.ClassINET}}}))
+				Expect(response.Header.RCode).To(Equal(dnsmessage.RCodeSuccess))
+				Expect(response.Authorities).To(HaveLen(1))
+				soa := response.Authorities[0].Body.(*dnsmessage.SOAResource)
+				Expect(response.Authorities[0].Header.TTL).To(Equal(soa.MinTTL))
+				Expect(logMessage).To(HavePrefix("TypeAAAA 1-2-3-4.sslip.io. ? nil, SOA "))
+			})
+		})
+		When("the name is above a name with an embedded IP", func() {
+			It("returns NXDOMAIN, for otherwise every name would exist", func() {
+				response, _ := respond(pack(dnsmessage.Message{Header: dnsmessage.Header{ID: 53}, Questions: []dnsmessage.Question{{
+					Name: dnsmessage.MustNewName("0.1.sslip.io."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}}}))
+				Expect(response.Header.RCode).To(Equal(dnsmessage.RCodeNameError))
+			})
+		})
+		When("the class isn't IN", func() {
+			It("returns REFUSED", func() {
+				chaosQuestion := dnsmessage.Question{Name: dnsmessage.MustNewName("version.bind."), Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassCHAOS}
+				response, logMessage := respond(pack(dnsmessage.Message{Header: dnsmessage.Header{ID: 53}, Questions: []dnsmessage.Question{chaosQuestion}}))
+				Expect(response.Header.RCode).To(Equal(dnsmessage.RCodeRefused))
+				Expect(response.Questions).To(Equal([]dnsmessage.Question{chaosQuestion}))
+				Expect(logMessage).To(Equal("ClassCHAOS TypeTXT version.bind. ? Refused"))
+				Expect(x.Metrics().RefusedQueries).To(Equal(1))
+			})
+		})
+		When("a metrics query is waiting for the throttle", func() {
+			It("holds up neither the setters nor the other queries", func() {
+				x.DnsAmplificationAttackDelay = make(chan struct{}) // nobody sends on it, so the metrics query waits
+				x.SetCustomizations(xip.DefaultCustomizations())
+				metricsAnswered := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					_, _, err := x.QueryResponse(pack(dnsmessage.Message{Header: dnsmessage.Header{ID: 53}, Questions: []dnsmessage.Question{{
+						Name: dnsmessage.MustNewName("metrics.status.sslip.io."), Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET}}}), nil)
+					Expect(err).ToNot(HaveOccurred())
+					close(metricsAnswered)
+				}()
+				Consistently(metricsAnswered).ShouldNot(BeClosed())
+
+				zonesSet := make(chan struct{})
+				go func() {
+					x.SetZones([]string{"sslip.io."}, false)
+					close(zonesSet)
+				}()
+				Eventually(zonesSet).Should(BeClosed())
+				responseBytes, logMessage, err := x.QueryResponse(pack(dnsmessage.Message{Header: dnsmessage.Header{ID: 53}, Questions: []dnsmessage.Question{question}}), nil)
+				Expect(err).ToNot(HaveOccurred())
+				var response dnsmessage.Message
+				Expect(response.Unpack(responseBytes)).To(Succeed())
+				Expect(response.Answers).To(HaveLen(1))
+				Expect(logMessage).To(Equal("TypeA 127-0-0-1.sslip.io. ? 127.0.0.1"))
+
+				x.DnsAmplificationAttackDelay <- struct{}{}
+				Eventually(metricsAnswered).Should(BeClosed())
+			})
+		})
+		When("the name is outside the zones we're authoritative for", func() {
+			outOfZone := dnsmessage.Question{Name: dnsmessage.MustNewName("127-0-0-1.Example.COM."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+			BeforeEach(func() {
+				Expect(x.SetZones([]string{"sslip.io", "nip.io.", "", "not..valid"}, false)).To(Equal([]string{
+					`Adding zone "sslip.io."`,
+					`Adding zone "nip.io."`,
+					`-zones: ignoring invalid zone "not..valid": each label must be 1 to 63 characters long`,
+				}))
+			})
+			It("returns REFUSED & counts it", func() {
+				response, logMessage := respond(pack(dnsmessage.Message{Header: dnsmessage.Header{ID: 53}, Questions: []dnsmessage.Question{outOfZone}}))
+				Expect(response.Header.RCode).To(Equal(dnsmessage.RCodeRefused))
+				Expect(response.Questions).To(Equal([]dnsmessage.Question{outOfZone}))
+				Expect(logMessage).To(Equal("TypeA 127-0-0-1.Example.COM. ? Refused (out of zone)"))
+				Expect(x.Metrics().OutOfZoneQueries).To(Equal(1))
+				Expect(x.Metrics().Queries).To(Equal(1))
+			})
+			It("answers names in the zones, including the apexes, whatever their case", func() {
+				for _, name := range []string{"127-0-0-1.SSLIP.io.", "nip.io.", "ns.nip.io."} {
+					_, logMessage, err := x.QueryResponse(pack(dnsmessage.Message{Header: dnsmessage.Header{ID: 53}, Questions: []dnsmessage.Question{{
+						Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}}}), nil)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(logMessage).ToNot(ContainSubstring("out of zone"))
+				}
+				Expect(x.Metrics().OutOfZoneQueries).To(Equal(0))
+			})
+			It("doesn't mistake a name that merely ends with a zone for one in it", func() {
+				response, _ := respond(pack(dnsmessage.Message{Header: dnsmessage.Header{ID: 53}, Questions: []dnsmessage.Question{{
+					Name: dnsmessage.MustNewName("127-0-0-1.notsslip.io."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}}}))
+				Expect(response.Header.RCode).To(Equal(dnsmessage.RCodeRefused))
+			})
+			When("we're configured to answer them anyway (the legacy behavior)", func() {
+				It("answers & counts it", func() {
+					x.SetZones([]string{"sslip.io"}, true)
+					responseBytes, logMessage, err := x.QueryResponse(pack(dnsmessage.Message{Header: dnsmessage.Header{ID: 53}, Questions: []dnsmessage.Question{outOfZone}}), nil)
+					Expect(err).ToNot(HaveOccurred())
+					var response dnsmessage.Message
+					Expect(response.Unpack(responseBytes)).To(Succeed())
+					Expect(response.Header.RCode).To(Equal(dnsmessage.RCodeSuccess))
+					Expect(response.Answers).To(HaveLen(1))
+					Expect(logMessage).To(Equal("TypeA 127-0-0-1.Example.COM. ? 127.0.0.1 (out of zone)"))
+					Expect(x.Metrics().OutOfZoneQueries).To(Equal(1))
+					Expect(x.Metrics().Queries).To(Equal(1))
+					Expect(x.Metrics().AnsweredQueries).To(Equal(1))
+				})
+			})
+		})
+	})
+
+	Describe("RefusedResponse()", func() {
+		It("returns REFUSED with the query's ID and question, but no records", func() {
+			queryBytes, err := (&dnsmessage.Message{
+				Header: dnsmessage.Header{ID: 5, RecursionDesired: true},
+				Questions: []dnsmessage.Question{{
+					Name:  dnsmessage.MustNewName("127-0-0-1.sslip.io."),
+					Type:  dnsmessage.TypeA,
+					Class: dnsmessage.ClassINET,
+				}},
+			}).Pack()
+			Expect(err).ToNot(HaveOccurred())
+			responseBytes, logMessage, err := xip.RefusedResponse(queryBytes)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(logMessage).To(Equal("TypeA 127-0-0-1.sslip.io. ? Refused"))
+			var response dnsmessage.Message
+			Expect(response.Unpack(responseBytes)).To(Succeed())
+			Expect(response.Header.ID).To(Equal(uint16(5)))
+			Expect(response.Header.RCode).To(Equal(dnsmessage.RCodeRefused))
+			Expect(response.Header.RecursionDesired).To(BeTrue())
+			Expect(response.Questions).To(HaveLen(1))
+			Expect(response.Answers).To(BeEmpty())
+		})
+		It("returns an error if the query is garbage", func() {
+			_, _, err := xip.RefusedResponse([]byte{1, 2, 3})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
 	Describe("SOAResource()", func() {
-		It("returns the SOA resource for the domain in question", func() {
-			randomDomain := testhelper.Random8ByteString() + ".com."
-			randomDomainName := dnsmessage.MustNewName(randomDomain)
-			soa := xip.SOAResource(randomDomainName)
-			Expect(soa.NS.Data).To(Equal(randomDomainName.Data))
+		var x *xip.Xip
+		randomDomainName := dnsmessage.MustNewName(testhelper.Random8ByteString() + ".com.")
+		BeforeEach(func() {
+			x, _ = xip.NewXip("file:///", []string{"ns-primary.example.com.", "ns-secondary.example.com."}, []string{})
+		})
+		It("returns the default SOA with our first nameserver as the MNAME", func() {
+			soa := x.SOAResource(randomDomainName)
+			Expect(soa.NS.String()).To(Equal("ns-primary.example.com."))
+			Expect(soa.MBox.String()).To(Equal("briancunnie.gmail.com."))
+			Expect(soa.Serial).ToNot(BeZero())
+			Expect([]uint32{soa.Refresh, soa.Retry, soa.Expire, soa.MinTTL}).To(Equal([]uint32{900, 900, 1800, 180}))
+		})
+		When("we have no nameservers", func() {
+			It("uses the domain in question as the MNAME", func() {
+				var x xip.Xip
+				Expect(x.SOAResource(randomDomainName).NS.Data).To(Equal(randomDomainName.Data))
+			})
+		})
+		When("it's configured", func() {
+			It("returns the configured SOA", func() {
+				logmessage, err := x.SetSOA(xip.SOAConfig{MName: "ns.example.com", MBox: "hostmaster.example.com.", Serial: 2024010100, Refresh: 1, Retry: 2, Expire: 3, MinTTL: 4})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(logmessage).To(Equal("SOA: ns.example.com. hostmaster.example.com. 2024010100 1 2 3 4"))
+				soa := x.SOAResource(randomDomainName)
+				Expect(soa.NS.String()).To(Equal("ns.example.com."))
+				Expect(soa.MBox.String()).To(Equal("hostmaster.example.com."))
+				Expect([]uint32{soa.Serial, soa.Refresh, soa.Retry, soa.Expire, soa.MinTTL}).To(Equal([]uint32{2024010100, 1, 2, 3, 4}))
+			})
+			It("rejects an invalid MBOX", func() {
+				_, err := x.SetSOA(xip.SOAConfig{MBox: "not..valid"})
+				Expect(err).To(MatchError(ContainSubstring(`invalid SOA MBOX "not..valid"`)))
+			})
+		})
+		Describe("the automatic serial", func() {
+			It("is the same for the same configuration, and changes when the configuration does", func() {
+				serial := x.SOAResource(randomDomainName).Serial
+				twin, _ := xip.NewXip("file:///", []string{"ns-primary.example.com.", "ns-secondary.example.com."}, []string{})
+				Expect(twin.SOAResource(randomDomainName).Serial).To(Equal(serial))
+
+				x.Reload("file:///", []string{"ns-primary.example.com."}, []string{})
+				Expect(x.SOAResource(randomDomainName).Serial).ToNot(Equal(serial))
+			})
+		})
+	})
+
+	Describe("SetTenants()", func() {
+		var x *xip.Xip
+		query := func(name string, qType dnsmessage.Type) (response dnsmessage.Message) {
+			queryBytes, err := (&dnsmessage.Message{Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: qType, Class: dnsmessage.ClassINET}}}).Pack()
+			Expect(err).ToNot(HaveOccurred())
+			responseBytes, _, err := x.QueryResponse(queryBytes, net.ParseIP("127.0.0.1"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.Unpack(responseBytes)).To(Succeed())
+			return response
+		}
+		BeforeEach(func() {
+			x, _ = xip.NewXip("file:///", []string{"ns-aws.sslip.io."}, []string{})
+			Expect(x.SetTenants([]xip.TenantConfig{
+				{
+					Apex:        "xip.example.com",
+					NameServers: []string{"ns1.example.com", "ns2.example.com"},
+					MBox:        "hostmaster.example.com",
+					Addresses:   []string{"www.xip.example.com=10.0.0.1", "www.sslip.io=10.0.0.2"},
+				},
+				{
+					Apex:        "internal.xip.example.com.",
+					NameServers: []string{"ns.internal.example.com."},
+				},
+				{
+					Apex:      "10.in-addr.arpa",
+					PTRDomain: "xip.example.com",
+				},
+				{
+					Apex: "not..valid",
+				},
+			})).To(Equal([]string{
+				`tenant "xip.example.com.": Adding nameserver "ns1.example.com."`,
+				`tenant "xip.example.com.": Adding nameserver "ns2.example.com."`,
+				`tenant "xip.example.com.": Adding record "www.xip.example.com.=10.0.0.1"`,
+				`tenant "xip.example.com.": Adding record "www.sslip.io.=10.0.0.2"`,
+				`tenant "xip.example.com.": ignoring record "www.sslip.io.", which isn't within the tenant`,
+				`Adding tenant "xip.example.com."`,
+				`tenant "internal.xip.example.com.": Adding nameserver "ns.internal.example.com."`,
+				`Adding tenant "internal.xip.example.com."`,
+				`Adding tenant "10.in-addr.arpa."`,
+				`tenants: ignoring tenant "not..valid": each label must be 1 to 63 characters long`,
+			}))
+		})
+		It("answers a tenant's names with its own NS & SOA", func() {
+			response := query("127-0-0-1.XIP.example.com.", dnsmessage.TypeNS)
+			Expect(response.Answers).To(HaveLen(2))
+			Expect(response.Answers[0].Body.(*dnsmessage.NSResource).NS.String()).To(Equal("ns1.example.com."))
+			soa := x.SOAResource(dnsmessage.MustNewName("127-0-0-1.xip.example.com."))
+			Expect(soa.NS.String()).To(Equal("ns1.example.com."))
+			Expect(soa.MBox.String()).To(Equal("hostmaster.example.com."))
+		})
+		It("picks the tenant with the longest matching apex", func() {
+			response := query("127-0-0-1.internal.xip.example.com.", dnsmessage.TypeNS)
+			Expect(response.Answers).To(HaveLen(1))
+			Expect(response.Answers[0].Body.(*dnsmessage.NSResource).NS.String()).To(Equal("ns.internal.example.com."))
+			soa := x.SOAResource(dnsmessage.MustNewName("127-0-0-1.internal.xip.example.com."))
+			Expect(soa.NS.String()).To(Equal("ns.internal.example.com."))
+			Expect(soa.MBox.String()).To(Equal("briancunnie.gmail.com.")) // it has no contact of its own
+		})
+		It("answers the tenant's records, but only within the tenant", func() {
+			Expect(x.NameToA("www.xip.example.com.")).To(Equal([]dnsmessage.AResource{{A: [4]byte{10, 0, 0, 1}}}))
+			Expect(x.NameToA("www.sslip.io.")).To(BeEmpty())
+		})
+		It("answers the tenant's apex, which has records, with NODATA rather than NXDOMAIN", func() {
+			Expect(query("xip.example.com.", dnsmessage.TypeA).Header.RCode).To(Equal(dnsmessage.RCodeSuccess))
+		})
+		It("answers the empty non-terminals, whose descendants have records, with NODATA rather than NXDOMAIN", func() {
+			Expect(query("example.com.", dnsmessage.TypeA).Header.RCode).To(Equal(dnsmessage.RCodeSuccess))           // above the tenant's apex
+			Expect(query("_domainkey.sslip.io.", dnsmessage.TypeTXT).Header.RCode).To(Equal(dnsmessage.RCodeSuccess)) // above a customization
+			Expect(query("no-such.example.com.", dnsmessage.TypeA).Header.RCode).To(Equal(dnsmessage.RCodeNameError))
+		})
+		It("points the PTR records of a tenant's reverse zone into its PTR domain", func() {
+			response := query("1.0.0.10.in-addr.arpa.", dnsmessage.TypePTR)
+			Expect(response.Answers).To(HaveLen(1))
+			Expect(response.Answers[0].Body.(*dnsmessage.PTRResource).PTR.String()).To(Equal("10-0-0-1.xip.example.com."))
+			response = query("1.0.0.127.in-addr.arpa.", dnsmessage.TypePTR)
+			Expect(response.Answers[0].Body.(*dnsmessage.PTRResource).PTR.String()).To(Equal("127-0-0-1.sslip.io."))
+		})
+		It("leaves sslip.io's names alone", func() {
+			response := query("127-0-0-1.sslip.io.", dnsmessage.TypeNS)
+			Expect(response.Answers).To(HaveLen(1))
+			Expect(response.Answers[0].Body.(*dnsmessage.NSResource).NS.String()).To(Equal("ns-aws.sslip.io."))
+			Expect(x.SOAResource(dnsmessage.MustNewName("127-0-0-1.sslip.io.")).MBox.String()).To(Equal("briancunnie.gmail.com."))
+		})
+		It("counts the tenants as zones we're authoritative for", func() {
+			x.SetZones([]string{"sslip.io"}, false)
+			Expect(query("127-0-0-1.xip.example.com.", dnsmessage.TypeA).Header.RCode).To(Equal(dnsmessage.RCodeSuccess))
+			Expect(query("127-0-0-1.example.com.", dnsmessage.TypeA).Header.RCode).To(Equal(dnsmessage.RCodeRefused))
+		})
+		It("keeps the tenants' records when we reload", func() {
+			x.Reload("file:///", []string{"ns-aws.sslip.io."}, []string{})
+			Expect(x.NameToA("www.xip.example.com.")).To(HaveLen(1))
+		})
+		It("validates a tenant, returning every nameserver & record it would ignore", func() {
+			Expect(xip.TenantConfig{Apex: "xip.example.com", Addresses: []string{"www.xip.example.com=10.0.0.1"}}.Validate()).To(Succeed())
+			Expect(xip.TenantConfig{Apex: "not..valid"}.Validate()).To(MatchError(HavePrefix(`tenant "not..valid": `)))
+
+			err := xip.TenantConfig{
+				Apex:        "xip.example.com",
+				NameServers: []string{""},
+				Addresses:   []string{"www.xip.example.com=not-an-ip", "www.example.org=10.0.0.1"},
+			}.Validate()
+			Expect(err).To(HaveOccurred())
+			errs := err.(interface{ Unwrap() []error }).Unwrap()
+			Expect(errs).To(HaveLen(3))
+			Expect(errs[0]).To(MatchError(`tenant "xip.example.com.": -nameservers: ignoring zero-length nameserver ""`))
+			Expect(errs[1]).To(MatchError(ContainSubstring(`tenant "xip.example.com.": -addresses: "[www.xip.example.com not-an-ip]" is not assigned a valid IP`)))
+			Expect(errs[2]).To(MatchError(`tenant "xip.example.com.": ignoring record "www.example.org.", which isn't within the tenant`))
+		})
+		It("replaces the tenants, the zones, & the rest of the configuration together with Reconfigure()", func() {
+			x.SetZones([]string{"sslip.io"}, false)
+			logmessages, err := x.Reconfigure(xip.Configuration{
+				NameServers: []string{"ns.example.org."},
+				Addresses:   []string{"ns.example.org=10.0.0.53"},
+				Tenants:     []xip.TenantConfig{{Apex: "xip.example.net", Addresses: []string{"www.xip.example.net=10.0.0.9"}}},
+				Zones:       []string{"sslip.io", "example.org"},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(logmessages).To(Equal([]string{
+				`tenant "xip.example.net.": Adding record "www.xip.example.net.=10.0.0.9"`,
+				`Adding tenant "xip.example.net."`,
+				`Adding zone "sslip.io."`,
+				`Adding zone "example.org."`,
+				`Adding nameserver "ns.example.org."`,
+				`Adding record "ns.example.org.=10.0.0.53"`,
+			}))
+			Expect(query("www.xip.example.net.", dnsmessage.TypeA).Answers).To(HaveLen(1))
+			Expect(query("127-0-0-1.example.org.", dnsmessage.TypeA).Answers).To(HaveLen(1))
+			Expect(query("www.xip.example.com.", dnsmessage.TypeA).Header.RCode).To(Equal(dnsmessage.RCodeRefused))
+			Expect(x.NameServers()).To(HaveLen(1))
+		})
+		It("keeps the whole previous configuration if Reconfigure() can't read the zone file", func() {
+			_, err := x.Reconfigure(xip.Configuration{
+				NameServers: []string{"ns.example.org."},
+				ZoneFile:    "/non-existent.zone",
+				Zones:       []string{"example.org"},
+			})
+			Expect(err).To(MatchError(ContainSubstring("/non-existent.zone")))
+			Expect(x.NameServers()[0].NS.String()).To(Equal("ns-aws.sslip.io."))
+			Expect(query("www.xip.example.com.", dnsmessage.TypeA).Answers).To(HaveLen(1))
+			Expect(query("127-0-0-1.sslip.io.", dnsmessage.TypeA).Answers).To(HaveLen(1))
+		})
+	})
+
+	Describe("TTLs", func() {
+		var x *xip.Xip
+		customizedDomain := strings.ToLower(testhelper.Random8ByteString()) + ".com."
+		answerTTLs := func(name string, qType dnsmessage.Type) (ttls []uint32) {
+			queryBytes, err := (&dnsmessage.Message{Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: qType, Class: dnsmessage.ClassINET}}}).Pack()
+			Expect(err).ToNot(HaveOccurred())
+			responseBytes, _, err := x.QueryResponse(queryBytes, net.ParseIP("127.0.0.1"))
+			Expect(err).ToNot(HaveOccurred())
+			var response dnsmessage.Message
+			Expect(response.Unpack(responseBytes)).To(Succeed())
+			for _, resource := range append(response.Answers, response.Authorities...) {
+				ttls = append(ttls, resource.Header.TTL)
+			}
+			return ttls
+		}
+		BeforeEach(func() {
+			x, _ = xip.NewXip("file:///", []string{"ns.example.com."}, []string{customizedDomain + "=10.0.0.1"})
+		})
+		It("uses the default TTLs", func() {
+			Expect(answerTTLs("10-0-0-1.sslip.io.", dnsmessage.TypeA)).To(Equal([]uint32{3600}))
+			Expect(answerTTLs(customizedDomain, dnsmessage.TypeA)).To(Equal([]uint32{3600}))
+			Expect(answerTTLs("ip.sslip.io.", dnsmessage.TypeTXT)).To(Equal([]uint32{180}))
+			Expect(answerTTLs("10-0-0-1.sslip.io.", dnsmessage.TypeNS)).To(Equal([]uint32{604800}))
+			Expect(answerTTLs("10-0-0-1.sslip.io.", dnsmessage.TypeSOA)).To(Equal([]uint32{604800}))
+			Expect(answerTTLs("non-existent.sslip.io.", dnsmessage.TypeA)).To(Equal([]uint32{180}))
+		})
+		When("the TTLs are set", func() {
+			BeforeEach(func() {
+				x.SetTTLs(xip.TTLs{EmbeddedIP: 1, Customization: 2, TXT: 3, NS: 4, SOA: 5, Negative: 6, Blocked: 7})
+			})
+			It("uses them", func() {
+				Expect(answerTTLs("10-0-0-1.sslip.io.", dnsmessage.TypeA)).To(Equal([]uint32{1}))
+				Expect(answerTTLs("10-0-0-1.sslip.io.", dnsmessage.TypeMX)).To(Equal([]uint32{1}))
+				Expect(answerTTLs("1.0.0.10.in-addr.arpa.", dnsmessage.TypePTR)).To(Equal([]uint32{1}))
+				Expect(answerTTLs(customizedDomain, dnsmessage.TypeA)).To(Equal([]uint32{2}))
+				Expect(answerTTLs("sslip.io.", dnsmessage.TypeMX)).To(Equal([]uint32{2, 2}))
+				Expect(answerTTLs("ip.sslip.io.", dnsmessage.TypeTXT)).To(Equal([]uint32{3}))
+				Expect(answerTTLs("10-0-0-1.sslip.io.", dnsmessage.TypeNS)).To(Equal([]uint32{4}))
+				Expect(answerTTLs("10-0-0-1.sslip.io.", dnsmessage.TypeSOA)).To(Equal([]uint32{5}))
+				Expect(answerTTLs("non-existent.sslip.io.", dnsmessage.TypeA)).To(Equal([]uint32{6}))
+			})
+			It("caps the negative TTL at the SOA's MINIMUM", func() {
+				x.SetTTLs(xip.TTLs{Negative: 86400})
+				Expect(answerTTLs("non-existent.sslip.io.", dnsmessage.TypeA)).To(Equal([]uint32{180}))
+			})
+		})
+		When("a customization has its own TTL", func() {
+			It("overrides the TTLs of its records", func() {
+				customize(x, customizedDomain, xip.DomainCustomization{TTL: 42}) // the -addresses add the A record
+				Expect(answerTTLs(customizedDomain, dnsmessage.TypeA)).To(Equal([]uint32{42}))
+				Expect(answerTTLs(customizedDomain, dnsmessage.TypeMX)).To(Equal([]uint32{3600})) // the MX pointing to the name isn't customized
+			})
 		})
 	})
 
 	Describe("TXTResources()", func() {
-		var x xip.Xip
 		It("returns an empty array for a random domain", func() {
 			randomDomain := testhelper.Random8ByteString() + ".com."
 			txts, err := x.TXTResources(randomDomain, nil)
@@ -152,14 +807,13 @@ var _ = Describe("Xip", func() {
 			})
 		})
 		When("a random domain has been customized w/out any TXT defaults", func() { // Unnecessary, but confirms Golang's behavior for me, a doubting Thomas
-			customizedDomain := testhelper.Random8ByteString() + ".com."
-			xip.Customizations[customizedDomain] = xip.DomainCustomization{}
 			It("returns no TXT resources", func() {
+				customizedDomain := testhelper.Random8ByteString() + ".com."
+				customize(x, customizedDomain, xip.DomainCustomization{})
 				txts, err := x.TXTResources(customizedDomain, nil)
 				Expect(err).To(Not(HaveOccurred()))
 				Expect(len(txts)).To(Equal(0))
 			})
-			delete(xip.Customizations, customizedDomain) // clean-up
 		})
 		When(`the domain "ip.sslip.io" is queried`, func() {
 			It("returns the IP address of the querier", func() {
@@ -179,12 +833,14 @@ var _ = Describe("Xip", func() {
 	})
 
 	Describe("NameToA()", func() {
-		xip.Customizations["custom.record."] = xip.DomainCustomization{A: []dnsmessage.AResource{
-			{A: [4]byte{78, 46, 204, 247}},
-		}}
+		BeforeEach(func() {
+			customize(x, "custom.record.", xip.DomainCustomization{A: []dnsmessage.AResource{
+				{A: [4]byte{78, 46, 204, 247}},
+			}})
+		})
 		DescribeTable("when it succeeds",
 			func(fqdn string, expectedA dnsmessage.AResource) {
-				ipv4Answers := xip.NameToA(fqdn)
+				ipv4Answers := x.NameToA(fqdn)
 				Expect(len(ipv4Answers)).To(Equal(1))
 				Expect(ipv4Answers[0]).To(Equal(expectedA))
 			},
@@ -203,7 +859,7 @@ var _ = Describe("Xip", func() {
 		)
 		DescribeTable("when it does NOT match an IP address",
 			func(fqdn string) {
-				ipv4Answers := xip.NameToA(fqdn)
+				ipv4Answers := x.NameToA(fqdn)
 				Expect(len(ipv4Answers)).To(Equal(0))
 			},
 			Entry("empty string", ""),
@@ -219,23 +875,22 @@ var _ = Describe("Xip", func() {
 		When("There is more than one A record", func() {
 			It("returns them all", func() {
 				fqdn := testhelper.Random8ByteString()
-				xip.Customizations[strings.ToLower(fqdn)] = xip.DomainCustomization{
+				customize(x, fqdn, xip.DomainCustomization{
 					A: []dnsmessage.AResource{
 						{A: [4]byte{1}},
 						{A: [4]byte{2}},
 					},
-				}
-				ipv4Answers := xip.NameToA(fqdn)
+				})
+				ipv4Answers := x.NameToA(fqdn)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(len(ipv4Answers)).To(Equal(2))
 				Expect(ipv4Answers[0].A).To(Equal([4]byte{1}))
 				Expect(ipv4Answers[1].A).To(Equal([4]byte{2}))
-				delete(xip.Customizations, fqdn)
 			})
 		})
 		When("There are multiple matches", func() {
 			It("returns the leftmost one", func() {
-				ipv4Answers := xip.NameToA("nono.io.127.0.0.1.192.168.0.1.sslip.io")
+				ipv4Answers := x.NameToA("nono.io.127.0.0.1.192.168.0.1.sslip.io")
 				Expect(len(ipv4Answers)).To(Equal(1))
 				Expect(ipv4Answers[0]).
 					To(Equal(dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}}))
@@ -243,7 +898,7 @@ var _ = Describe("Xip", func() {
 		})
 		When("There are matches with dashes and dots", func() {
 			It("returns the one with dashes", func() {
-				ipv4Answers := xip.NameToA("nono.io.127.0.0.1.192-168-0-1.sslip.io")
+				ipv4Answers := x.NameToA("nono.io.127.0.0.1.192-168-0-1.sslip.io")
 				Expect(len(ipv4Answers)).To(Equal(1))
 				Expect(ipv4Answers[0]).
 					To(Equal(dnsmessage.AResource{A: [4]byte{192, 168, 0, 1}}))
@@ -255,33 +910,33 @@ var _ = Describe("Xip", func() {
 		When("the domain doesn't have '_acme-challenge.' in it", func() {
 			It("returns false", func() {
 				randomDomain := testhelper.Random8ByteString() + ".com."
-				Expect(xip.IsAcmeChallenge(randomDomain)).To(BeFalse())
+				Expect(x.IsAcmeChallenge(randomDomain)).To(BeFalse())
 			})
 			It("returns false even when there are embedded IPs", func() {
 				randomDomain := "127.0.0.1." + testhelper.Random8ByteString() + ".com."
-				Expect(xip.IsAcmeChallenge(randomDomain)).To(BeFalse())
+				Expect(x.IsAcmeChallenge(randomDomain)).To(BeFalse())
 			})
 		})
 		When("it has '_acme-challenge.' in it", func() {
 			When("it does NOT have any embedded IPs", func() {
 				It("returns false", func() {
 					randomDomain := "_acme-challenge." + testhelper.Random8ByteString() + ".com."
-					Expect(xip.IsAcmeChallenge(randomDomain)).To(BeFalse())
+					Expect(x.IsAcmeChallenge(randomDomain)).To(BeFalse())
 				})
 			})
 			When("it has embedded IPs", func() {
 				It("returns true", func() {
 					randomDomain := "_acme-challenge.127.0.0.1." + testhelper.Random8ByteString() + ".com."
-					Expect(xip.IsAcmeChallenge(randomDomain)).To(BeTrue())
+					Expect(x.IsAcmeChallenge(randomDomain)).To(BeTrue())
 					randomDomain = "_acme-challenge.fe80--1." + testhelper.Random8ByteString() + ".com."
-					Expect(xip.IsAcmeChallenge(randomDomain)).To(BeTrue())
+					Expect(x.IsAcmeChallenge(randomDomain)).To(BeTrue())
 				})
 				When("it has random capitalization", func() {
 					It("returns true", func() {
 						randomDomain := "_AcMe-ChAlLeNgE.127.0.0.1." + testhelper.Random8ByteString() + ".com."
-						Expect(xip.IsAcmeChallenge(randomDomain)).To(BeTrue())
+						Expect(x.IsAcmeChallenge(randomDomain)).To(BeTrue())
 						randomDomain = "_aCMe-cHAllENge.fe80--1." + testhelper.Random8ByteString() + ".com."
-						Expect(xip.IsAcmeChallenge(randomDomain)).To(BeTrue())
+						Expect(x.IsAcmeChallenge(randomDomain)).To(BeTrue())
 					})
 				})
 			})
@@ -291,7 +946,7 @@ var _ = Describe("Xip", func() {
 	Describe("NameToAAAA()", func() {
 		DescribeTable("when it succeeds",
 			func(fqdn string, expectedAAAA dnsmessage.AAAAResource) {
-				ipv6Answers := xip.NameToAAAA(fqdn)
+				ipv6Answers := x.NameToAAAA(fqdn)
 				Expect(len(ipv6Answers)).To(Equal(1))
 				Expect(ipv6Answers[0]).To(Equal(expectedAAAA))
 			},
@@ -306,7 +961,7 @@ var _ = Describe("Xip", func() {
 		)
 		DescribeTable("when it does not match an IP address",
 			func(fqdn string) {
-				ipv6Answers := xip.NameToAAAA(fqdn)
+				ipv6Answers := x.NameToAAAA(fqdn)
 				Expect(len(ipv6Answers)).To(Equal(0))
 			},
 			Entry("empty string", ""),
@@ -320,7 +975,7 @@ var _ = Describe("Xip", func() {
 			It("should succeed every time", func() {
 				for i := 0; i < 10000; i++ {
 					addr := testhelper.RandomIPv6Address()
-					ipv6Answers := xip.NameToAAAA(strings.ReplaceAll(addr.String(), ":", "-"))
+					ipv6Answers := x.NameToAAAA(strings.ReplaceAll(addr.String(), ":", "-"))
 					Expect(err).ToNot(HaveOccurred())
 					Expect(ipv6Answers[0].AAAA[:]).To(Equal([]uint8(addr)))
 				}
@@ -329,17 +984,16 @@ var _ = Describe("Xip", func() {
 		When("There is more than one AAAA record", func() {
 			It("returns them all", func() {
 				fqdn := testhelper.Random8ByteString()
-				xip.Customizations[strings.ToLower(fqdn)] = xip.DomainCustomization{
+				customize(x, fqdn, xip.DomainCustomization{
 					AAAA: []dnsmessage.AAAAResource{
 						{AAAA: [16]byte{1}},
 						{AAAA: [16]byte{2}},
 					},
-				}
-				ipv6Addrs := xip.NameToAAAA(fqdn)
+				})
+				ipv6Addrs := x.NameToAAAA(fqdn)
 				Expect(len(ipv6Addrs)).To(Equal(2))
 				Expect(ipv6Addrs[0].AAAA).To(Equal([16]byte{1}))
 				Expect(ipv6Addrs[1].AAAA).To(Equal([16]byte{2}))
-				delete(xip.Customizations, fqdn)
 			})
 		})
 	})
@@ -391,3 +1045,10 @@ var _ = Describe("Xip", func() {
 		})
 	})
 })
+
+// customize adds the records of name to x's built-in records
+func customize(x *xip.Xip, name string, customization xip.DomainCustomization) {
+	customizations := xip.DefaultCustomizations()
+	customizations[strings.ToLower(name)] = customization
+	x.SetCustomizations(customizations)
+}
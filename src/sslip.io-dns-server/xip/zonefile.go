@@ -0,0 +1,423 @@
+package xip
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// CAAResource is a CAA record (RFC 8659), which dnsmessage doesn't support,
+// e.g. `0 issue "letsencrypt.org"`
+type CAAResource struct {
+	Flags uint8
+	Tag   string // e.g. "issue", "issuewild", "iodef"
+	Value string // e.g. "letsencrypt.org"
+}
+
+// data is the CAA record's RDATA
+func (caa CAAResource) data() []byte {
+	data := []byte{caa.Flags, byte(len(caa.Tag))}
+	data = append(data, caa.Tag...)
+	return append(data, caa.Value...)
+}
+
+// zoneToken is a field of a zone file's record; a quoted field may contain
+// whitespace and isn't special, e.g. "@"
+type zoneToken struct {
+	text   string
+	quoted bool
+}
+
+// zoneRecord is a record (or a directive, e.g. $ORIGIN) of a zone file, which
+// may span several lines when it's parenthesized
+type zoneRecord struct {
+	line          int  // the line on which the record starts
+	inheritsOwner bool // the record starts with whitespace, so its owner is the previous record's
+	tokens        []zoneToken
+}
+
+// zoneEntry accumulates the records of one name
+type zoneEntry struct {
+	customization DomainCustomization
+	txts          []dnsmessage.TXTResource
+	line          int // the line of the name's first record, for error messages
+}
+
+// ParseZoneFile parses an RFC 1035 master file (a "zone file") into
+// customizations. It understands $ORIGIN, $TTL, parentheses, comments, and
+// A, AAAA, CNAME, MX, TXT, NS, SRV, and CAA records; it ignores SOA records
+// (see SetSOA()). Relative names need an $ORIGIN. A name's records share one
+// TTL (DomainCustomization.TTL), the least of them; 0 (no TTL nor $TTL) means
+// our default TTLs. Errors include the line number.
+func ParseZoneFile(zoneFile io.Reader) (customizations DomainCustomizations, err error) {
+	records, err := readZoneRecords(zoneFile)
+	if err != nil {
+		return nil, err
+	}
+	var origin, owner string
+	var defaultTTL, previousTTL uint32
+	var hasDefaultTTL bool
+	entries := map[string]*zoneEntry{}
+	var names []string // to build the customizations in the zone file's order
+	for _, record := range records {
+		tokens := record.tokens
+		if !record.inheritsOwner && !tokens[0].quoted && strings.HasPrefix(tokens[0].text, "$") {
+			switch directive := strings.ToUpper(tokens[0].text); directive {
+			case "$ORIGIN":
+				if len(tokens) != 2 {
+					return nil, fmt.Errorf("line %d: $ORIGIN needs exactly one name", record.line)
+				}
+				if origin, err = zoneName(tokens[1].text, origin); err != nil {
+					return nil, fmt.Errorf("line %d: %s", record.line, err.Error())
+				}
+			case "$TTL":
+				if len(tokens) != 2 {
+					return nil, fmt.Errorf("line %d: $TTL needs exactly one TTL", record.line)
+				}
+				if defaultTTL, err = parseZoneTTL(tokens[1].text); err != nil {
+					return nil, fmt.Errorf("line %d: %s", record.line, err.Error())
+				}
+				hasDefaultTTL = true
+			default:
+				return nil, fmt.Errorf(`line %d: unsupported directive "%s"`, record.line, tokens[0].text)
+			}
+			continue
+		}
+		if !record.inheritsOwner {
+			if owner, err = zoneName(tokens[0].text, origin); err != nil {
+				return nil, fmt.Errorf("line %d: %s", record.line, err.Error())
+			}
+			tokens = tokens[1:]
+		} else if owner == "" {
+			return nil, fmt.Errorf("line %d: the record has no owner name, and there's no previous one", record.line)
+		}
+		// the TTL and the class are optional, and may come in either order
+		ttl, hasTTL := previousTTL, false
+		if hasDefaultTTL {
+			ttl = defaultTTL
+		}
+		for i := 0; i < 2 && len(tokens) > 0; i++ {
+			if recordTTL, err := parseZoneTTL(tokens[0].text); err == nil && !hasTTL {
+				ttl, hasTTL = recordTTL, true
+				tokens = tokens[1:]
+				continue
+			}
+			class := strings.ToUpper(tokens[0].text)
+			if class == "CH" || class == "CS" || class == "HS" {
+				return nil, fmt.Errorf(`line %d: unsupported class "%s"; only IN is supported`, record.line, tokens[0].text)
+			}
+			if class != "IN" {
+				break
+			}
+			tokens = tokens[1:]
+		}
+		if len(tokens) == 0 {
+			return nil, fmt.Errorf("line %d: the record has no type", record.line)
+		}
+		previousTTL = ttl
+		recordType, rdata := strings.ToUpper(tokens[0].text), tokens[1:]
+		if recordType == "SOA" {
+			continue // our SOA comes from SetSOA()
+		}
+		entry, ok := entries[strings.ToLower(owner)]
+		if !ok {
+			entry = &zoneEntry{line: record.line}
+			entries[strings.ToLower(owner)] = entry
+			names = append(names, strings.ToLower(owner))
+		}
+		if err = entry.add(recordType, rdata, origin); err != nil {
+			return nil, fmt.Errorf("line %d: %s %s: %s", record.line, owner, recordType, err.Error())
+		}
+		if ttl != 0 && (entry.customization.TTL == 0 || ttl < entry.customization.TTL) {
+			entry.customization.TTL = ttl
+		}
+	}
+	customizations = DomainCustomizations{}
+	for _, name := range names {
+		entry := entries[name]
+		if entry.customization.CNAME != (dnsmessage.CNAMEResource{}) &&
+			(len(entry.customization.A) > 0 || len(entry.customization.AAAA) > 0 || len(entry.customization.MX) > 0 ||
+				len(entry.customization.NS) > 0 || len(entry.customization.SRV) > 0 || len(entry.customization.CAA) > 0 || len(entry.txts) > 0) {
+			return nil, fmt.Errorf("line %d: %s has a CNAME, so it can't have other records", entry.line, name)
+		}
+		if len(entry.txts) > 0 {
+			txts := entry.txts
+			entry.customization.TXT = func(_ *Xip, _ net.IP) ([]dnsmessage.TXTResource, error) {
+				return txts, nil
+			}
+		}
+		customizations[name] = entry.customization
+	}
+	return customizations, nil
+}
+
+// add parses a record's RDATA and adds it to the entry
+func (entry *zoneEntry) add(recordType string, rdata []zoneToken, origin string) (err error) {
+	c := &entry.customization
+	wantFields := map[string]int{"A": 1, "AAAA": 1, "CNAME": 1, "MX": 2, "NS": 1, "SRV": 4, "CAA": 3}
+	if want, ok := wantFields[recordType]; ok && len(rdata) != want {
+		return fmt.Errorf("needs %d fields, not %d", want, len(rdata))
+	}
+	switch recordType {
+	case "A":
+		ip := net.ParseIP(rdata[0].text).To4()
+		if ip == nil {
+			return fmt.Errorf(`invalid IPv4 address "%s"`, rdata[0].text)
+		}
+		c.A = append(c.A, dnsmessage.AResource{A: [4]byte{ip[0], ip[1], ip[2], ip[3]}})
+	case "AAAA":
+		ip := net.ParseIP(rdata[0].text)
+		if ip == nil || !strings.Contains(rdata[0].text, ":") {
+			return fmt.Errorf(`invalid IPv6 address "%s"`, rdata[0].text)
+		}
+		var aaaa dnsmessage.AAAAResource
+		copy(aaaa.AAAA[:], ip.To16())
+		c.AAAA = append(c.AAAA, aaaa)
+	case "CNAME":
+		if c.CNAME != (dnsmessage.CNAMEResource{}) {
+			return errors.New("a name can't have more than one CNAME")
+		}
+		if c.CNAME.CNAME, err = zoneNameResource(rdata[0].text, origin); err != nil {
+			return err
+		}
+	case "MX":
+		var mx dnsmessage.MXResource
+		if mx.Pref, err = parseZoneUint16(rdata[0].text, "preference"); err != nil {
+			return err
+		}
+		if mx.MX, err = zoneNameResource(rdata[1].text, origin); err != nil {
+			return err
+		}
+		c.MX = append(c.MX, mx)
+	case "NS":
+		var ns dnsmessage.NSResource
+		if ns.NS, err = zoneNameResource(rdata[0].text, origin); err != nil {
+			return err
+		}
+		c.NS = append(c.NS, ns)
+	case "SRV":
+		var srv dnsmessage.SRVResource
+		if srv.Priority, err = parseZoneUint16(rdata[0].text, "priority"); err != nil {
+			return err
+		}
+		if srv.Weight, err = parseZoneUint16(rdata[1].text, "weight"); err != nil {
+			return err
+		}
+		if srv.Port, err = parseZoneUint16(rdata[2].text, "port"); err != nil {
+			return err
+		}
+		if srv.Target, err = zoneNameResource(rdata[3].text, origin); err != nil {
+			return err
+		}
+		c.SRV = append(c.SRV, srv)
+	case "CAA":
+		flags, err := strconv.ParseUint(rdata[0].text, 10, 8)
+		if err != nil {
+			return fmt.Errorf(`invalid flags "%s"`, rdata[0].text)
+		}
+		tag := rdata[1].text
+		if len(tag) == 0 || len(tag) > 15 || strings.IndexFunc(tag, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+		}) != -1 {
+			return fmt.Errorf(`invalid tag "%s"; it must be 1 to 15 letters & digits`, tag)
+		}
+		c.CAA = append(c.CAA, CAAResource{Flags: uint8(flags), Tag: tag, Value: rdata[2].text})
+	case "TXT":
+		if len(rdata) == 0 {
+			return errors.New("needs at least one string")
+		}
+		var txt dnsmessage.TXTResource
+		for _, token := range rdata {
+			if len(token.text) > 255 {
+				return fmt.Errorf("a string can't be longer than 255 bytes, not %d", len(token.text))
+			}
+			txt.TXT = append(txt.TXT, token.text)
+		}
+		entry.txts = append(entry.txts, txt)
+	default:
+		return errors.New("unsupported record type")
+	}
+	return nil
+}
+
+// readZoneRecords splits a zone file into records, joining the lines of
+// parenthesized records and dropping the comments & blank lines
+func readZoneRecords(zoneFile io.Reader) (records []zoneRecord, err error) {
+	scanner := bufio.NewScanner(zoneFile)
+	var record zoneRecord
+	parentheses := 0
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if parentheses == 0 {
+			record = zoneRecord{line: lineNumber, inheritsOwner: len(line) > 0 && (line[0] == ' ' || line[0] == '\t')}
+		}
+		for i := 0; i < len(line); {
+			switch c := line[i]; {
+			case c == ' ' || c == '\t' || c == '\r':
+				i++
+			case c == ';':
+				i = len(line) // the rest of the line is a comment
+			case c == '(':
+				parentheses++
+				i++
+			case c == ')':
+				if parentheses == 0 {
+					return nil, fmt.Errorf(`line %d: ")" without "("`, lineNumber)
+				}
+				parentheses--
+				i++
+			case c == '"':
+				text, length, err := unescapeZoneText(line[i+1:], true)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %s", lineNumber, err.Error())
+				}
+				record.tokens = append(record.tokens, zoneToken{text: text, quoted: true})
+				i += 1 + length
+			default:
+				text, length, err := unescapeZoneText(line[i:], false)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %s", lineNumber, err.Error())
+				}
+				record.tokens = append(record.tokens, zoneToken{text: text})
+				i += length
+			}
+		}
+		if parentheses == 0 && len(record.tokens) > 0 {
+			records = append(records, record)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if parentheses != 0 {
+		return nil, fmt.Errorf(`line %d: "(" without ")"`, record.line)
+	}
+	return records, nil
+}
+
+// unescapeZoneText reads a field up to its end (the closing quote if it's
+// quoted, otherwise whitespace or a special character), decoding "\X" and
+// "\DDD" escapes. It returns the field and how many bytes of s it consumed.
+func unescapeZoneText(s string, quoted bool) (text string, length int, err error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quoted && c == '"':
+			return b.String(), i + 1, nil
+		case !quoted && strings.IndexByte(" \t\r;()\"", c) != -1:
+			return b.String(), i, nil
+		case c == '\\':
+			if i+3 < len(s) && isDigits(s[i+1:i+4]) {
+				value, _ := strconv.Atoi(s[i+1 : i+4])
+				if value > 255 {
+					return "", 0, fmt.Errorf(`invalid escape "\%s"`, s[i+1:i+4])
+				}
+				b.WriteByte(byte(value))
+				i += 3
+			} else if i+1 < len(s) {
+				b.WriteByte(s[i+1])
+				i++
+			} else {
+				return "", 0, errors.New(`"\" at the end of the line`)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	if quoted {
+		return "", 0, errors.New("unterminated quoted string")
+	}
+	return b.String(), len(s), nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// zoneName makes a zone file's name absolute: "@" is the origin, and a name
+// that doesn't end in "." is relative to the origin
+func zoneName(name string, origin string) (string, error) {
+	switch {
+	case name == "@":
+		if origin == "" {
+			return "", errors.New(`"@" needs an $ORIGIN`)
+		}
+		name = origin
+	case !strings.HasSuffix(name, "."):
+		if origin == "" {
+			return "", fmt.Errorf(`relative name "%s" needs an $ORIGIN`, name)
+		}
+		name = strings.TrimSuffix(name+"."+origin, ".") + "."
+	}
+	if _, err := absoluteName(name); err != nil {
+		return "", fmt.Errorf(`invalid name "%s": %s`, name, err.Error())
+	}
+	return name, nil
+}
+
+// zoneNameResource is zoneName as a dnsmessage.Name, e.g. an MX record's exchange
+func zoneNameResource(name string, origin string) (dnsmessage.Name, error) {
+	absolute, err := zoneName(name, origin)
+	if err != nil {
+		return dnsmessage.Name{}, err
+	}
+	return dnsmessage.NewName(absolute)
+}
+
+func parseZoneUint16(s string, field string) (uint16, error) {
+	value, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf(`invalid %s "%s"`, field, s)
+	}
+	return uint16(value), nil
+}
+
+// parseZoneTTL parses a TTL in seconds, e.g. "3600", or with BIND's units, e.g. "1h" or "1w2d"
+func parseZoneTTL(s string) (uint32, error) {
+	if s == "" {
+		return 0, errors.New("empty TTL")
+	}
+	if isDigits(s) {
+		ttl, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf(`invalid TTL "%s"`, s)
+		}
+		return uint32(ttl), nil
+	}
+	units := map[byte]uint64{'s': 1, 'm': 60, 'h': 60 * 60, 'd': 60 * 60 * 24, 'w': 60 * 60 * 24 * 7}
+	var ttl, number uint64
+	hasNumber := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= '0' && c <= '9' {
+			number = number*10 + uint64(c-'0')
+			hasNumber = true
+			if number > 1<<32 {
+				return 0, fmt.Errorf(`invalid TTL "%s"`, s)
+			}
+			continue
+		}
+		unit, ok := units[c|0x20] // lower-case
+		if !ok || !hasNumber {
+			return 0, fmt.Errorf(`invalid TTL "%s"`, s)
+		}
+		ttl += number * unit
+		number, hasNumber = 0, false
+	}
+	if hasNumber || ttl > 1<<32-1 {
+		return 0, fmt.Errorf(`invalid TTL "%s"`, s)
+	}
+	return uint32(ttl), nil
+}
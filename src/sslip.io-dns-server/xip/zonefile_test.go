@@ -0,0 +1,173 @@
+package xip_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"xip/xip"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var _ = Describe("ParseZoneFile()", func() {
+	parse := func(zoneFile string) (xip.DomainCustomizations, error) {
+		return xip.ParseZoneFile(strings.NewReader(zoneFile))
+	}
+
+	It("parses the records into customizations", func() {
+		customizations, err := parse(`
+$ORIGIN example.com.
+$TTL 1h ; the default TTL
+@	IN	SOA	ns1 hostmaster (
+		2024010100 ; serial
+		900 900 1800 180 )
+	IN	NS	ns1
+	IN	NS	ns2.example.net.
+	IN	MX	10 mail
+	IN	MX	20 mail.example.net.
+	IN	TXT	"v=spf1 mx ~all"
+	IN	TXT	( "two " "strings" )
+	IN	CAA	0 issue "letsencrypt.org"
+www	300	IN	A	10.0.0.1
+www	IN	300	A	10.0.0.2
+WWW		AAAA	2001:db8::1
+alias		CNAME	www
+_sip._tcp	SRV	10 60 5060 sip.example.com.
+quoted	TXT	"a \"quoted\" \059 semicolon"
+`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(customizations).To(HaveLen(5))
+
+		apex := customizations["example.com."]
+		Expect(apex.TTL).To(Equal(uint32(3600)))
+		Expect(apex.NS).To(Equal([]dnsmessage.NSResource{
+			{NS: dnsmessage.MustNewName("ns1.example.com.")},
+			{NS: dnsmessage.MustNewName("ns2.example.net.")},
+		}))
+		Expect(apex.MX).To(Equal([]dnsmessage.MXResource{
+			{Pref: 10, MX: dnsmessage.MustNewName("mail.example.com.")},
+			{Pref: 20, MX: dnsmessage.MustNewName("mail.example.net.")},
+		}))
+		Expect(apex.CAA).To(Equal([]xip.CAAResource{{Flags: 0, Tag: "issue", Value: "letsencrypt.org"}}))
+		txts, err := apex.TXT(nil, net.ParseIP("127.0.0.1"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(txts).To(Equal([]dnsmessage.TXTResource{
+			{TXT: []string{"v=spf1 mx ~all"}},
+			{TXT: []string{"two ", "strings"}},
+		}))
+
+		www := customizations["www.example.com."]
+		Expect(www.TTL).To(Equal(uint32(300)))
+		Expect(www.A).To(Equal([]dnsmessage.AResource{{A: [4]byte{10, 0, 0, 1}}, {A: [4]byte{10, 0, 0, 2}}}))
+		Expect(www.AAAA).To(HaveLen(1))
+
+		Expect(customizations["alias.example.com."].CNAME.CNAME.String()).To(Equal("www.example.com."))
+		Expect(customizations["_sip._tcp.example.com."].SRV).To(Equal([]dnsmessage.SRVResource{
+			{Priority: 10, Weight: 60, Port: 5060, Target: dnsmessage.MustNewName("sip.example.com.")},
+		}))
+		txts, err = customizations["quoted.example.com."].TXT(nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(txts[0].TXT).To(Equal([]string{`a "quoted" ; semicolon`}))
+	})
+	It("leaves the TTL to our defaults when the zone file doesn't set one", func() {
+		customizations, err := parse("a.example.com. A 10.0.0.1\n")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(customizations["a.example.com."].TTL).To(BeZero())
+	})
+	DescribeTable("rejects invalid zone files with the line number",
+		func(zoneFile string, expectedError string) {
+			_, err := parse(zoneFile)
+			Expect(err).To(MatchError(expectedError))
+		},
+		Entry("a relative name without $ORIGIN", "\nwww A 10.0.0.1\n",
+			`line 2: relative name "www" needs an $ORIGIN`),
+		Entry("an invalid address", "$ORIGIN example.com.\n\nwww A 10.0.0.256\n",
+			`line 3: www.example.com. A: invalid IPv4 address "10.0.0.256"`),
+		Entry("an IPv4 address in an AAAA record", "www.example.com. AAAA 10.0.0.1\n",
+			`line 1: www.example.com. AAAA: invalid IPv6 address "10.0.0.1"`),
+		Entry("the wrong number of fields", "www.example.com. MX mail.example.com.\n",
+			`line 1: www.example.com. MX: needs 2 fields, not 1`),
+		Entry("an unsupported record type", "www.example.com. HINFO \"PC\" \"Linux\"\n",
+			`line 1: www.example.com. HINFO: unsupported record type`),
+		Entry("an unsupported class", "www.example.com. CH A 10.0.0.1\n",
+			`line 1: unsupported class "CH"; only IN is supported`),
+		Entry("an unsupported directive", "$INCLUDE other.zone\n",
+			`line 1: unsupported directive "$INCLUDE"`),
+		Entry("an invalid $TTL", "$TTL 1fortnight\n",
+			`line 1: invalid TTL "1fortnight"`),
+		Entry("a record without an owner", "  A 10.0.0.1\n",
+			`line 1: the record has no owner name, and there's no previous one`),
+		Entry("an unterminated quoted string", "www.example.com. TXT \"oops\n",
+			`line 1: unterminated quoted string`),
+		Entry("an unclosed parenthesis", "www.example.com. TXT ( \"oops\"\n\n",
+			`line 1: "(" without ")"`),
+		Entry("a CNAME alongside other records", "www.example.com. CNAME example.com.\nwww.example.com. A 10.0.0.1\n",
+			`line 1: www.example.com. has a CNAME, so it can't have other records`),
+		Entry("an invalid name", "www..example.com. A 10.0.0.1\n",
+			`line 1: invalid name "www..example.com.": each label must be 1 to 63 characters long`),
+	)
+})
+
+var _ = Describe("SetZoneFile()", func() {
+	var x *xip.Xip
+	var zoneFilePath string
+	query := func(name string, qType dnsmessage.Type) (response dnsmessage.Message) {
+		queryBytes, err := (&dnsmessage.Message{Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: qType, Class: dnsmessage.ClassINET}}}).Pack()
+		Expect(err).ToNot(HaveOccurred())
+		responseBytes, _, err := x.QueryResponse(queryBytes, net.ParseIP("127.0.0.1"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.Unpack(responseBytes)).To(Succeed())
+		return response
+	}
+	BeforeEach(func() {
+		x, _ = xip.NewXip("file:///", []string{"ns-aws.sslip.io."}, []string{"www.zone.example.com=10.0.0.9"})
+		zoneFilePath = filepath.Join(GinkgoT().TempDir(), "sslip.io.zone")
+		Expect(os.WriteFile(zoneFilePath, []byte(`$ORIGIN sslip.io.
+$TTL 300
+@		MX	5 mx.example.com.
+		CAA	0 issue "letsencrypt.org"
+_xmpp._tcp	SRV	5 0 5222 xmpp.example.com.
+www.zone.example.com.	A	10.0.0.8
+delegated	NS	ns.example.com.
+`), 0644)).To(Succeed())
+		logmessage, err := x.SetZoneFile(zoneFilePath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(logmessage).To(Equal(`Loaded 4 names from zone file "` + zoneFilePath + `"`))
+	})
+	It("replaces the built-in records of the same names", func() {
+		response := query("sslip.io.", dnsmessage.TypeMX)
+		Expect(response.Answers).To(HaveLen(1))
+		Expect(response.Answers[0].Header.TTL).To(Equal(uint32(300)))
+		Expect(response.Answers[0].Body.(*dnsmessage.MXResource).MX.String()).To(Equal("mx.example.com."))
+		// the names it doesn't mention keep their built-in records
+		Expect(x.CNAMEResource("protonmail._domainkey.sslip.io.")).ToNot(BeNil())
+	})
+	It("answers SRV & CAA queries", func() {
+		response := query("_xmpp._tcp.sslip.io.", dnsmessage.TypeSRV)
+		Expect(response.Answers).To(HaveLen(1))
+		Expect(response.Answers[0].Body.(*dnsmessage.SRVResource).Port).To(Equal(uint16(5222)))
+		response = query("sslip.io.", xip.TypeCAA)
+		Expect(response.Answers).To(HaveLen(1))
+		Expect(response.Answers[0].Body.(*dnsmessage.UnknownResource).Data).To(Equal(append([]byte{0, 5}, "issueletsencrypt.org"...)))
+		Expect(query("127-0-0-1.sslip.io.", dnsmessage.TypeSRV).Authorities).To(HaveLen(1)) // NODATA
+	})
+	It("answers a name's NS records from the zone file", func() {
+		response := query("delegated.sslip.io.", dnsmessage.TypeNS)
+		Expect(response.Answers).To(HaveLen(1))
+		Expect(response.Answers[0].Body.(*dnsmessage.NSResource).NS.String()).To(Equal("ns.example.com."))
+	})
+	It("adds the -addresses records to the zone file's", func() {
+		Expect(x.NameToA("www.zone.example.com.")).To(Equal([]dnsmessage.AResource{{A: [4]byte{10, 0, 0, 8}}, {A: [4]byte{10, 0, 0, 9}}}))
+	})
+	When("the zone file is invalid", func() {
+		It("returns an error & keeps the previous zone file", func() {
+			Expect(os.WriteFile(zoneFilePath, []byte("$ORIGIN sslip.io.\n@ MX mx.example.com.\n"), 0644)).To(Succeed())
+			_, err := x.SetZoneFile(zoneFilePath)
+			Expect(err).To(MatchError(`zone file "` + zoneFilePath + `": line 2: sslip.io. MX: needs 2 fields, not 1`))
+			Expect(x.MXResources("sslip.io.")).To(HaveLen(1))
+		})
+	})
+})